@@ -0,0 +1,79 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/database"
+)
+
+// Project aliases store the data of a project under another, canonical name,
+// e.g. when the same repository is named differently on different machines.
+
+// getProjectAliases lists all project aliases
+// GET /api/v1/projects/aliases
+func (h *Handler) getProjectAliases(w http.ResponseWriter, r *http.Request) {
+	aliases, err := h.db.GetProjectAliases()
+	if err != nil {
+		slog.Error("failed to get project aliases", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get project aliases")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": aliases,
+	})
+}
+
+// setProjectAlias stores a project under a canonical name from now on, and
+// moves its existing data there
+// PUT /api/v1/projects/aliases/{alias} {"project": "my-app"}
+func (h *Handler) setProjectAlias(w http.ResponseWriter, r *http.Request) {
+	alias := r.PathValue("alias")
+
+	var req struct {
+		Project string `json:"project"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		bodyError(w, err, "invalid request body")
+		return
+	}
+	if req.Project == "" {
+		writeError(w, http.StatusBadRequest, "project is required")
+		return
+	}
+
+	if err := h.db.SetProjectAlias(alias, req.Project); err != nil {
+		if errors.Is(err, database.ErrAliasCycle) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("failed to set project alias", "alias", alias, "project", req.Project, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to set project alias")
+		return
+	}
+
+	h.getProjectAliases(w, r)
+}
+
+// deleteProjectAlias deletes a project alias. Data already moved to the
+// canonical project stays there.
+// DELETE /api/v1/projects/aliases/{alias}
+func (h *Handler) deleteProjectAlias(w http.ResponseWriter, r *http.Request) {
+	alias := r.PathValue("alias")
+
+	if err := h.db.DeleteProjectAlias(alias); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "alias not found")
+			return
+		}
+		slog.Error("failed to delete project alias", "alias", alias, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete project alias")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// publicPaths are API routes that never require the bearer token
+var publicPaths = map[string]bool{
+	"/api/v1/badge":        true,
+	"/api/v1/badge.json":   true,
+	"/api/v2/badge":        true,
+	"/api/v2/badge.json":   true,
+	"/api/v1/openapi.json": true,
+	"/api/v2/openapi.json": true,
+	// Editor plugins authenticate with the WakaTime API key, which the
+	// handler checks itself
+	"/api/v1/users/current/heartbeats.bulk": true,
+	"/api/v2/users/current/heartbeats.bulk": true,
+}
+
+// AuthMiddleware requires "Authorization: Bearer <api_token>" on all API routes
+// when api_token is configured. /health, /readyz and the static frontend stay open.
+// Badges stay open too, as they are meant to be embedded in public pages, and
+// so does the OpenAPI document. Heartbeat ingestion is only protected by its
+// own api_key check, as WakaTime plugins cannot send the bearer token.
+// The sync endpoint additionally keeps its own api_key check.
+func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.cfg.APIToken == "" || !strings.HasPrefix(r.URL.Path, "/api/") || publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.cfg.APIToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// badgeCacheTTL is how long a rendered badge is reused
+	badgeCacheTTL = 5 * time.Minute
+	// badgeCacheMax bounds the number of cached badges, as labels are arbitrary
+	badgeCacheMax = 100
+	// badgeMaxLabel is the longest label accepted, in characters
+	badgeMaxLabel = 64
+)
+
+// badgeColors are the named colors of shields.io
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"green":       "#97ca00",
+	"yellowgreen": "#a4a61d",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+	"blue":        "#007ec6",
+	"lightgrey":   "#9f9f9f",
+	"grey":        "#555",
+}
+
+var hexColor = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+type cachedBadge struct {
+	contentType string
+	body        []byte
+	at          time.Time
+}
+
+// badgeColor resolves a shields.io color name or hex value, falling back to blue
+func badgeColor(color string) string {
+	if c, ok := badgeColors[color]; ok {
+		return c
+	}
+	if hexColor.MatchString(color) {
+		return "#" + strings.TrimPrefix(color, "#")
+	}
+	return badgeColors["blue"]
+}
+
+// badgeTextWidth approximates the width of text in 11px Verdana
+func badgeTextWidth(text string) int {
+	return len([]rune(text))*7 + 10
+}
+
+// renderBadge draws a flat shields.io style badge
+func renderBadge(label, message, color string) []byte {
+	lw, mw := badgeTextWidth(label), badgeTextWidth(message)
+	label, message = html.EscapeString(label), html.EscapeString(message)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, lw+mw, lw, mw, label, message, color, lw/2, lw+mw/2))
+}
+
+// getBadge renders the coding time of a named range as a badge, either as an
+// SVG or, for badge.json, in the shields.io endpoint format
+// GET /api/v1/badge?range=last_7_days&label=coding&color=blue
+// GET /api/v1/badge.json?range=last_7_days
+func (h *Handler) getBadge(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rangeName := q.Get("range")
+	if rangeName == "" {
+		rangeName = "last_7_days"
+	}
+	label := q.Get("label")
+	if label == "" {
+		label = "coding"
+	}
+	if len([]rune(label)) > badgeMaxLabel {
+		writeError(w, http.StatusBadRequest, "label is too long")
+		return
+	}
+	color := q.Get("color")
+	asJSON := strings.HasSuffix(r.URL.Path, ".json")
+
+	key := strings.Join([]string{rangeName, label, color, r.URL.Path}, "\x00")
+	// The lock only guards the cache, so concurrent badges do not wait on each
+	// other's queries
+	h.badgeMu.Lock()
+	b, ok := h.badgeCache[key]
+	h.badgeMu.Unlock()
+	if ok && time.Since(b.at) < badgeCacheTTL {
+		writeBadge(w, b)
+		return
+	}
+
+	start, end, ok := h.statsRangeBounds(rangeName)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid range, expected one of last_7_days, last_30_days, last_6_months, last_year, all_time")
+		return
+	}
+	totalSeconds, err := h.db.GetAggregatedStatsTotal(start, end, "project")
+	if err != nil {
+		slog.Error("failed to get badge total", "range", rangeName, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get badge")
+		return
+	}
+
+	message := formatDuration(totalSeconds)
+	b = cachedBadge{at: time.Now()}
+	if asJSON {
+		b.contentType = "application/json"
+		b.body, _ = json.Marshal(map[string]interface{}{
+			"schemaVersion": 1,
+			"label":         label,
+			"message":       message,
+			"color":         strings.TrimPrefix(badgeColor(color), "#"),
+		})
+	} else {
+		b.contentType = "image/svg+xml"
+		b.body = renderBadge(label, message, badgeColor(color))
+	}
+
+	h.badgeMu.Lock()
+	if h.badgeCache == nil || len(h.badgeCache) >= badgeCacheMax {
+		h.badgeCache = make(map[string]cachedBadge)
+	}
+	h.badgeCache[key] = b
+	h.badgeMu.Unlock()
+	writeBadge(w, b)
+}
+
+func writeBadge(w http.ResponseWriter, b cachedBadge) {
+	w.Header().Set("Content-Type", b.contentType)
+	// Let badge proxies like GitHub's camo refresh the badge regularly
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(badgeCacheTTL.Seconds())))
+	w.WriteHeader(http.StatusOK)
+	w.Write(b.body)
+}
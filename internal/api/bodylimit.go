@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// importPaths are the routes whose bodies are limited by max_import_bytes
+// instead of max_request_bytes: dump imports, which are streamed into the
+// database
+var importPaths = map[string]bool{
+	"/api/v1/import/dump": true,
+	"/api/v2/import/dump": true,
+}
+
+// bulkPaths are the routes of bulk heartbeat uploads, which editors send after
+// being offline. They are read into memory, so they are limited by
+// maxBulkBytes, or max_request_bytes if that is larger.
+var bulkPaths = map[string]bool{
+	"/api/v1/users/current/heartbeats.bulk": true,
+	"/api/v2/users/current/heartbeats.bulk": true,
+}
+
+// BodyLimitMiddleware rejects request bodies larger than maxBytes, or
+// maxImportBytes for importPaths, with a 413. Bodies that announce their size
+// are rejected before anything is read, others once reading passes the limit.
+func BodyLimitMiddleware(maxBytes, maxImportBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maxBytes := maxBytes
+		if importPaths[r.URL.Path] {
+			maxBytes = maxImportBytes
+		} else if bulkPaths[r.URL.Path] {
+			maxBytes = max(maxBytes, maxBulkBytes)
+		}
+		if r.ContentLength > maxBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body too large, the limit is %d bytes", maxBytes))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyError answers a request whose body could not be read: 413 if it was
+// larger than the limit, otherwise 400 with message
+func bodyError(w http.ResponseWriter, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body too large, the limit is %d bytes", tooLarge.Limit))
+		return
+	}
+	writeError(w, http.StatusBadRequest, message)
+}
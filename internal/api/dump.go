@@ -0,0 +1,102 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/database"
+)
+
+// dumpTimeout bounds the transfer of a dump, which takes longer than the
+// server timeouts for large databases
+const dumpTimeout = time.Hour
+
+// exportDump streams every table as JSON Lines, e.g. to move all data to
+// another instance with importDump
+// GET /api/v1/export/dump
+func (h *Handler) exportDump(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(dumpTimeout)); err != nil {
+		slog.Warn("failed to extend write deadline for dump", "error", err)
+	}
+
+	filename := "wakatime-dump-" + h.today().Format("2006-01-02") + ".jsonl"
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	counts, err := h.db.WriteDump(w, func() { rc.Flush() })
+	if err != nil {
+		// Headers are already sent, so all we can do is log
+		slog.Error("failed to export dump", "error", err)
+		return
+	}
+	slog.Info("exported dump", "rows", counts)
+}
+
+// importDump imports a dump written by exportDump in a single transaction.
+// Rows are matched to existing ones by their natural key, so importing the
+// same dump twice is harmless.
+// POST /api/v1/import/dump?api_key=xxx
+func (h *Handler) importDump(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("api_key")), []byte(h.cfg.WakaTimeAPI)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(dumpTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		slog.Warn("failed to extend read deadline for dump", "error", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		slog.Warn("failed to extend write deadline for dump", "error", err)
+	}
+
+	// The dump is uploaded to a temporary file first, so a slow client does
+	// not hold the import's transaction open
+	f, err := os.CreateTemp("", "wakatime-dump-*.jsonl")
+	if err != nil {
+		slog.Error("failed to create temporary file for dump", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to import dump")
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.Copy(f, r.Body); err != nil {
+		bodyError(w, err, "failed to read dump")
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		slog.Error("failed to rewind dump", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to import dump")
+		return
+	}
+
+	counts, err := h.db.ImportDump(f)
+	if errors.Is(err, database.ErrInvalidDump) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("failed to import dump", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to import dump: "+err.Error())
+		return
+	}
+
+	var total int
+	for _, n := range counts {
+		total += n
+	}
+	slog.Info("imported dump", "rows", counts)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "dump imported",
+		"rows":    counts,
+		"total":   total,
+	})
+}
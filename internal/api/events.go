@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/sync"
+)
+
+// sseKeepAliveInterval is how often a comment is sent to keep idle connections open
+const sseKeepAliveInterval = 30 * time.Second
+
+// getSyncEvents streams sync events as Server-Sent Events
+// GET /api/v1/sync/events
+func (h *Handler) getSyncEvents(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe, err := h.syncer.Subscribe()
+	if errors.Is(err, sync.ErrTooManySubscribers) {
+		writeError(w, http.StatusServiceUnavailable, "too many event subscribers, try again later")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to subscribe to sync events", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to subscribe to sync events")
+		return
+	}
+	defer unsubscribe()
+
+	// The server write timeout would otherwise cut the stream off
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Warn("failed to clear write deadline for event stream", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	ticker := time.NewTicker(sseKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			rc.Flush()
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				slog.Error("failed to marshal sync event", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportWriter writes records either as CSV rows or as elements of a JSON
+// array, flushing to the client as it goes so large exports are not buffered.
+type exportWriter struct {
+	w       http.ResponseWriter
+	format  string
+	header  []string
+	csv     *csv.Writer
+	written int
+}
+
+func newExportWriter(w http.ResponseWriter, format string, header []string) *exportWriter {
+	ew := &exportWriter{w: w, format: format, header: header}
+	if format == "csv" {
+		ew.csv = csv.NewWriter(w)
+		ew.csv.Write(header)
+	} else {
+		w.Write([]byte("["))
+	}
+	return ew
+}
+
+// write emits a single record. values must be in the same order as the header.
+func (ew *exportWriter) write(values ...interface{}) error {
+	if ew.format == "csv" {
+		record := make([]string, len(values))
+		for i, v := range values {
+			record[i] = formatCSVValue(v)
+		}
+		return ew.csv.Write(record)
+	}
+
+	obj := make(map[string]interface{}, len(values))
+	for i, v := range values {
+		obj[ew.header[i]] = v
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if ew.written > 0 {
+		ew.w.Write([]byte(","))
+	}
+	ew.written++
+	_, err = ew.w.Write(b)
+	return err
+}
+
+// flush pushes buffered output to the client
+func (ew *exportWriter) flush() {
+	if ew.csv != nil {
+		ew.csv.Flush()
+	}
+	if f, ok := ew.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (ew *exportWriter) close() {
+	if ew.format != "csv" {
+		ew.w.Write([]byte("]\n"))
+	}
+	ew.flush()
+}
+
+func formatCSVValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(val)
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// exportData streams durations, summaries or heartbeats for a date range
+// GET /api/v1/export?type=durations&start=2024-01-01&end=2024-01-31&format=csv
+func (h *Handler) exportData(w http.ResponseWriter, r *http.Request) {
+	exportType := r.URL.Query().Get("type")
+	if exportType == "" {
+		exportType = "summaries"
+	}
+	if exportType != "durations" && exportType != "summaries" && exportType != "heartbeats" {
+		writeError(w, http.StatusBadRequest, "invalid type, use durations, summaries or heartbeats")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		writeError(w, http.StatusBadRequest, "invalid format, use csv or json")
+		return
+	}
+
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+	if startStr == "" || endStr == "" {
+		// Default to last 30 days
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -30).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, "start date must be before end date")
+		return
+	}
+
+	filename := fmt.Sprintf("wakatime-%s-%s-%s.%s", exportType, startStr, endStr, format)
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	switch exportType {
+	case "summaries":
+		err = h.exportSummaries(w, format, start, end)
+	case "durations":
+		err = h.exportDurations(w, format, start, end)
+	case "heartbeats":
+		err = h.exportHeartbeats(w, format, start, end)
+	}
+	if err != nil {
+		// Headers are already sent, so all we can do is log
+		slog.Error("failed to export data", "type", exportType, "error", err)
+	}
+}
+
+func (h *Handler) exportSummaries(w http.ResponseWriter, format string, start, end time.Time) error {
+	summaries, err := h.db.GetDaySummaries(start, end)
+	if err != nil {
+		return err
+	}
+
+	ew := newExportWriter(w, format, []string{
+		"date", "total_seconds", "text", "ai_additions", "ai_deletions", "human_additions", "human_deletions",
+	})
+	defer ew.close()
+	for _, s := range summaries {
+		if err := ew.write(
+			s.Day.Format("2006-01-02"), s.TotalSeconds, formatDuration(s.TotalSeconds),
+			s.AIAdditions, s.AIDeletions, s.HumanAdditions, s.HumanDeletions,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) exportDurations(w http.ResponseWriter, format string, start, end time.Time) error {
+	ew := newExportWriter(w, format, []string{
+		"date", "project", "time", "duration", "dependencies",
+		"ai_additions", "ai_deletions", "human_additions", "human_deletions",
+	})
+	defer ew.close()
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		durations, err := h.db.GetDurationsByDay(d)
+		if err != nil {
+			return err
+		}
+		for _, dur := range durations {
+			if err := ew.write(
+				d.Format("2006-01-02"), dur.Project, dur.StartTime, dur.Duration, dur.Dependencies,
+				dur.AIAdditions, dur.AIDeletions, dur.HumanAdditions, dur.HumanDeletions,
+			); err != nil {
+				return err
+			}
+		}
+		ew.flush()
+	}
+	return nil
+}
+
+func (h *Handler) exportHeartbeats(w http.ResponseWriter, format string, start, end time.Time) error {
+	machines, err := h.db.GetMachines()
+	if err != nil {
+		return err
+	}
+	labels := machineLabels(machines)
+
+	ew := newExportWriter(w, format, []string{
+		"date", "entity", "type", "category", "time", "project", "branch", "language",
+		"is_write", "machine_name_id", "machine", "lines", "lineno", "cursorpos",
+	})
+	defer ew.close()
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		heartbeats, err := h.db.GetHeartbeatsByDay(d)
+		if err != nil {
+			return err
+		}
+		for _, hb := range heartbeats {
+			if err := ew.write(
+				d.Format("2006-01-02"), hb.Entity, hb.Type, hb.Category, hb.Time, hb.Project, hb.Branch, hb.Language,
+				hb.IsWrite, hb.MachineID, machineLabel(labels, hb.MachineID), hb.Lines, hb.LineNo, hb.CursorPos,
+			); err != nil {
+				return err
+			}
+		}
+		ew.flush()
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/database"
+)
+
+// Goals are defined in this app and evaluated against the synced data. The
+// goals defined on WakaTime are listed separately, at /api/v1/goals/wakatime.
+
+type goalRequest struct {
+	Title         string  `json:"title"`
+	Type          string  `json:"type"`
+	TargetSeconds float64 `json:"target_seconds"`
+	Project       string  `json:"project"`
+	Language      string  `json:"language"`
+}
+
+// toGoal validates the request and fills in defaults
+func (req *goalRequest) toGoal() (*database.Goal, error) {
+	if req.Type == "" {
+		req.Type = database.GoalTypeDailySeconds
+	}
+	if req.Type != database.GoalTypeDailySeconds {
+		return nil, errors.New("invalid type, use daily_seconds")
+	}
+	if req.TargetSeconds <= 0 {
+		return nil, errors.New("target_seconds must be positive")
+	}
+	if req.Project != "" && req.Language != "" {
+		return nil, errors.New("a goal can filter by project or language, not both")
+	}
+	return &database.Goal{
+		Title:         req.Title,
+		Type:          req.Type,
+		TargetSeconds: req.TargetSeconds,
+		Project:       req.Project,
+		Language:      req.Language,
+	}, nil
+}
+
+func parseGoalID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(r.PathValue("id"), 10, 64)
+}
+
+// getGoals lists all goals
+// GET /api/v1/goals
+func (h *Handler) getGoals(w http.ResponseWriter, r *http.Request) {
+	goals, err := h.db.GetGoals()
+	if err != nil {
+		slog.Error("failed to get goals", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get goals")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": goals,
+	})
+}
+
+// createGoal creates a goal
+// POST /api/v1/goals {"title": "2h a day", "type": "daily_seconds", "target_seconds": 7200}
+func (h *Handler) createGoal(w http.ResponseWriter, r *http.Request) {
+	var req goalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		bodyError(w, err, "invalid request body")
+		return
+	}
+	goal, err := req.toGoal()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.db.CreateGoal(goal); err != nil {
+		slog.Error("failed to create goal", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to create goal")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"data": goal,
+	})
+}
+
+// getGoal returns a single goal
+// GET /api/v1/goals/{id}
+func (h *Handler) getGoal(w http.ResponseWriter, r *http.Request) {
+	id, err := parseGoalID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid goal id")
+		return
+	}
+
+	goal, err := h.db.GetGoal(id)
+	if err != nil {
+		slog.Error("failed to get goal", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get goal")
+		return
+	}
+	if goal == nil {
+		writeError(w, http.StatusNotFound, "goal not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": goal,
+	})
+}
+
+// updateGoal replaces a goal
+// PUT /api/v1/goals/{id}
+func (h *Handler) updateGoal(w http.ResponseWriter, r *http.Request) {
+	id, err := parseGoalID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid goal id")
+		return
+	}
+
+	var req goalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		bodyError(w, err, "invalid request body")
+		return
+	}
+	goal, err := req.toGoal()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	goal.ID = id
+
+	if err := h.db.UpdateGoal(goal); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "goal not found")
+			return
+		}
+		slog.Error("failed to update goal", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to update goal")
+		return
+	}
+
+	// Re-read to return the stored goal including created_at
+	h.getGoal(w, r)
+}
+
+// deleteGoal deletes a goal
+// DELETE /api/v1/goals/{id}
+func (h *Handler) deleteGoal(w http.ResponseWriter, r *http.Request) {
+	id, err := parseGoalID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid goal id")
+		return
+	}
+
+	if err := h.db.DeleteGoal(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "goal not found")
+			return
+		}
+		slog.Error("failed to delete goal", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete goal")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getGoalProgress evaluates a goal for every day of a date range.
+// Days with less time than min_session_seconds (defaults to the configured
+// value) count as zero.
+// GET /api/v1/goals/{id}/progress?start=2024-01-01&end=2024-01-31&min_session_seconds=300
+func (h *Handler) getGoalProgress(w http.ResponseWriter, r *http.Request) {
+	id, err := parseGoalID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid goal id")
+		return
+	}
+
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -30).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, "start date must be before end date")
+		return
+	}
+
+	minSeconds := h.cfg.MinSessionSeconds
+	if minStr := r.URL.Query().Get("min_session_seconds"); minStr != "" {
+		n, err := strconv.Atoi(minStr)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid min_session_seconds")
+			return
+		}
+		minSeconds = n
+	}
+
+	goal, err := h.db.GetGoal(id)
+	if err != nil {
+		slog.Error("failed to get goal", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get goal")
+		return
+	}
+	if goal == nil {
+		writeError(w, http.StatusNotFound, "goal not found")
+		return
+	}
+
+	progress, err := h.db.EvaluateGoal(goal, start, end, float64(minSeconds))
+	if err != nil {
+		slog.Error("failed to evaluate goal", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to evaluate goal")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"goal":                goal,
+		"data":                progress.Days,
+		"current_streak":      progress.CurrentStreak,
+		"longest_streak":      progress.LongestStreak,
+		"days_met":            progress.DaysMet,
+		"min_session_seconds": minSeconds,
+		"start":               startStr,
+		"end":                 endStr,
+	})
+}
@@ -1,53 +1,186 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
 	"log/slog"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	gosync "sync"
 	"time"
 
 	"github.com/charlie0129/wakatime-sync-go/internal/config"
 	"github.com/charlie0129/wakatime-sync-go/internal/database"
 	"github.com/charlie0129/wakatime-sync-go/internal/sync"
+	"github.com/charlie0129/wakatime-sync-go/internal/version"
+	"github.com/charlie0129/wakatime-sync-go/internal/wakatime"
 )
 
 type Handler struct {
 	cfg    *config.Config
 	db     *database.DB
 	syncer *sync.Syncer
+
+	// startedAt is when the server started, for the uptime in /health
+	startedAt time.Time
+
+	// activeStatus caches the response of getActiveStatus. activeFetch is
+	// closed when the running fetch of a new status is done, so concurrent
+	// requests wait for it instead of fetching too.
+	activeMu       gosync.Mutex
+	activeStatus   map[string]interface{}
+	activeCachedAt time.Time
+	activeFetch    chan struct{}
+
+	// badgeCache holds recently rendered badges by their query
+	badgeMu    gosync.Mutex
+	badgeCache map[string]cachedBadge
+
+	// wakatimeErr is the result of the last check of the WakaTime connection
+	// before a sync, reused until wakatimeCheckTTL has passed
+	wakatimeMu        gosync.Mutex
+	wakatimeErr       error
+	wakatimeCheckedAt time.Time
 }
 
 func NewHandler(cfg *config.Config, db *database.DB, syncer *sync.Syncer) *Handler {
 	return &Handler{
-		cfg:    cfg,
-		db:     db,
-		syncer: syncer,
+		cfg:       cfg,
+		db:        db,
+		syncer:    syncer,
+		startedAt: time.Now(),
 	}
 }
 
-func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+// RegisterRoutes registers the API on mux, and serves the frontend from static
+// (nil to serve the API only)
+func (h *Handler) RegisterRoutes(mux *http.ServeMux, static fs.FS) {
 	// API routes that resemble official WakaTime API
 	mux.HandleFunc("GET /api/v1/users/current/durations", h.getDurations)
 	mux.HandleFunc("GET /api/v1/users/current/heartbeats", h.getHeartbeats)
-	mux.HandleFunc("GET /api/v1/users/current/summaries", h.getSummaries)
+	mux.HandleFunc("POST /api/v1/users/current/heartbeats.bulk", h.ingestHeartbeats)
+	mux.HandleFunc("GET /api/v1/heartbeats/search", h.searchHeartbeats)
+	mux.HandleFunc("GET /api/v1/users/current/summaries", withETag(h.getSummaries))
 	mux.HandleFunc("GET /api/v1/users/current/projects", h.getProjects)
+	mux.HandleFunc("DELETE /api/v1/users/current/projects/{name}", h.deleteProject)
+	mux.HandleFunc("GET /api/v1/users/current/machine_names", h.getMachineNames)
+	mux.HandleFunc("GET /api/v1/users/current/stats/{range}", h.getStatsForRange)
+	mux.HandleFunc("GET /api/v1/badge", h.getBadge)
+	mux.HandleFunc("GET /api/v1/badge.json", h.getBadge)
+	mux.HandleFunc("GET /api/v1/projects/{name}/languages", h.getProjectLanguages)
+	mux.HandleFunc("GET /api/v1/projects/aliases", h.getProjectAliases)
+	mux.HandleFunc("PUT /api/v1/projects/aliases/{alias}", h.setProjectAlias)
+	mux.HandleFunc("DELETE /api/v1/projects/aliases/{alias}", h.deleteProjectAlias)
+
+	// Current WakaTime user (also serves as a connection test)
+	mux.HandleFunc("GET /api/v1/user", h.getUser)
+
+	// Goals defined on WakaTime, as of the last sync
+	mux.HandleFunc("GET /api/v1/goals/wakatime", h.getWakaTimeGoals)
+
+	// Goals defined in this app, evaluated against the synced data
+	mux.HandleFunc("GET /api/v1/goals", h.getGoals)
+	mux.HandleFunc("POST /api/v1/goals", h.createGoal)
+	mux.HandleFunc("GET /api/v1/goals/{id}", h.getGoal)
+	mux.HandleFunc("PUT /api/v1/goals/{id}", h.updateGoal)
+	mux.HandleFunc("DELETE /api/v1/goals/{id}", h.deleteGoal)
+	mux.HandleFunc("GET /api/v1/goals/{id}/progress", h.getGoalProgress)
+
+	// Whether the user is coding right now
+	mux.HandleFunc("GET /api/v1/status/active", h.getActiveStatus)
 
 	// Additional convenience endpoints
-	mux.HandleFunc("GET /api/v1/stats/daily", h.getDailyStats)
+	mux.HandleFunc("GET /api/v1/stats/daily", withETag(h.getDailyStats))
 	mux.HandleFunc("GET /api/v1/stats/range", h.getRangeStats)
 	mux.HandleFunc("GET /api/v1/stats/years", h.getAvailableYears)
-	mux.HandleFunc("GET /api/v1/stats/yearly", h.getYearlyActivity)
+	mux.HandleFunc("GET /api/v1/stats/yearly", withETag(h.getYearlyActivity))
+	mux.HandleFunc("GET /api/v1/stats/lines", h.getLineStats)
+	mux.HandleFunc("GET /api/v1/stats/hourly", h.getHourlyStats)
+	mux.HandleFunc("GET /api/v1/stats/weekdays", h.getWeekdayStats)
+	mux.HandleFunc("GET /api/v1/stats/weekly", h.getWeeklyStats)
+	mux.HandleFunc("GET /api/v1/stats/series", withETag(h.getStatsSeries))
+	mux.HandleFunc("GET /api/v1/stats/alltime", h.getAllTimeStats)
+	mux.HandleFunc("GET /api/v1/stats/averages", h.getAverageStats)
+	mux.HandleFunc("GET /api/v1/stats/compare", h.getProjectComparison)
+	mux.HandleFunc("GET /api/v1/stats/languages", h.getTopStats("language"))
+	mux.HandleFunc("GET /api/v1/stats/languages/{name}/activity", h.getLanguageActivity)
+	mux.HandleFunc("GET /api/v1/stats/editors", h.getTopStats("editor"))
+	mux.HandleFunc("GET /api/v1/stats/projects", h.getTopStats("project"))
+	mux.HandleFunc("GET /api/v1/activity", withETag(h.getActivity))
+	mux.HandleFunc("GET /api/v1/activity/years", h.getActivityYears)
+
+	// Export endpoints
+	mux.HandleFunc("GET /api/v1/export", h.exportData)
+	mux.HandleFunc("GET /api/v1/export/dump", h.exportDump)
+	mux.HandleFunc("POST /api/v1/import/dump", h.importDump)
 
 	// Sync endpoints
 	mux.HandleFunc("POST /api/v1/sync", h.triggerSync)
 	mux.HandleFunc("GET /api/v1/sync/status", h.getSyncStatus)
+	mux.HandleFunc("GET /api/v1/sync/history", h.getSyncHistory)
+	mux.HandleFunc("GET /api/v1/sync/events", h.getSyncEvents)
+	mux.HandleFunc("GET /api/v1/sync/gaps", h.getSyncGaps)
+	mux.HandleFunc("POST /api/v1/recompute", h.recomputeDay)
+
+	// Read-only SQL for power users
+	mux.HandleFunc("POST /api/v1/query", h.runQuery)
+
+	// Machine-readable description of the API
+	mux.HandleFunc("GET /api/v1/openapi.json", h.getOpenAPI)
+
+	// All of the above under /api/v2, wrapped in a consistent {"data": ...} envelope
+	mux.Handle("/api/v2/", h.v2Handler(mux))
 
 	// Health check
 	mux.HandleFunc("GET /health", h.healthCheck)
+	mux.HandleFunc("GET /readyz", h.readyCheck)
 
-	// Serve static files from web/dist (for production)
-	mux.Handle("/", http.FileServer(http.Dir("web/dist")))
+	// Unknown API routes get a JSON error instead of the frontend
+	mux.Handle("/api/", apiNotFound(mux))
+
+	// Serve the frontend
+	mux.Handle("/", staticHandler(static))
+}
+
+// apiMethods are the methods API routes are registered with
+var apiMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// apiNotFound answers API requests no route matches with a JSON error: 405
+// with an Allow header listing the supported methods if the path has routes
+// for other methods, e.g. POST to a GET-only endpoint, 404 otherwise. Without
+// it, they would reach the frontend.
+func apiNotFound(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range apiMethods {
+			probe := *r
+			probe.Method = method
+			if _, pattern := mux.Handler(&probe); pattern != "/api/" {
+				allowed = append(allowed, method)
+				// GET routes also answer HEAD
+				if method == http.MethodGet {
+					allowed = append(allowed, http.MethodHead)
+				}
+			}
+		}
+		if len(allowed) > 0 {
+			// Preflight requests are answered by the CORS middleware
+			allowed = append(allowed, http.MethodOptions)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeError(w, http.StatusNotFound, "not found")
+	})
 }
 
 // --- Response helpers ---
@@ -71,14 +204,68 @@ func parseDate(s string) (time.Time, error) {
 	return time.Parse("2006-01-02", s)
 }
 
+// today returns the current date in the configured timezone. Days are stored
+// as dates, so like parseDate it returns the date at midnight UTC.
+func (h *Handler) today() time.Time {
+	today, _ := parseDate(time.Now().In(h.cfg.GetTimezone()).Format("2006-01-02"))
+	return today
+}
+
+// parsePagination reads the limit and offset query params. limit falls back to
+// defaultLimit when absent and is capped at maxLimit.
+func parsePagination(r *http.Request, defaultLimit, maxLimit int) (limit, offset int, err error) {
+	limit = defaultLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return 0, 0, errors.New("invalid limit")
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+	}
+
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("invalid offset")
+		}
+	}
+
+	return limit, offset, nil
+}
+
 // --- Handlers ---
 
-// getDurations returns durations for a specific day
+// getDurations returns durations for a specific day, or for every day of a range
 // GET /api/v1/users/current/durations?date=2024-01-01
+// GET /api/v1/users/current/durations?start=2024-01-01&end=2024-01-07
+// Pass merge=true to merge overlapping durations of the same project.
+// Pass slice_by=language (or entity, dependencies, os, editor, category,
+// machine) to fetch a single day's durations sliced that way from WakaTime.
 func (h *Handler) getDurations(w http.ResponseWriter, r *http.Request) {
+	project := r.URL.Query().Get("project")
+	merge := r.URL.Query().Get("merge") == "true"
+	sliceBy := r.URL.Query().Get("slice_by")
+	if sliceBy != "" && !wakatime.ValidDurationSliceBy(sliceBy) {
+		writeError(w, http.StatusBadRequest, "invalid slice_by, expected one of "+strings.Join(wakatime.DurationSliceBy, ", "))
+		return
+	}
+
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+	if startStr != "" || endStr != "" {
+		if sliceBy != "" {
+			writeError(w, http.StatusBadRequest, "slice_by is only supported for a single date")
+			return
+		}
+		h.getDurationsRange(w, startStr, endStr, project, merge)
+		return
+	}
+
 	dateStr := r.URL.Query().Get("date")
 	if dateStr == "" {
-		dateStr = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+		dateStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
 	}
 
 	day, err := parseDate(dateStr)
@@ -87,30 +274,27 @@ func (h *Handler) getDurations(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	project := r.URL.Query().Get("project")
-
 	var data interface{}
-	if project != "" {
+	if sliceBy != "" {
+		// Synced durations are not sliced by these, so ask WakaTime
+		durations, err := h.syncer.GetSlicedDurations(r.Context(), day, project, sliceBy)
+		if err != nil {
+			slog.Error("failed to get sliced durations", "slice_by", sliceBy, "error", err)
+			writeWakaTimeError(w, err)
+			return
+		}
+		if durations == nil {
+			durations = []wakatime.DurationData{}
+		}
+		data = durations
+	} else if project != "" {
 		durations, err := h.db.GetProjectDurationsByDay(day, project)
 		if err != nil {
 			slog.Error("failed to get project durations", "error", err)
 			writeError(w, http.StatusInternalServerError, "failed to get durations")
 			return
 		}
-		// Format response like WakaTime API
-		formatted := make([]map[string]interface{}, len(durations))
-		for i, d := range durations {
-			formatted[i] = map[string]interface{}{
-				"project":  d.Project,
-				"time":     d.StartTime,
-				"duration": d.Duration,
-				"entity":   d.Entity,
-				"language": d.Language,
-				"branch":   d.Branch,
-				"type":     d.Type,
-			}
-		}
-		data = formatted
+		data = formatProjectDurations(durations)
 	} else {
 		durations, err := h.db.GetDurationsByDay(day)
 		if err != nil {
@@ -118,36 +302,192 @@ func (h *Handler) getDurations(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusInternalServerError, "failed to get durations")
 			return
 		}
-		// Format response like WakaTime API
-		formatted := make([]map[string]interface{}, len(durations))
-		for i, d := range durations {
-			formatted[i] = map[string]interface{}{
-				"project":  d.Project,
-				"time":     d.StartTime,
-				"duration": d.Duration,
+		if merge {
+			durations = mergeOverlappingDurations(durations)
+		}
+		data = formatDurations(durations)
+	}
+
+	writeJSON(w, http.StatusOK, h.durationsDay(day, data))
+}
+
+// maxDurationsRangeDays is the longest range of durations returned at once
+const maxDurationsRangeDays = 31
+
+// getDurationsRange returns the durations of every day from start to end, each
+// day in the same format as a single-day request
+func (h *Handler) getDurationsRange(w http.ResponseWriter, startStr, endStr, project string, merge bool) {
+	if startStr == "" || endStr == "" {
+		writeError(w, http.StatusBadRequest, "both start and end are required")
+		return
+	}
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format, use YYYY-MM-DD")
+		return
+	}
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format, use YYYY-MM-DD")
+		return
+	}
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, "start must not be after end")
+		return
+	}
+	days := int(end.Sub(start).Hours()/24) + 1
+	if days > maxDurationsRangeDays {
+		writeError(w, http.StatusBadRequest, "date range too large, at most "+strconv.Itoa(maxDurationsRangeDays)+" days")
+		return
+	}
+
+	byDay := make(map[string]interface{}, days)
+	if project != "" {
+		durations, err := h.db.GetProjectDurationsByRange(start, end, project)
+		if err != nil {
+			slog.Error("failed to get project durations", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to get durations")
+			return
+		}
+		grouped := make(map[string][]database.ProjectDuration)
+		for _, d := range durations {
+			key := d.Day.Format("2006-01-02")
+			grouped[key] = append(grouped[key], d)
+		}
+		for key, ds := range grouped {
+			byDay[key] = formatProjectDurations(ds)
+		}
+	} else {
+		durations, err := h.db.GetDurationsByRange(start, end)
+		if err != nil {
+			slog.Error("failed to get durations", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to get durations")
+			return
+		}
+		grouped := make(map[string][]database.Duration)
+		for _, d := range durations {
+			key := d.Day.Format("2006-01-02")
+			grouped[key] = append(grouped[key], d)
+		}
+		for key, ds := range grouped {
+			if merge {
+				ds = mergeOverlappingDurations(ds)
 			}
+			byDay[key] = formatDurations(ds)
+		}
+	}
+
+	result := make([]map[string]interface{}, 0, days)
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		data, ok := byDay[day.Format("2006-01-02")]
+		if !ok {
+			data = []map[string]interface{}{}
 		}
-		data = formatted
+		result = append(result, h.durationsDay(day, data))
 	}
 
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":  result,
+		"start": startStr,
+		"end":   endStr,
+	})
+}
+
+// durationsDay wraps a day's durations like WakaTime's durations API
+func (h *Handler) durationsDay(day time.Time, data interface{}) map[string]interface{} {
 	loc := h.cfg.GetTimezone()
 	startOfDay := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
 	endOfDay := startOfDay.Add(24*time.Hour - time.Second)
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
+	return map[string]interface{}{
 		"data":     data,
 		"start":    startOfDay.Format(time.RFC3339),
 		"end":      endOfDay.Format(time.RFC3339),
 		"timezone": loc.String(),
-	})
+	}
+}
+
+// formatDurations formats durations like WakaTime's API
+func formatDurations(durations []database.Duration) []map[string]interface{} {
+	formatted := make([]map[string]interface{}, len(durations))
+	for i, d := range durations {
+		formatted[i] = map[string]interface{}{
+			"project":         d.Project,
+			"time":            d.StartTime,
+			"duration":        d.Duration,
+			"ai_additions":    d.AIAdditions,
+			"ai_deletions":    d.AIDeletions,
+			"human_additions": d.HumanAdditions,
+			"human_deletions": d.HumanDeletions,
+		}
+	}
+	return formatted
+}
+
+// formatProjectDurations formats project durations like WakaTime's API
+func formatProjectDurations(durations []database.ProjectDuration) []map[string]interface{} {
+	formatted := make([]map[string]interface{}, len(durations))
+	for i, d := range durations {
+		formatted[i] = map[string]interface{}{
+			"project":  d.Project,
+			"time":     d.StartTime,
+			"duration": d.Duration,
+			"entity":   d.Entity,
+			"language": d.Language,
+			"branch":   d.Branch,
+			"type":     d.Type,
+		}
+	}
+	return formatted
+}
+
+// mergeOverlappingDurations merges durations of the same project that overlap
+// or touch, which WakaTime returns when coding on several machines at once.
+// Line change counts are summed; other fields are taken from the earliest
+// duration. The result is ordered by start time.
+func mergeOverlappingDurations(durations []database.Duration) []database.Duration {
+	sorted := make([]database.Duration, len(durations))
+	copy(sorted, durations)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].StartTime < sorted[j].StartTime })
+
+	merged := []database.Duration{}
+	// Index in merged of the latest span of each project
+	last := make(map[string]int)
+	for _, d := range sorted {
+		if i, ok := last[d.Project]; ok {
+			cur := &merged[i]
+			if d.StartTime <= cur.StartTime+cur.Duration {
+				if end := d.StartTime + d.Duration; end > cur.StartTime+cur.Duration {
+					cur.Duration = end - cur.StartTime
+				}
+				cur.AIAdditions += d.AIAdditions
+				cur.AIDeletions += d.AIDeletions
+				cur.HumanAdditions += d.HumanAdditions
+				cur.HumanDeletions += d.HumanDeletions
+				continue
+			}
+		}
+		merged = append(merged, d)
+		last[d.Project] = len(merged) - 1
+	}
+	return merged
 }
 
-// getHeartbeats returns heartbeats for a specific day
-// GET /api/v1/users/current/heartbeats?date=2024-01-01
+const (
+	defaultHeartbeatsLimit = 1000
+	maxHeartbeatsLimit     = 10000
+)
+
+// getHeartbeats returns heartbeats for a specific day, paginated
+// GET /api/v1/users/current/heartbeats?date=2024-01-01&limit=1000&offset=0
+//
+// limit defaults to 1000 and is capped at 10000. The response includes the
+// total number of heartbeats for the day and the offset of the next page in
+// "next" (null when there are no more pages).
 func (h *Handler) getHeartbeats(w http.ResponseWriter, r *http.Request) {
 	dateStr := r.URL.Query().Get("date")
 	if dateStr == "" {
-		dateStr = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+		dateStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
 	}
 
 	day, err := parseDate(dateStr)
@@ -156,7 +496,20 @@ func (h *Handler) getHeartbeats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	heartbeats, err := h.db.GetHeartbeatsByDay(day)
+	limit, offset, err := parsePagination(r, defaultHeartbeatsLimit, maxHeartbeatsLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	total, err := h.db.CountHeartbeatsByDay(day)
+	if err != nil {
+		slog.Error("failed to count heartbeats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get heartbeats")
+		return
+	}
+
+	heartbeats, err := h.db.GetHeartbeatsByDayPaged(day, limit, offset)
 	if err != nil {
 		slog.Error("failed to get heartbeats", "error", err)
 		writeError(w, http.StatusInternalServerError, "failed to get heartbeats")
@@ -182,28 +535,90 @@ func (h *Handler) getHeartbeats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	var next interface{}
+	if offset+len(heartbeats) < total {
+		next = offset + len(heartbeats)
+	}
+
 	loc := h.cfg.GetTimezone()
 	startOfDay := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
 	endOfDay := startOfDay.Add(24*time.Hour - time.Second)
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
 		"data":     formatted,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+		"next":     next,
 		"start":    startOfDay.Format(time.RFC3339),
 		"end":      endOfDay.Format(time.RFC3339),
 		"timezone": loc.String(),
 	})
 }
 
-// getSummaries returns summaries for a date range
+// searchHeartbeats finds the days on which files matching q were worked on
+// GET /api/v1/heartbeats/search?q=main.go&start=2024-01-01&end=2024-01-31
+func (h *Handler) searchHeartbeats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		endStr = h.today().Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -30).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	days, err := h.db.SearchHeartbeats(query, start, end)
+	if err != nil {
+		slog.Error("failed to search heartbeats", "query", query, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to search heartbeats")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":  days,
+		"q":     query,
+		"start": startStr,
+		"end":   endStr,
+	})
+}
+
+// getSummaries returns summaries for a date range, or with range=today for
+// today so far
 // GET /api/v1/users/current/summaries?start=2024-01-01&end=2024-01-07
+// GET /api/v1/users/current/summaries?range=today
 func (h *Handler) getSummaries(w http.ResponseWriter, r *http.Request) {
 	startStr := r.URL.Query().Get("start")
 	endStr := r.URL.Query().Get("end")
 
-	if startStr == "" || endStr == "" {
+	if rangeStr := r.URL.Query().Get("range"); rangeStr != "" {
+		if !strings.EqualFold(rangeStr, "today") {
+			writeError(w, http.StatusBadRequest, "invalid range, expected today")
+			return
+		}
+		startStr = h.today().Format("2006-01-02")
+		endStr = startStr
+	} else if startStr == "" || endStr == "" {
 		// Default to last 7 days
-		endStr = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-		startStr = time.Now().AddDate(0, 0, -7).Format("2006-01-02")
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -7).Format("2006-01-02")
 	}
 
 	start, err := parseDate(startStr)
@@ -223,12 +638,26 @@ func (h *Handler) getSummaries(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Colors and machine names are the same for every day
+	projectColors, err := h.db.GetProjectColors()
+	if err != nil {
+		slog.Error("failed to get project colors", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get summaries")
+		return
+	}
+	machines, err := h.db.GetMachines()
+	if err != nil {
+		slog.Error("failed to get machines", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get summaries")
+		return
+	}
+
 	// Build daily summaries
 	summaries := []map[string]interface{}{}
 	var cumulativeSeconds float64
 
 	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
-		dayData := h.buildDaySummary(d)
+		dayData := h.buildDaySummary(d, projectColors, machines)
 		summaries = append(summaries, dayData)
 
 		if grandTotal, ok := dayData["grand_total"].(map[string]interface{}); ok {
@@ -252,7 +681,8 @@ func (h *Handler) getSummaries(w http.ResponseWriter, r *http.Request) {
 		"cumulative_total": map[string]interface{}{
 			"seconds": cumulativeSeconds,
 			"text":    formatDuration(cumulativeSeconds),
-			"digital": formatDigital(cumulativeSeconds),
+			"digital": formatDigital(cumulativeSeconds, h.cfg.DigitalFormat),
+			"decimal": formatDecimal(cumulativeSeconds),
 		},
 		"daily_average": map[string]interface{}{
 			"seconds":                 avgSeconds,
@@ -264,39 +694,82 @@ func (h *Handler) getSummaries(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *Handler) buildDaySummary(day time.Time) map[string]interface{} {
+// buildDaySummary builds the summary of a day from the synced data. Days that
+// were not synced yet, like today, are computed from the stored heartbeats,
+// e.g. those appended by today_sync_interval. Such days and days that are not
+// over yet are flagged as partial, as their data can still change.
+// projectColors and knownMachines name the projects and machines of the day.
+func (h *Handler) buildDaySummary(day time.Time, projectColors map[string]string, knownMachines []database.Machine) map[string]interface{} {
 	summary, _ := h.db.GetDaySummary(day)
 	totalSeconds := float64(0)
+	var aiAdditions, aiDeletions, humanAdditions, humanDeletions int
 	if summary != nil {
 		totalSeconds = summary.TotalSeconds
+		aiAdditions = summary.AIAdditions
+		aiDeletions = summary.AIDeletions
+		humanAdditions = summary.HumanAdditions
+		humanDeletions = summary.HumanDeletions
+	}
+
+	partial := !day.Before(h.today())
+	dayStats := func(statType string) []database.DayStats {
+		stats, _ := h.db.GetDayStatsByDayAndType(day, statType)
+		return stats
+	}
+	if summary == nil {
+		total, stats, err := h.syncer.ComputeDay(day)
+		if err != nil && !errors.Is(err, sync.ErrNoHeartbeats) {
+			slog.Error("failed to compute day from heartbeats", "date", day.Format("2006-01-02"), "error", err)
+		}
+		if err == nil {
+			totalSeconds = total
+			partial = true
+			sort.SliceStable(stats, func(i, j int) bool { return stats[i].TotalSeconds > stats[j].TotalSeconds })
+			byType := make(map[string][]database.DayStats)
+			for _, s := range stats {
+				byType[s.Type] = append(byType[s.Type], s)
+			}
+			// Editors, operating systems and dependencies are not in heartbeats
+			dayStats = func(statType string) []database.DayStats { return byType[statType] }
+		}
 	}
 
 	// Get stats breakdowns
-	categories, _ := h.db.GetDayStatsByDayAndType(day, "category")
-	languages, _ := h.db.GetDayStatsByDayAndType(day, "language")
-	editors, _ := h.db.GetDayStatsByDayAndType(day, "editor")
-	operating_systems, _ := h.db.GetDayStatsByDayAndType(day, "os")
-	projects, _ := h.db.GetDayStatsByDayAndType(day, "project")
-	dependencies, _ := h.db.GetDayStatsByDayAndType(day, "dependency")
-	machines, _ := h.db.GetDayStatsByDayAndType(day, "machine")
+	categories := dayStats("category")
+	languages := dayStats("language")
+	editors := dayStats("editor")
+	operating_systems := dayStats("os")
+	projects := dayStats("project")
+	dependencies := dayStats("dependency")
+	machines := dayStats("machine")
+	branches := dayStats("branch")
+	entities := dayStats("entity")
 
 	loc := h.cfg.GetTimezone()
 
 	return map[string]interface{}{
+		"partial": partial,
 		"grand_total": map[string]interface{}{
-			"total_seconds": totalSeconds,
-			"digital":       formatDigital(totalSeconds),
-			"hours":         int(totalSeconds / 3600),
-			"minutes":       int(totalSeconds/60) % 60,
-			"text":          formatDuration(totalSeconds),
+			"total_seconds":   totalSeconds,
+			"digital":         formatDigital(totalSeconds, h.cfg.DigitalFormat),
+			"decimal":         formatDecimal(totalSeconds),
+			"hours":           int(totalSeconds / 3600),
+			"minutes":         int(totalSeconds/60) % 60,
+			"text":            formatDuration(totalSeconds),
+			"ai_additions":    aiAdditions,
+			"ai_deletions":    aiDeletions,
+			"human_additions": humanAdditions,
+			"human_deletions": humanDeletions,
 		},
-		"categories":        formatStatsItems(categories, totalSeconds),
-		"languages":         formatStatsItems(languages, totalSeconds),
-		"editors":           formatStatsItems(editors, totalSeconds),
-		"operating_systems": formatStatsItems(operating_systems, totalSeconds),
-		"projects":          formatStatsItems(projects, totalSeconds),
-		"dependencies":      formatStatsItems(dependencies, totalSeconds),
-		"machines":          formatMachineItems(machines, totalSeconds),
+		"categories":        formatStatsItems(categories, totalSeconds, h.cfg.DigitalFormat),
+		"languages":         formatStatsItems(languages, totalSeconds, h.cfg.DigitalFormat),
+		"editors":           formatStatsItems(editors, totalSeconds, h.cfg.DigitalFormat),
+		"operating_systems": formatStatsItems(operating_systems, totalSeconds, h.cfg.DigitalFormat),
+		"projects":          withProjectColors(formatStatsItems(projects, totalSeconds, h.cfg.DigitalFormat), projectColors),
+		"dependencies":      formatStatsItems(dependencies, totalSeconds, h.cfg.DigitalFormat),
+		"machines":          formatMachineItems(machines, knownMachines, totalSeconds, h.cfg.DigitalFormat),
+		"branches":          formatStatsItems(branches, totalSeconds, h.cfg.DigitalFormat),
+		"entities":          formatStatsItems(entities, totalSeconds, h.cfg.DigitalFormat),
 		"range": map[string]interface{}{
 			"date":     day.Format("2006-01-02"),
 			"start":    day.Format("2006-01-02") + "T00:00:00" + formatTimezoneOffset(loc),
@@ -307,7 +780,7 @@ func (h *Handler) buildDaySummary(day time.Time) map[string]interface{} {
 	}
 }
 
-func formatStatsItems(stats []database.DayStats, totalSeconds float64) []map[string]interface{} {
+func formatStatsItems(stats []database.DayStats, totalSeconds float64, digitalFormat string) []map[string]interface{} {
 	items := make([]map[string]interface{}, len(stats))
 	for i, s := range stats {
 		percent := float64(0)
@@ -318,29 +791,54 @@ func formatStatsItems(stats []database.DayStats, totalSeconds float64) []map[str
 			"name":          s.Name,
 			"total_seconds": s.TotalSeconds,
 			"percent":       percent,
-			"digital":       formatDigital(s.TotalSeconds),
+			"digital":       formatDigital(s.TotalSeconds, digitalFormat),
+			"decimal":       formatDecimal(s.TotalSeconds),
 			"hours":         int(s.TotalSeconds / 3600),
 			"minutes":       int(s.TotalSeconds/60) % 60,
 			"seconds":       int(s.TotalSeconds) % 60,
 			"text":          formatDuration(s.TotalSeconds),
 		}
+		if s.Type == "branch" || s.Type == "entity" {
+			items[i]["project"] = s.Project
+		}
 	}
 	return items
 }
 
-func formatMachineItems(stats []database.DayStats, totalSeconds float64) []map[string]interface{} {
+// formatMachineItems formats machine stats, which are stored by machine name
+// ID. Days synced before machines were stored by ID hold the name instead,
+// which is then resolved to the ID if no other machine has that name.
+func formatMachineItems(stats []database.DayStats, machines []database.Machine, totalSeconds float64, digitalFormat string) []map[string]interface{} {
+	names := make(map[string]string, len(machines))
+	idsByName := make(map[string]string, len(machines))
+	for _, m := range machines {
+		names[m.ID] = m.Name
+		if _, dup := idsByName[m.Name]; dup {
+			idsByName[m.Name] = ""
+		} else {
+			idsByName[m.Name] = m.ID
+		}
+	}
+
 	items := make([]map[string]interface{}, len(stats))
 	for i, s := range stats {
 		percent := float64(0)
 		if totalSeconds > 0 {
 			percent = (s.TotalSeconds / totalSeconds) * 100
 		}
+		id, name := s.Name, s.Name
+		if n, ok := names[s.Name]; ok {
+			name = n
+		} else if machineID := idsByName[s.Name]; machineID != "" {
+			id = machineID
+		}
 		items[i] = map[string]interface{}{
-			"name":            s.Name,
-			"machine_name_id": s.Name, // Use name as ID since we don't store separate ID
+			"name":            name,
+			"machine_name_id": id,
 			"total_seconds":   s.TotalSeconds,
 			"percent":         percent,
-			"digital":         formatDigital(s.TotalSeconds),
+			"digital":         formatDigital(s.TotalSeconds, digitalFormat),
+			"decimal":         formatDecimal(s.TotalSeconds),
 			"hours":           int(s.TotalSeconds / 3600),
 			"minutes":         int(s.TotalSeconds/60) % 60,
 			"seconds":         int(s.TotalSeconds) % 60,
@@ -350,6 +848,76 @@ func formatMachineItems(stats []database.DayStats, totalSeconds float64) []map[s
 	return items
 }
 
+// machineLabels returns the name of each machine by ID, for breakdowns keyed
+// by a single name. Machines sharing a name are told apart by their ID.
+func machineLabels(machines []database.Machine) map[string]string {
+	count := make(map[string]int, len(machines))
+	for _, m := range machines {
+		count[m.Name]++
+	}
+	labels := make(map[string]string, len(machines))
+	for _, m := range machines {
+		labels[m.ID] = m.Name
+		if count[m.Name] > 1 {
+			labels[m.ID] = m.Name + " (" + m.ID + ")"
+		}
+	}
+	return labels
+}
+
+// machineLabel returns the name of a machine by ID. Unknown IDs, and names
+// stored before machines were stored by ID, are returned as they are.
+func machineLabel(labels map[string]string, id string) string {
+	if label, ok := labels[id]; ok {
+		return label
+	}
+	return id
+}
+
+// withProjectColors sets the color of each project item to the color WakaTime
+// assigned the project, or one derived from its name, so that a project has
+// the same color in every chart
+func withProjectColors(items []map[string]interface{}, colors map[string]string) []map[string]interface{} {
+	for _, item := range items {
+		name, _ := item["name"].(string)
+		color := colors[name]
+		if color == "" {
+			color = hashColor(name)
+		}
+		item["color"] = color
+	}
+	return items
+}
+
+// hashColor derives a stable color from name, e.g. "#3fa2c9"
+func hashColor(name string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	hue := float64(hash.Sum32() % 360)
+
+	// HSL with 60% saturation and 50% lightness
+	const s, l = 0.6, 0.5
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g, b = c, x, 0
+	case hue < 120:
+		r, g, b = x, c, 0
+	case hue < 180:
+		r, g, b = 0, c, x
+	case hue < 240:
+		r, g, b = 0, x, c
+	case hue < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return fmt.Sprintf("#%02x%02x%02x", int((r+m)*255), int((g+m)*255), int((b+m)*255))
+}
+
 func formatDuration(seconds float64) string {
 	hours := int(seconds / 3600)
 	mins := int(seconds/60) % 60
@@ -359,12 +927,22 @@ func formatDuration(seconds float64) string {
 	return strconv.Itoa(mins) + " mins"
 }
 
-func formatDigital(seconds float64) string {
+// formatDigital formats seconds as "H:MM", or as "HH:MM:SS" if format is
+// config.DigitalFormatLong
+func formatDigital(seconds float64, format string) string {
 	hours := int(seconds / 3600)
 	mins := int(seconds/60) % 60
+	if format == config.DigitalFormatLong {
+		return padZero(hours) + ":" + padZero(mins) + ":" + padZero(int(seconds)%60)
+	}
 	return strconv.Itoa(hours) + ":" + padZero(mins)
 }
 
+// formatDecimal formats seconds as hours with two decimals, e.g. "12.50"
+func formatDecimal(seconds float64) string {
+	return strconv.FormatFloat(seconds/3600, 'f', 2, 64)
+}
+
 func padZero(n int) string {
 	if n < 10 {
 		return "0" + strconv.Itoa(n)
@@ -383,30 +961,27 @@ func formatTimezoneOffset(loc *time.Location) string {
 	return "-" + padZero(-hours) + ":" + padZero(-mins)
 }
 
-// getProjects returns all projects
-// GET /api/v1/users/current/projects?q=search
-func (h *Handler) getProjects(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-
-	projects, err := h.db.GetProjects(query)
+// getMachineNames returns the machines heartbeats were sent from, as of the
+// last sync
+// GET /api/v1/users/current/machine_names
+func (h *Handler) getMachineNames(w http.ResponseWriter, r *http.Request) {
+	machines, err := h.db.GetMachines()
 	if err != nil {
-		slog.Error("failed to get projects", "error", err)
-		writeError(w, http.StatusInternalServerError, "failed to get projects")
+		slog.Error("failed to get machines", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get machines")
 		return
 	}
 
-	formatted := make([]map[string]interface{}, len(projects))
-	for i, p := range projects {
+	formatted := make([]map[string]interface{}, len(machines))
+	for i, m := range machines {
 		formatted[i] = map[string]interface{}{
-			"id":                 p.UUID,
-			"name":               p.Name,
-			"repository":         p.Repository,
-			"badge":              p.Badge,
-			"color":              p.Color,
-			"has_public_url":     p.HasPublicURL,
-			"last_heartbeat_at":  formatTime(p.LastHeartbeatAt),
-			"first_heartbeat_at": formatTime(p.FirstHeartbeatAt),
-			"created_at":         formatTime(p.CreatedAt),
+			"id":           m.ID,
+			"name":         m.Name,
+			"value":        m.Name,
+			"ip":           m.IP,
+			"timezone":     m.Timezone,
+			"last_seen_at": formatTime(m.LastSeenAt),
+			"created_at":   formatTime(m.CreatedAt),
 		}
 	}
 
@@ -415,54 +990,264 @@ func (h *Handler) getProjects(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func formatTime(t time.Time) string {
-	if t.IsZero() {
-		return ""
+const (
+	defaultProjectsLimit = 50
+	maxProjectsLimit     = 500
+)
+
+// getProjects returns a page of projects with their total time over all time
+// or a date range, leaving out those with less total tracked time than
+// min_project_seconds (defaults to the configured value). They are ordered
+// by sort (name, created_at, last_heartbeat_at or total) in order (asc or
+// desc), by default the latest heartbeat first.
+// GET /api/v1/users/current/projects?q=search&min_project_seconds=600&sort=total&order=desc&start=2024-01-01&end=2024-01-31&limit=50&offset=0
+//
+// Without limit and offset all projects are returned, as WakaTime does. With
+// either, limit defaults to 50 and is capped at 500. The response includes the
+// total number of matching projects and the offset of the next page in "next"
+// (null when there are no more pages).
+func (h *Handler) getProjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+
+	minSeconds := h.cfg.MinProjectSeconds
+	if minStr := r.URL.Query().Get("min_project_seconds"); minStr != "" {
+		n, err := strconv.Atoi(minStr)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid min_project_seconds")
+			return
+		}
+		minSeconds = n
 	}
-	return t.Format(time.RFC3339)
-}
 
-// getDailyStats returns daily totals for a date range
-// GET /api/v1/stats/daily?start=2024-01-01&end=2024-01-31
-func (h *Handler) getDailyStats(w http.ResponseWriter, r *http.Request) {
-	startStr := r.URL.Query().Get("start")
-	endStr := r.URL.Query().Get("end")
+	sortBy := r.URL.Query().Get("sort")
+	switch sortBy {
+	case "", "last_heartbeat":
+		sortBy = "last_heartbeat_at"
+	}
+	if !database.ValidProjectSort(sortBy) {
+		writeError(w, http.StatusBadRequest, "invalid sort, expected name, created_at, last_heartbeat_at or total")
+		return
+	}
+	// Names sort A to Z by default, everything else largest or latest first
+	desc := sortBy != "name"
+	switch r.URL.Query().Get("order") {
+	case "":
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		writeError(w, http.StatusBadRequest, "invalid order, expected asc or desc")
+		return
+	}
 
-	if startStr == "" || endStr == "" {
-		// Default to last 30 days
-		endStr = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-		startStr = time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+	// Totals cover all time unless a range is given
+	start, end := time.Time{}, h.today()
+	if startStr := r.URL.Query().Get("start"); startStr != "" {
+		var err error
+		if start, err = parseDate(startStr); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid start date format")
+			return
+		}
+	}
+	if endStr := r.URL.Query().Get("end"); endStr != "" {
+		var err error
+		if end, err = parseDate(endStr); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid end date format")
+			return
+		}
 	}
 
-	start, err := parseDate(startStr)
+	total, err := h.db.CountProjects(query, minSeconds)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid start date format")
+		slog.Error("failed to count projects", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get projects")
 		return
 	}
 
-	end, err := parseDate(endStr)
+	// Clients of the WakaTime API expect the full list, which -1 (no limit in
+	// SQLite) returns
+	limit, offset := -1, 0
+	if r.URL.Query().Has("limit") || r.URL.Query().Has("offset") {
+		if limit, offset, err = parsePagination(r, defaultProjectsLimit, maxProjectsLimit); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	projects, err := h.db.GetProjectsPaged(query, minSeconds, sortBy, desc, start, end, limit, offset)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid end date format")
+		slog.Error("failed to get projects", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get projects")
 		return
 	}
+	if limit < 0 {
+		limit = len(projects)
+	}
 
-	summaries, err := h.db.GetDaySummaries(start, end)
+	totals, err := h.db.GetAggregatedStats(start, end, "project", 0)
 	if err != nil {
-		slog.Error("failed to get daily stats", "error", err)
-		writeError(w, http.StatusInternalServerError, "failed to get stats")
+		slog.Error("failed to get project totals", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get projects")
 		return
 	}
-
-	// Create a map for quick lookup
-	summaryMap := make(map[string]float64)
-	for _, s := range summaries {
-		summaryMap[s.Day.Format("2006-01-02")] = s.TotalSeconds
+	totalSeconds := make(map[string]float64, len(totals))
+	for _, t := range totals {
+		totalSeconds[t.Name] = t.TotalSeconds
 	}
 
-	// Fill in all days including zeros
-	var data []map[string]interface{}
-	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
-		dateStr := d.Format("2006-01-02")
+	formatted := make([]map[string]interface{}, len(projects))
+	for i, p := range projects {
+		formatted[i] = map[string]interface{}{
+			"id":                 p.UUID,
+			"name":               p.Name,
+			"repository":         p.Repository,
+			"badge":              p.Badge,
+			"color":              p.Color,
+			"has_public_url":     p.HasPublicURL,
+			"last_heartbeat_at":  formatTime(p.LastHeartbeatAt),
+			"first_heartbeat_at": formatTime(p.FirstHeartbeatAt),
+			"created_at":         formatTime(p.CreatedAt),
+			"total_seconds":      totalSeconds[p.Name],
+			"text":               formatDuration(totalSeconds[p.Name]),
+		}
+	}
+
+	var next interface{}
+	if offset+len(projects) < total {
+		next = offset + len(projects)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":   formatted,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+		"next":   next,
+	})
+}
+
+// deleteProject removes all data of a project, e.g. one that was abandoned,
+// and keeps it from being synced again. The days it was on are re-synced in
+// the background.
+// DELETE /api/v1/users/current/projects/{name}?api_key=xxx
+func (h *Handler) deleteProject(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("api_key")), []byte(h.cfg.WakaTimeAPI)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+
+	name := r.PathValue("name")
+	deleted, err := h.syncer.DeleteProject(name)
+	if errors.Is(err, sync.ErrSyncInProgress) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "project not found")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to delete project", "project", name, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete project")
+		return
+	}
+
+	slog.Info("deleted project", "project", name, "days", deleted.Days, "total_seconds", deleted.TotalSeconds)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"project": name,
+		"deleted": deleted,
+	})
+}
+
+// getWakaTimeGoals returns the goals defined on WakaTime as of the last sync
+// GET /api/v1/goals/wakatime
+func (h *Handler) getWakaTimeGoals(w http.ResponseWriter, r *http.Request) {
+	goals, err := h.db.GetWakaTimeGoals()
+	if err != nil {
+		slog.Error("failed to get wakatime goals", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get goals")
+		return
+	}
+
+	formatted := make([]map[string]interface{}, len(goals))
+	for i, g := range goals {
+		formatted[i] = map[string]interface{}{
+			"id":                        g.UUID,
+			"title":                     g.Title,
+			"type":                      g.Type,
+			"delta":                     g.Delta,
+			"seconds":                   g.Seconds,
+			"improve_by_percent":        g.ImproveByPercent,
+			"status":                    g.Status,
+			"status_percent_calculated": g.StatusPercentCalculated,
+			"range_text":                g.RangeText,
+			"is_enabled":                g.IsEnabled,
+			"is_snoozed":                g.IsSnoozed,
+			"is_inverse":                g.IsInverse,
+			"languages":                 json.RawMessage(g.Languages),
+			"projects":                  json.RawMessage(g.Projects),
+			"editors":                   json.RawMessage(g.Editors),
+			"chart_data":                json.RawMessage(g.ChartData),
+			"modified_at":               formatTime(g.ModifiedAt),
+			"created_at":                formatTime(g.CreatedAt),
+			"updated_at":                formatTime(g.UpdatedAt),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": formatted,
+	})
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// getDailyStats returns daily totals for a date range, optionally of a single
+// language, editor or operating system only
+// GET /api/v1/stats/daily?start=2024-01-01&end=2024-01-31&language=Go
+func (h *Handler) getDailyStats(w http.ResponseWriter, r *http.Request) {
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		// Default to last 30 days
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -30).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	filter, err := parseStatsFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	summaryMap, err := h.dailyTotals(start, end, filter)
+	if err != nil {
+		slog.Error("failed to get daily stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get stats")
+		return
+	}
+
+	// Fill in all days including zeros
+	data := []map[string]interface{}{}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		dateStr := d.Format("2006-01-02")
 		totalSeconds := summaryMap[dateStr]
 		data = append(data, map[string]interface{}{
 			"date":          dateStr,
@@ -471,122 +1256,1183 @@ func (h *Handler) getDailyStats(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	resp := map[string]interface{}{
+		"data": data,
+	}
+	if filter != nil {
+		resp[filter.param] = filter.name
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// statsFilter restricts stats to one name of a breakdown type
+type statsFilter struct {
+	param    string // query param it was set by
+	statType string // day_stats type
+	name     string
+}
+
+// statsFilterParams maps the filter query params to day_stats types
+var statsFilterParams = []struct{ param, statType string }{
+	{"language", "language"},
+	{"editor", "editor"},
+	{"os", "os"},
+}
+
+// parseStatsFilter returns the language, editor or os filter of a request, or
+// nil if there is none. day_stats has totals per language, editor and OS, but
+// not per combination of them, so only one filter can be applied.
+func parseStatsFilter(r *http.Request) (*statsFilter, error) {
+	var filter *statsFilter
+	for _, p := range statsFilterParams {
+		name := r.URL.Query().Get(p.param)
+		if name == "" {
+			continue
+		}
+		if filter != nil {
+			return nil, fmt.Errorf("cannot combine the %s and %s filters, only one of language, editor and os is supported", filter.param, p.param)
+		}
+		filter = &statsFilter{param: p.param, statType: p.statType, name: name}
+	}
+	return filter, nil
+}
+
+// dailyTotals returns the total per day, keyed by date, of all coding time or
+// of a single language, editor or OS if filter is set
+func (h *Handler) dailyTotals(start, end time.Time, filter *statsFilter) (map[string]float64, error) {
+	totals := make(map[string]float64)
+	if filter != nil {
+		stats, err := h.db.GetDailyStatsByTypeName(start, end, filter.statType, filter.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range stats {
+			totals[s.Day.Format("2006-01-02")] = s.TotalSeconds
+		}
+		return totals, nil
+	}
+
+	summaries, err := h.db.GetDaySummaries(start, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range summaries {
+		totals[s.Day.Format("2006-01-02")] = s.TotalSeconds
+	}
+	return totals, nil
+}
+
+// getRangeStats returns aggregated stats for a date range. With language,
+// editor or os, it returns that one's total and daily totals only.
+// GET /api/v1/stats/range?start=2024-01-01&end=2024-01-31
+// GET /api/v1/stats/range?start=2024-01-01&end=2024-01-31&language=Go
+func (h *Handler) getRangeStats(w http.ResponseWriter, r *http.Request) {
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -7).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	filter, err := parseStatsFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter != nil {
+		h.filteredRangeStats(w, start, end, filter)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.rangeStats(start, end))
+}
+
+// filteredRangeStats writes the total and daily totals of a language, editor
+// or OS for a range. The other breakdowns are not stored per language etc., so
+// they are left out.
+func (h *Handler) filteredRangeStats(w http.ResponseWriter, start, end time.Time, filter *statsFilter) {
+	totals, err := h.dailyTotals(start, end, filter)
+	if err != nil {
+		slog.Error("failed to get filtered stats", filter.param, filter.name, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get stats")
+		return
+	}
+
+	var totalSeconds float64
+	daily := []map[string]interface{}{}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		seconds := totals[d.Format("2006-01-02")]
+		totalSeconds += seconds
+		daily = append(daily, map[string]interface{}{
+			"date":          d.Format("2006-01-02"),
+			"total_seconds": seconds,
+			"text":          formatDuration(seconds),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		filter.param:    filter.name,
+		"total_seconds": totalSeconds,
+		"text":          formatDuration(totalSeconds),
+		"daily":         daily,
+		"start":         start.Format("2006-01-02"),
+		"end":           end.Format("2006-01-02"),
+	})
+}
+
+// getProjectComparison compares the time per project in two periods, by
+// default this month so far and the whole previous month. If only the current
+// period is given, it is compared to the same number of days right before it.
+// GET /api/v1/stats/compare?start=2024-02-01&end=2024-02-29&compare_start=2024-01-01&compare_end=2024-01-31
+func (h *Handler) getProjectComparison(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	today := h.today()
+
+	start := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := today
+	compareStart, compareEnd := start.AddDate(0, -1, 0), start.AddDate(0, 0, -1)
+
+	if q.Get("start") != "" || q.Get("end") != "" {
+		var err error
+		if start, err = parseDate(q.Get("start")); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid start date format")
+			return
+		}
+		if end, err = parseDate(q.Get("end")); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid end date format")
+			return
+		}
+		days := int(end.Sub(start).Hours()/24) + 1
+		compareStart, compareEnd = start.AddDate(0, 0, -days), start.AddDate(0, 0, -1)
+	}
+	if q.Get("compare_start") != "" || q.Get("compare_end") != "" {
+		var err error
+		if compareStart, err = parseDate(q.Get("compare_start")); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid compare_start date format")
+			return
+		}
+		if compareEnd, err = parseDate(q.Get("compare_end")); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid compare_end date format")
+			return
+		}
+	}
+	if start.After(end) || compareStart.After(compareEnd) {
+		writeError(w, http.StatusBadRequest, "start date must be before end date")
+		return
+	}
+
+	comparison, err := h.db.GetProjectComparison(start, end, compareStart, compareEnd)
+	if err != nil {
+		slog.Error("failed to compare projects", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to compare projects")
+		return
+	}
+
+	var currentTotal, previousTotal float64
+	for _, c := range comparison {
+		currentTotal += c.CurrentSeconds
+		previousTotal += c.PreviousSeconds
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": comparison,
+		"current": map[string]interface{}{
+			"start":         start.Format("2006-01-02"),
+			"end":           end.Format("2006-01-02"),
+			"total_seconds": currentTotal,
+			"text":          formatDuration(currentTotal),
+		},
+		"previous": map[string]interface{}{
+			"start":         compareStart.Format("2006-01-02"),
+			"end":           compareEnd.Format("2006-01-02"),
+			"total_seconds": previousTotal,
+			"text":          formatDuration(previousTotal),
+		},
+	})
+}
+
+// statsRanges are the named ranges of WakaTime's stats API. Each returns the
+// first day of the range ending today.
+var statsRanges = map[string]func(today time.Time) time.Time{
+	"last_7_days":   func(today time.Time) time.Time { return today.AddDate(0, 0, -6) },
+	"last_30_days":  func(today time.Time) time.Time { return today.AddDate(0, 0, -29) },
+	"last_6_months": func(today time.Time) time.Time { return today.AddDate(0, -6, 1) },
+	"last_year":     func(today time.Time) time.Time { return today.AddDate(-1, 0, 1) },
+}
+
+// statsRangeBounds returns the first and last day of a named stats range in
+// the configured timezone, or false if the range is unknown
+func (h *Handler) statsRangeBounds(name string) (time.Time, time.Time, bool) {
+	today := h.today()
+
+	if name == "all_time" {
+		return h.cfg.GetStartDate(), today, true
+	}
+	startFn, ok := statsRanges[name]
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return startFn(today), today, true
+}
+
+// getStatsForRange returns the stats of a named range, like WakaTime's stats
+// API, so WakaTime clients and badges work against this server
+// GET /api/v1/users/current/stats/last_7_days
+func (h *Handler) getStatsForRange(w http.ResponseWriter, r *http.Request) {
+	rangeName := r.PathValue("range")
+	start, end, ok := h.statsRangeBounds(rangeName)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid range, expected one of last_7_days, last_30_days, last_6_months, last_year, all_time")
+		return
+	}
+
+	stats := h.rangeStats(start, end)
+	stats["range"] = rangeName
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// rangeStats aggregates the stats of all days from start to end
+func (h *Handler) rangeStats(start, end time.Time) map[string]interface{} {
+	// Get aggregated stats
+	categories, _ := h.db.GetAggregatedStats(start, end, "category", 0)
+	languages, _ := h.db.GetAggregatedStats(start, end, "language", 0)
+	editors, _ := h.db.GetAggregatedStats(start, end, "editor", 0)
+	operating_systems, _ := h.db.GetAggregatedStats(start, end, "os", 0)
+	projects, _ := h.db.GetAggregatedStats(start, end, "project", 0)
+	branches, _ := h.db.GetAggregatedProjectStats(start, end, "branch")
+	entities, _ := h.db.GetAggregatedProjectStats(start, end, "entity")
+
+	machines, _ := h.db.GetAggregatedStats(start, end, "machine", 0)
+	knownMachines, _ := h.db.GetMachines()
+	machineStats := make([]database.DayStats, len(machines))
+	for i, m := range machines {
+		machineStats[i] = database.DayStats{Name: m.Name, TotalSeconds: m.TotalSeconds}
+	}
+
+	projectColors, _ := h.db.GetProjectColors()
+
+	// Get daily project breakdown
+	projectDaily, _ := h.db.GetDailyStatsSeries(start, end, "project")
+
+	// Calculate total
+	var totalSeconds float64
+	for _, p := range projects {
+		totalSeconds += p.TotalSeconds
+	}
+
+	return map[string]interface{}{
+		"total_seconds":     totalSeconds,
+		"text":              formatDuration(totalSeconds),
+		"categories":        formatAggStats(categories, totalSeconds),
+		"languages":         formatAggStats(languages, totalSeconds),
+		"editors":           formatAggStats(editors, totalSeconds),
+		"operating_systems": formatAggStats(operating_systems, totalSeconds),
+		"projects":          withProjectColors(formatAggStats(projects, totalSeconds), projectColors),
+		"branches":          formatProjectAggStats(branches, totalSeconds),
+		"entities":          formatProjectAggStats(entities, totalSeconds),
+		"machines":          formatMachineItems(machineStats, knownMachines, totalSeconds, h.cfg.DigitalFormat),
+		"projects_daily":    projectDaily,
+		"start":             start.Format("2006-01-02"),
+		"end":               end.Format("2006-01-02"),
+	}
+}
+
+// getHourlyStats returns time spent per hour of the day, computed from heartbeats
+// GET /api/v1/stats/hourly?start=2024-01-01&end=2024-01-31
+func (h *Handler) getHourlyStats(w http.ResponseWriter, r *http.Request) {
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -7).Format("2006-01-02")
+	}
+
+	// Hours are bucketed in the configured timezone
+	loc := h.cfg.GetTimezone()
+	start, err := time.ParseInLocation("2006-01-02", startStr, loc)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := time.ParseInLocation("2006-01-02", endStr, loc)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	hours, err := h.db.GetHourlyHistogram(start, end, h.cfg.GetHeartbeatTimeout())
+	if err != nil {
+		slog.Error("failed to get hourly stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get hourly stats")
+		return
+	}
+
+	var totalSeconds float64
+	for _, s := range hours {
+		totalSeconds += s
+	}
+
+	data := make([]map[string]interface{}, len(hours))
+	for hour, s := range hours {
+		percent := float64(0)
+		if totalSeconds > 0 {
+			percent = (s / totalSeconds) * 100
+		}
+		data[hour] = map[string]interface{}{
+			"hour":          hour,
+			"total_seconds": s,
+			"percent":       percent,
+			"text":          formatDuration(s),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":          data,
+		"total_seconds": totalSeconds,
+		"timezone":      loc.String(),
+		"start":         startStr,
+		"end":           endStr,
+	})
+}
+
+// getAllTimeStats returns the lifetime total, active days and daily average
+// GET /api/v1/stats/alltime
+func (h *Handler) getAllTimeStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.db.GetAllTimeStats()
+	if err != nil {
+		slog.Error("failed to get all-time stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get all-time stats")
+		return
+	}
+
+	average := float64(0)
+	if stats.ActiveDays > 0 {
+		average = stats.TotalSeconds / float64(stats.ActiveDays)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total_seconds":         stats.TotalSeconds,
+		"text":                  formatDuration(stats.TotalSeconds),
+		"active_days":           stats.ActiveDays,
+		"daily_average_seconds": average,
+		"daily_average_text":    formatDuration(average),
+		"first_day":             stats.FirstDay,
+		"last_day":              stats.LastDay,
+	})
+}
+
+// getAverageStats returns the average daily time of the current week, month
+// and year. Today is still being coded and is only synced once it is over, so
+// averages are over the days of the period up to yesterday rather than its
+// calendar length; on the first day of a period no day has elapsed yet and
+// its averages are 0.
+// GET /api/v1/stats/averages
+func (h *Handler) getAverageStats(w http.ResponseWriter, r *http.Request) {
+	today := h.today()
+	yesterday := today.AddDate(0, 0, -1)
+	periods := []struct {
+		name  string
+		start time.Time
+	}{
+		{"week", startOfWeek(today, h.cfg.GetWeekStart())},
+		{"month", today.AddDate(0, 0, 1-today.Day())},
+		{"year", today.AddDate(0, 0, 1-today.YearDay())},
+	}
+
+	earliest := today
+	for _, p := range periods {
+		if p.start.Before(earliest) {
+			earliest = p.start
+		}
+	}
+	summaries, err := h.db.GetDaySummaries(earliest, yesterday)
+	if err != nil {
+		slog.Error("failed to get average stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get average stats")
+		return
+	}
+
+	result := map[string]interface{}{
+		"timezone":   h.cfg.GetTimezone().String(),
+		"week_start": h.cfg.WeekStart,
+	}
+	for _, p := range periods {
+		var totalSeconds float64
+		var activeDays int
+		for _, s := range summaries {
+			if s.Day.Before(p.start) || s.TotalSeconds <= 0 {
+				continue
+			}
+			totalSeconds += s.TotalSeconds
+			activeDays++
+		}
+		days := int(today.Sub(p.start).Hours() / 24)
+
+		average, activeAverage := float64(0), float64(0)
+		if days > 0 {
+			average = totalSeconds / float64(days)
+		}
+		if activeDays > 0 {
+			activeAverage = totalSeconds / float64(activeDays)
+		}
+		result[p.name] = map[string]interface{}{
+			"start":         p.start.Format("2006-01-02"),
+			"end":           yesterday.Format("2006-01-02"),
+			"days":          days,
+			"active_days":   activeDays,
+			"total_seconds": totalSeconds,
+			"text":          formatDuration(totalSeconds),
+			"daily_average": map[string]interface{}{
+				"seconds": average,
+				"text":    formatDuration(average),
+			},
+			"active_day_average": map[string]interface{}{
+				"seconds": activeAverage,
+				"text":    formatDuration(activeAverage),
+			},
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// getWeekdayStats returns time spent per day of the week, starting with the
+// configured week_start
+// GET /api/v1/stats/weekdays?start=2024-01-01&end=2024-01-31
+func (h *Handler) getWeekdayStats(w http.ResponseWriter, r *http.Request) {
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -28).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	weekStart := h.cfg.GetWeekStart()
+	totals, err := h.db.GetWeekdayTotals(start, end, weekStart)
+	if err != nil {
+		slog.Error("failed to get weekday stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get weekday stats")
+		return
+	}
+
+	// Count how often each weekday occurs in the range, for averages
+	var occurrences [7]int
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		occurrences[(d.Weekday()-weekStart+7)%7]++
+	}
+
+	var totalSeconds float64
+	for _, s := range totals {
+		totalSeconds += s
+	}
+
+	data := make([]map[string]interface{}, len(totals))
+	for i, s := range totals {
+		percent := float64(0)
+		if totalSeconds > 0 {
+			percent = (s / totalSeconds) * 100
+		}
+		average := float64(0)
+		if occurrences[i] > 0 {
+			average = s / float64(occurrences[i])
+		}
+		data[i] = map[string]interface{}{
+			"weekday":         ((weekStart + time.Weekday(i)) % 7).String(),
+			"total_seconds":   s,
+			"percent":         percent,
+			"text":            formatDuration(s),
+			"average_seconds": average,
+			"average_text":    formatDuration(average),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":          data,
+		"total_seconds": totalSeconds,
+		"week_start":    h.cfg.WeekStart,
+		"start":         startStr,
+		"end":           endStr,
+	})
+}
+
+// defaultWeeklyStatsWeeks is how many weeks getWeeklyStats returns by default
+const defaultWeeklyStatsWeeks = 12
+
+// startOfWeek returns the first day of the week containing day
+func startOfWeek(day time.Time, weekStart time.Weekday) time.Time {
+	return day.AddDate(0, 0, -int((day.Weekday()-weekStart+7)%7))
+}
+
+// getWeeklyStats returns the total time of every week from the week containing
+// start to the week containing end, with weeks starting on the configured
+// week_start. Defaults to the last 12 weeks.
+// GET /api/v1/stats/weekly?start=2024-01-01&end=2024-03-31
+func (h *Handler) getWeeklyStats(w http.ResponseWriter, r *http.Request) {
+	weekStart := h.cfg.GetWeekStart()
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		yesterday := h.today().AddDate(0, 0, -1)
+		endStr = yesterday.Format("2006-01-02")
+		startStr = startOfWeek(yesterday, weekStart).AddDate(0, 0, -7*(defaultWeeklyStatsWeeks-1)).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, "start must not be after end")
+		return
+	}
+
+	weeks, err := h.db.GetWeeklyTotals(start, end, weekStart)
+	if err != nil {
+		slog.Error("failed to get weekly stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get weekly stats")
+		return
+	}
+	byWeek := make(map[string]float64, len(weeks))
+	for _, wk := range weeks {
+		byWeek[wk.WeekStart] = wk.TotalSeconds
+	}
+
+	var totalSeconds float64
+	data := []map[string]interface{}{}
+	for wk := startOfWeek(start, weekStart); !wk.After(end); wk = wk.AddDate(0, 0, 7) {
+		seconds := byWeek[wk.Format("2006-01-02")]
+		totalSeconds += seconds
+		data = append(data, map[string]interface{}{
+			"week_start":    wk.Format("2006-01-02"),
+			"week_end":      wk.AddDate(0, 0, 6).Format("2006-01-02"),
+			"total_seconds": seconds,
+			"text":          formatDuration(seconds),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":          data,
+		"total_seconds": totalSeconds,
+		"week_start":    h.cfg.WeekStart,
+		"start":         startStr,
+		"end":           endStr,
+	})
+}
+
+// getStatsSeries returns the time per day of each name of a stat type, e.g.
+// for a stacked chart of languages. Every day of the range is included, and
+// every day lists every name, with 0 if there was no time on it.
+// GET /api/v1/stats/series?type=language&start=2024-01-01&end=2024-01-31
+func (h *Handler) getStatsSeries(w http.ResponseWriter, r *http.Request) {
+	statType := r.URL.Query().Get("type")
+	if !database.ValidStatType(statType) {
+		writeError(w, http.StatusBadRequest, "invalid type, expected one of "+strings.Join(database.StatTypes, ", "))
+		return
+	}
+
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -7).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, "start must not be after end")
+		return
+	}
+
+	series, err := h.db.GetDailyStatsSeries(start, end, statType)
+	if err != nil {
+		slog.Error("failed to get stats series", "type", statType, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get stats series")
+		return
+	}
+
+	// Machines are stored by ID
+	if statType == "machine" {
+		machines, err := h.db.GetMachines()
+		if err != nil {
+			slog.Error("failed to get machines", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to get stats series")
+			return
+		}
+		labels := machineLabels(machines)
+		for i := range series {
+			series[i].Name = machineLabel(labels, series[i].Name)
+		}
+	}
+
+	byDay := make(map[string]map[string]float64)
+	nameTotals := make(map[string]float64)
+	for _, s := range series {
+		if byDay[s.Day] == nil {
+			byDay[s.Day] = make(map[string]float64)
+		}
+		byDay[s.Day][s.Name] += s.TotalSeconds
+		nameTotals[s.Name] += s.TotalSeconds
+	}
+
+	// Names are ordered largest first, so the biggest stack is at the bottom
+	names := make([]string, 0, len(nameTotals))
+	for name := range nameTotals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if nameTotals[names[i]] != nameTotals[names[j]] {
+			return nameTotals[names[i]] > nameTotals[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var totalSeconds float64
+	data := []map[string]interface{}{}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		day := byDay[d.Format("2006-01-02")]
+		values := make(map[string]float64, len(names))
+		var daySeconds float64
+		for _, name := range names {
+			values[name] = day[name]
+			daySeconds += day[name]
+		}
+		totalSeconds += daySeconds
+		data = append(data, map[string]interface{}{
+			"date":          d.Format("2006-01-02"),
+			"total_seconds": daySeconds,
+			"values":        values,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"type":          statType,
+		"names":         names,
+		"data":          data,
+		"total_seconds": totalSeconds,
+		"text":          formatDuration(totalSeconds),
+		"start":         start.Format("2006-01-02"),
+		"end":           end.Format("2006-01-02"),
+	})
+}
+
+const (
+	defaultTopStatsLimit = 10
+	maxTopStatsLimit     = 100
+)
+
+// getTopStats returns the top names of a breakdown type over a date range,
+// e.g. the most used languages, without the rest of the range stats
+// GET /api/v1/stats/languages?start=2024-01-01&end=2024-01-31&limit=10
+func (h *Handler) getTopStats(statType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startStr := r.URL.Query().Get("start")
+		endStr := r.URL.Query().Get("end")
+
+		if startStr == "" || endStr == "" {
+			endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+			startStr = h.today().AddDate(0, 0, -7).Format("2006-01-02")
+		}
+
+		start, err := parseDate(startStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid start date format")
+			return
+		}
+
+		end, err := parseDate(endStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid end date format")
+			return
+		}
+
+		limit := defaultTopStatsLimit
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			if limit > maxTopStatsLimit {
+				limit = maxTopStatsLimit
+			}
+		}
+
+		stats, err := h.db.GetAggregatedStats(start, end, statType, limit)
+		if err != nil {
+			slog.Error("failed to get top stats", "type", statType, "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to get stats")
+			return
+		}
+
+		// Percentages are relative to all names, not just the returned ones
+		totalSeconds, err := h.db.GetAggregatedStatsTotal(start, end, statType)
+		if err != nil {
+			slog.Error("failed to get top stats", "type", statType, "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to get stats")
+			return
+		}
+
+		items := formatAggStats(stats, totalSeconds)
+		if statType == "project" {
+			colors, err := h.db.GetProjectColors()
+			if err != nil {
+				slog.Error("failed to get project colors", "error", err)
+			}
+			items = withProjectColors(items, colors)
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data":          items,
+			"total_seconds": totalSeconds,
+			"start":         startStr,
+			"end":           endStr,
+		})
+	}
+}
+
+// getLanguageActivity returns the current and longest streak of consecutive
+// days with a language, and the last day it was used
+// GET /api/v1/stats/languages/Rust/activity
+func (h *Handler) getLanguageActivity(w http.ResponseWriter, r *http.Request) {
+	language := r.PathValue("name")
+
+	minSeconds := h.cfg.MinSessionSeconds
+	if minStr := r.URL.Query().Get("min_session_seconds"); minStr != "" {
+		n, err := strconv.Atoi(minStr)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid min_session_seconds")
+			return
+		}
+		minSeconds = n
+	}
+
+	today := h.today()
+	activity, err := h.db.GetLanguageActivity(language, today, float64(minSeconds))
+	if err != nil {
+		slog.Error("failed to get language activity", "language", language, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get language activity")
+		return
+	}
+
+	var daysSince interface{}
+	if activity.LastDay != "" {
+		last, _ := parseDate(activity.LastDay)
+		daysSince = int(today.Sub(last).Hours() / 24)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"language":             activity.Language,
+		"current_streak":       activity.CurrentStreak,
+		"longest_streak":       activity.LongestStreak,
+		"active_days":          activity.ActiveDays,
+		"total_seconds":        activity.TotalSeconds,
+		"text":                 formatDuration(activity.TotalSeconds),
+		"first_day":            activity.FirstDay,
+		"last_day":             activity.LastDay,
+		"days_since_last_used": daysSince,
+		"min_session_seconds":  minSeconds,
+	})
+}
+
+func formatAggStats(stats []struct {
+	Name         string  `json:"name"`
+	TotalSeconds float64 `json:"total_seconds"`
+}, totalSeconds float64) []map[string]interface{} {
+	items := make([]map[string]interface{}, len(stats))
+	for i, s := range stats {
+		percent := float64(0)
+		if totalSeconds > 0 {
+			percent = (s.TotalSeconds / totalSeconds) * 100
+		}
+		items[i] = map[string]interface{}{
+			"name":          s.Name,
+			"total_seconds": s.TotalSeconds,
+			"percent":       percent,
+			"text":          formatDuration(s.TotalSeconds),
+		}
+	}
+	return items
+}
+
+// formatProjectAggStats formats aggregated per-project stats, branches and
+// entities, like formatAggStats with the project of each
+func formatProjectAggStats(stats []database.DayStats, totalSeconds float64) []map[string]interface{} {
+	items := make([]map[string]interface{}, len(stats))
+	for i, s := range stats {
+		percent := float64(0)
+		if totalSeconds > 0 {
+			percent = (s.TotalSeconds / totalSeconds) * 100
+		}
+		items[i] = map[string]interface{}{
+			"project":       s.Project,
+			"name":          s.Name,
+			"total_seconds": s.TotalSeconds,
+			"percent":       percent,
+			"text":          formatDuration(s.TotalSeconds),
+		}
+	}
+	return items
+}
+
+// getProjectLanguages returns the time spent per language in a project, from
+// the project's detailed durations
+// GET /api/v1/projects/{name}/languages?start=2024-01-01&end=2024-01-31
+func (h *Handler) getProjectLanguages(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("name")
+
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -7).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	stats, err := h.db.GetProjectLanguageBreakdown(project, start, end)
+	if err != nil {
+		slog.Error("failed to get project languages", "project", project, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get project languages")
+		return
+	}
+
+	var totalSeconds float64
+	for _, s := range stats {
+		totalSeconds += s.TotalSeconds
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"project":       project,
+		"data":          formatAggStats(stats, totalSeconds),
+		"total_seconds": totalSeconds,
+		"start":         startStr,
+		"end":           endStr,
+	})
+}
+
+// getLineStats returns estimated lines added/removed per day from write heartbeats.
+// This is a heuristic based on file line counts, see database.GetLineDeltas.
+// GET /api/v1/stats/lines?start=2024-01-01&end=2024-01-31
+func (h *Handler) getLineStats(w http.ResponseWriter, r *http.Request) {
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		endStr = h.today().AddDate(0, 0, -1).Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -7).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	deltas, err := h.db.GetLineDeltas(start, end)
+	if err != nil {
+		slog.Error("failed to get line stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get line stats")
+		return
+	}
+
+	var added, removed, net int
+	for _, d := range deltas {
+		added += d.LinesAdded
+		removed += d.LinesRemoved
+		net += d.NetLines
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":          deltas,
+		"lines_added":   added,
+		"lines_removed": removed,
+		"net_lines":     net,
+		"start":         startStr,
+		"end":           endStr,
+	})
+}
+
+// triggerSync manually triggers a sync of the last N days, or of a date range
+// if start and end are given
+// POST /api/v1/sync?days=7&api_key=xxx
+// POST /api/v1/sync?start=2024-01-01&end=2024-01-31&force=true&api_key=xxx
+func (h *Handler) triggerSync(w http.ResponseWriter, r *http.Request) {
+	// Check API key
+	apiKey := r.URL.Query().Get("api_key")
+	if apiKey == "" {
+		apiKey = r.FormValue("apiKey")
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(h.cfg.WakaTimeAPI)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+
+	// force re-syncs days even if they look up to date
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	// catch_up syncs every day since the last synced one instead of the last N
+	catchUp, _ := strconv.ParseBool(r.URL.Query().Get("catch_up"))
+
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+	if startStr != "" || endStr != "" {
+		h.triggerSyncRange(w, r, startStr, endStr, force)
+		return
+	}
+
+	daysStr := r.URL.Query().Get("days")
+	if daysStr == "" {
+		daysStr = r.FormValue("day")
+	}
+	days, err := strconv.Atoi(daysStr)
+	if err != nil || days <= 0 {
+		days = 1
+	}
+
+	// Run sync in background
+	if !h.checkWakaTime(w, r) {
+		return
+	}
+	if err := h.syncer.StartSync(days, force, catchUp); err != nil {
+		writeSyncStartError(w, err)
+		return
+	}
+
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"data": data,
+		"message":  "sync started",
+		"days":     days,
+		"force":    force,
+		"catch_up": catchUp,
 	})
 }
 
-// getRangeStats returns aggregated stats for a date range
-// GET /api/v1/stats/range?start=2024-01-01&end=2024-01-31
-func (h *Handler) getRangeStats(w http.ResponseWriter, r *http.Request) {
-	startStr := r.URL.Query().Get("start")
-	endStr := r.URL.Query().Get("end")
-
+// triggerSyncRange starts a sync of every day from start to end. The range
+// must lie between the configured start date and today.
+func (h *Handler) triggerSyncRange(w http.ResponseWriter, r *http.Request, startStr, endStr string, force bool) {
 	if startStr == "" || endStr == "" {
-		endStr = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-		startStr = time.Now().AddDate(0, 0, -7).Format("2006-01-02")
+		writeError(w, http.StatusBadRequest, "both start and end are required")
+		return
 	}
 
-	start, err := parseDate(startStr)
+	loc := h.cfg.GetTimezone()
+	start, err := time.ParseInLocation("2006-01-02", startStr, loc)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "invalid start date format")
 		return
 	}
-
-	end, err := parseDate(endStr)
+	end, err := time.ParseInLocation("2006-01-02", endStr, loc)
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "invalid end date format")
 		return
 	}
 
-	// Get aggregated stats
-	categories, _ := h.db.GetAggregatedStats(start, end, "category")
-	languages, _ := h.db.GetAggregatedStats(start, end, "language")
-	editors, _ := h.db.GetAggregatedStats(start, end, "editor")
-	operating_systems, _ := h.db.GetAggregatedStats(start, end, "os")
-	projects, _ := h.db.GetAggregatedStats(start, end, "project")
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, "start date must be before end date")
+		return
+	}
+	if minStart := h.cfg.GetStartDate().Format("2006-01-02"); startStr < minStart {
+		writeError(w, http.StatusBadRequest, "start date must not be before "+minStart)
+		return
+	}
+	if endStr > time.Now().In(loc).Format("2006-01-02") {
+		writeError(w, http.StatusBadRequest, "end date must not be in the future")
+		return
+	}
 
-	// Get daily project breakdown
-	projectDaily, _ := h.db.GetProjectDailyStats(start, end)
+	if !h.checkWakaTime(w, r) {
+		return
+	}
+	if err := h.syncer.StartSyncRange(start, end, force); err != nil {
+		writeSyncStartError(w, err)
+		return
+	}
 
-	// Calculate total
-	var totalSeconds float64
-	for _, p := range projects {
-		totalSeconds += p.TotalSeconds
+	days := 0
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		days++
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"total_seconds":     totalSeconds,
-		"text":              formatDuration(totalSeconds),
-		"categories":        formatAggStats(categories, totalSeconds),
-		"languages":         formatAggStats(languages, totalSeconds),
-		"editors":           formatAggStats(editors, totalSeconds),
-		"operating_systems": formatAggStats(operating_systems, totalSeconds),
-		"projects":          formatAggStats(projects, totalSeconds),
-		"projects_daily":    projectDaily,
-		"start":             startStr,
-		"end":               endStr,
+		"message": "sync started",
+		"days":    days,
+		"start":   startStr,
+		"end":     endStr,
+		"force":   force,
 	})
 }
 
-func formatAggStats(stats []struct {
-	Name         string  `json:"name"`
-	TotalSeconds float64 `json:"total_seconds"`
-}, totalSeconds float64) []map[string]interface{} {
-	items := make([]map[string]interface{}, len(stats))
-	for i, s := range stats {
-		percent := float64(0)
-		if totalSeconds > 0 {
-			percent = (s.TotalSeconds / totalSeconds) * 100
+// wakatimeCheckTimeout bounds the connection check before starting a sync
+const wakatimeCheckTimeout = 15 * time.Second
+
+// wakatimeCheckTTL is how long the result of a connection check is reused, so
+// repeated sync requests do not each cost a request to WakaTime
+const wakatimeCheckTTL = 30 * time.Second
+
+// checkWakaTime verifies that WakaTime accepts the API key before a sync is
+// started in the background, so the caller learns right away about a bad key
+// or rate limiting. A running sync is reported first, without contacting
+// WakaTime. It writes the error response and returns false on failure.
+func (h *Handler) checkWakaTime(w http.ResponseWriter, r *http.Request) bool {
+	if h.syncer.IsSyncing() {
+		writeSyncStartError(w, sync.ErrSyncInProgress)
+		return false
+	}
+
+	h.wakatimeMu.Lock()
+	err, cached := h.wakatimeErr, time.Since(h.wakatimeCheckedAt) < wakatimeCheckTTL
+	h.wakatimeMu.Unlock()
+
+	if !cached {
+		ctx, cancel := context.WithTimeout(r.Context(), wakatimeCheckTimeout)
+		defer cancel()
+		_, err = h.syncer.GetUser(ctx)
+		// A cancelled request says nothing about WakaTime
+		if r.Context().Err() == nil {
+			h.wakatimeMu.Lock()
+			h.wakatimeErr, h.wakatimeCheckedAt = err, time.Now()
+			h.wakatimeMu.Unlock()
 		}
-		items[i] = map[string]interface{}{
-			"name":          s.Name,
-			"total_seconds": s.TotalSeconds,
-			"percent":       percent,
-			"text":          formatDuration(s.TotalSeconds),
+	}
+
+	if err != nil {
+		slog.Error("failed to reach wakatime before sync", "error", err, "cached", cached)
+		writeWakaTimeError(w, err)
+		return false
+	}
+	return true
+}
+
+// writeWakaTimeError writes an actionable response for a failed WakaTime request
+func writeWakaTimeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, wakatime.ErrUnauthorized):
+		writeError(w, http.StatusBadGateway, "wakatime rejected the configured api key, check wakatime_api_key")
+	case errors.Is(err, wakatime.ErrRateLimited):
+		var rateLimitErr *wakatime.RateLimitError
+		if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(rateLimitErr.RetryAfter.Seconds())))
 		}
+		writeError(w, http.StatusTooManyRequests, "rate limited by wakatime, try again later")
+	case errors.Is(err, wakatime.ErrNotFound):
+		writeError(w, http.StatusBadGateway, "wakatime api endpoint not found, check wakatime_base_url")
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, "wakatime did not respond in time")
+	default:
+		writeError(w, http.StatusBadGateway, "failed to connect to wakatime")
 	}
-	return items
 }
 
-// triggerSync manually triggers a sync
-// POST /api/v1/sync?days=7&api_key=xxx
-func (h *Handler) triggerSync(w http.ResponseWriter, r *http.Request) {
-	// Check API key
-	apiKey := r.URL.Query().Get("api_key")
-	if apiKey == "" {
-		apiKey = r.FormValue("apiKey")
+// writeSyncStartError writes the response for a sync that could not be started
+func writeSyncStartError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sync.ErrSyncInProgress) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
 	}
-	if apiKey != h.cfg.WakaTimeAPI {
+	slog.Error("failed to start sync", "error", err)
+	writeError(w, http.StatusInternalServerError, "failed to start sync")
+}
+
+// recomputeDay rebuilds a day's total and stats from the stored heartbeats,
+// without contacting WakaTime
+// POST /api/v1/recompute?date=2024-01-15&api_key=xxx
+func (h *Handler) recomputeDay(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("api_key")), []byte(h.cfg.WakaTimeAPI)) != 1 {
 		writeError(w, http.StatusUnauthorized, "invalid api key")
 		return
 	}
 
-	daysStr := r.URL.Query().Get("days")
-	if daysStr == "" {
-		daysStr = r.FormValue("day")
-	}
-	days, err := strconv.Atoi(daysStr)
-	if err != nil || days <= 0 {
-		days = 1
+	day, err := parseDate(r.URL.Query().Get("date"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid date format")
+		return
 	}
 
-	// Run sync in background
-	go func() {
-		if err := h.syncer.SyncDays(days); err != nil {
-			slog.Error("sync failed", "error", err)
+	totalSeconds, err := h.syncer.RecomputeDay(day)
+	if err != nil {
+		if errors.Is(err, sync.ErrNoHeartbeats) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, sync.ErrSyncInProgress) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
 		}
-		// Also sync projects
-		h.syncer.SyncProjects()
-	}()
+		slog.Error("failed to recompute day", "date", day.Format("2006-01-02"), "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to recompute day")
+		return
+	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"message": "sync started",
-		"days":    days,
+		"date":                      day.Format("2006-01-02"),
+		"total_seconds":             totalSeconds,
+		"heartbeat_timeout_minutes": h.cfg.HeartbeatTimeoutMinutes,
 	})
 }
 
-// getSyncStatus returns sync status
+// getSyncStatus returns the last synced day, the latest sync attempt, success/partial/failure
+// counts, recent failures and whether a sync is running
 // GET /api/v1/sync/status
 func (h *Handler) getSyncStatus(w http.ResponseWriter, r *http.Request) {
 	lastSynced, err := h.db.GetLastSyncedDay()
@@ -596,8 +2442,125 @@ func (h *Handler) getSyncStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Nothing synced yet
+	lastSyncedDay := ""
+	if !lastSynced.IsZero() {
+		lastSyncedDay = lastSynced.Format("2006-01-02")
+	}
+
+	stats, err := h.db.GetSyncStats(syncStatusRecentFailures)
+	if err != nil {
+		slog.Error("failed to get sync status", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get sync status")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"last_synced_day": lastSyncedDay,
+		"last_synced_at":  formatTime(stats.LastSyncedAt),
+		"last_sync_day":   stats.LastSyncDay,
+		"last_status":     stats.LastStatus,
+		"last_error":      stats.LastError,
+		"success_days":    stats.SuccessDays,
+		"partial_days":    stats.PartialDays,
+		"failed_days":     stats.FailedDays,
+		"recent_failures": stats.RecentFailures,
+		"syncing":         h.syncer.IsSyncing(),
+	})
+}
+
+const (
+	syncStatusRecentFailures = 10
+	defaultSyncHistoryLimit  = 50
+	maxSyncHistoryLimit      = 1000
+)
+
+// getSyncHistory returns the sync log, most recent first
+// GET /api/v1/sync/history?limit=50&offset=0
+func (h *Handler) getSyncHistory(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r, defaultSyncHistoryLimit, maxSyncHistoryLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, total, err := h.db.GetSyncLog(limit, offset)
+	if err != nil {
+		slog.Error("failed to get sync history", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get sync history")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":   entries,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// getSyncGaps returns the days from the start date (or start) to yesterday
+// (or end) without a successful sync. With fill=true and the api key, a sync
+// of exactly those days is started.
+// GET /api/v1/sync/gaps?start=2024-01-01&end=2024-01-31&fill=true&api_key=xxx
+func (h *Handler) getSyncGaps(w http.ResponseWriter, r *http.Request) {
+	loc := h.cfg.GetTimezone()
+	startDate := h.cfg.GetStartDate()
+	start := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, loc)
+	now := time.Now().In(loc)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -1)
+
+	var err error
+	if s := r.URL.Query().Get("start"); s != "" {
+		if start, err = time.ParseInLocation("2006-01-02", s, loc); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid start date format")
+			return
+		}
+	}
+	if s := r.URL.Query().Get("end"); s != "" {
+		if end, err = time.ParseInLocation("2006-01-02", s, loc); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid end date format")
+			return
+		}
+	}
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, "start date must be before end date")
+		return
+	}
+
+	missing, err := h.db.GetMissingDays(start, end)
+	if err != nil {
+		slog.Error("failed to get sync gaps", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get sync gaps")
+		return
+	}
+
+	fill, _ := strconv.ParseBool(r.URL.Query().Get("fill"))
+	if fill && len(missing) > 0 {
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("api_key")), []byte(h.cfg.WakaTimeAPI)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid api key")
+			return
+		}
+		if !h.checkWakaTime(w, r) {
+			return
+		}
+		if err := h.syncer.StartSyncDays(missing, false); err != nil {
+			writeSyncStartError(w, err)
+			return
+		}
+	}
+
+	days := make([]string, len(missing))
+	for i, d := range missing {
+		days[i] = d.Format("2006-01-02")
+	}
+
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"last_synced_day": lastSynced.Format("2006-01-02"),
+		"start":   start.Format("2006-01-02"),
+		"end":     end.Format("2006-01-02"),
+		"days":    days,
+		"count":   len(days),
+		"filling": fill && len(days) > 0,
 	})
 }
 
@@ -616,12 +2579,37 @@ func (h *Handler) getAvailableYears(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// getActivityYears returns all years that have activity data, and the first
+// and last day with data
+// GET /api/v1/activity/years
+func (h *Handler) getActivityYears(w http.ResponseWriter, r *http.Request) {
+	years, err := h.db.GetAvailableYears()
+	if err != nil {
+		slog.Error("failed to get available years", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get available years")
+		return
+	}
+
+	earliest, latest, err := h.db.GetActivityBounds()
+	if err != nil {
+		slog.Error("failed to get activity bounds", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get available years")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"years":    years,
+		"earliest": earliest,
+		"latest":   latest,
+	})
+}
+
 // getYearlyActivity returns daily activity data for an entire year (for heatmap)
 // GET /api/v1/stats/yearly?year=2024
 func (h *Handler) getYearlyActivity(w http.ResponseWriter, r *http.Request) {
 	yearStr := r.URL.Query().Get("year")
 	if yearStr == "" {
-		yearStr = strconv.Itoa(time.Now().Year())
+		yearStr = strconv.Itoa(h.today().Year())
 	}
 
 	year, err := strconv.Atoi(yearStr)
@@ -638,13 +2626,207 @@ func (h *Handler) getYearlyActivity(w http.ResponseWriter, r *http.Request) {
 	}
 
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"year": year,
-		"data": activity,
+		"year":       year,
+		"data":       activity,
+		"week_start": h.cfg.WeekStart,
+	})
+}
+
+// getActivity returns daily activity data for any date range, e.g. a rolling
+// 12-month heatmap. Defaults to the last 365 days.
+// GET /api/v1/activity?start=2024-01-01&end=2024-12-31
+func (h *Handler) getActivity(w http.ResponseWriter, r *http.Request) {
+	startStr := r.URL.Query().Get("start")
+	endStr := r.URL.Query().Get("end")
+
+	if startStr == "" || endStr == "" {
+		endStr = h.today().Format("2006-01-02")
+		startStr = h.today().AddDate(0, 0, -364).Format("2006-01-02")
+	}
+
+	start, err := parseDate(startStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid start date format")
+		return
+	}
+
+	end, err := parseDate(endStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid end date format")
+		return
+	}
+
+	if start.After(end) {
+		writeError(w, http.StatusBadRequest, "start date must be before end date")
+		return
+	}
+
+	activity, err := h.db.GetActivityRange(start, end)
+	if err != nil {
+		slog.Error("failed to get activity", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get activity")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":       activity,
+		"week_start": h.cfg.WeekStart,
+		"start":      startStr,
+		"end":        endStr,
 	})
 }
 
+// getUser returns the current WakaTime user, fetched live to verify the API key
+// GET /api/v1/user
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.syncer.GetUser(r.Context())
+	if err != nil {
+		slog.Error("failed to get wakatime user", "error", err)
+		writeWakaTimeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": user,
+	})
+}
+
+// activeStatusCacheTTL is how long getActiveStatus reuses its last result
+const activeStatusCacheTTL = 30 * time.Second
+
+// getActiveStatus reports whether the most recent heartbeat is within the
+// configured active window. Today's heartbeats are fetched live from WakaTime,
+// falling back to the stored heartbeats when that fails or there are none yet
+// today.
+// GET /api/v1/status/active
+func (h *Handler) getActiveStatus(w http.ResponseWriter, r *http.Request) {
+	for {
+		h.activeMu.Lock()
+		if h.activeStatus != nil && time.Since(h.activeCachedAt) < activeStatusCacheTTL {
+			status := h.activeStatus
+			h.activeMu.Unlock()
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+		fetch := h.activeFetch
+		if fetch == nil {
+			break
+		}
+		h.activeMu.Unlock()
+
+		// Another request is fetching, use its result
+		select {
+		case <-fetch:
+		case <-r.Context().Done():
+			return
+		}
+		h.activeMu.Lock()
+		status := h.activeStatus
+		h.activeMu.Unlock()
+		if status != nil {
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+		// It failed, try again
+	}
+	fetch := make(chan struct{})
+	h.activeFetch = fetch
+	h.activeMu.Unlock()
+
+	// Waiting requests share the result, so the fetch outlives this one
+	status, err := h.fetchActiveStatus(context.WithoutCancel(r.Context()))
+
+	h.activeMu.Lock()
+	if err == nil {
+		h.activeStatus = status
+		h.activeCachedAt = time.Now()
+	}
+	h.activeFetch = nil
+	close(fetch)
+	h.activeMu.Unlock()
+
+	if err != nil {
+		slog.Error("failed to get latest heartbeat", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get active status")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// fetchActiveStatus builds the response of getActiveStatus
+func (h *Handler) fetchActiveStatus(ctx context.Context) (map[string]interface{}, error) {
+	var (
+		lastTime float64
+		project  string
+		language string
+		source   string
+	)
+
+	live, err := h.syncer.GetLatestHeartbeat(ctx)
+	if err != nil {
+		slog.Warn("failed to fetch live heartbeats, falling back to stored data", "error", err)
+	}
+	if live != nil {
+		source = "live"
+		lastTime, project, language = live.Time, live.Project, live.Language
+	} else {
+		stored, err := h.db.GetLatestHeartbeat()
+		if err != nil {
+			return nil, err
+		}
+		source = "stored"
+		if stored != nil {
+			lastTime, project, language = stored.Time, stored.Project, stored.Language
+		}
+	}
+
+	window := h.cfg.GetActiveWindow()
+	status := map[string]interface{}{
+		"active":            false,
+		"last_heartbeat_at": "",
+		"project":           project,
+		"language":          language,
+		"active_window":     window.String(),
+		"source":            source,
+	}
+	if lastTime > 0 {
+		last := time.Unix(0, int64(lastTime*float64(time.Second))).In(h.cfg.GetTimezone())
+		status["active"] = time.Since(last) <= window
+		status["last_heartbeat_at"] = last.Format(time.RFC3339)
+	}
+	return status, nil
+}
+
 func (h *Handler) healthCheck(w http.ResponseWriter, r *http.Request) {
+	// The server is alive either way, the sync state is for monitoring
+	lastSync := h.syncer.LastScheduledSync()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":              "ok",
+		"version":             version.Version,
+		"commit":              version.Commit,
+		"started_at":          h.startedAt.Format(time.RFC3339),
+		"uptime_seconds":      int(time.Since(h.startedAt).Seconds()),
+		"last_scheduled_sync": lastSync,
+		"sync_healthy":        lastSync == nil || lastSync.Error == "",
+	})
+}
+
+// readyCheck reports whether the server can serve and sync data: the database
+// is reachable and WakaTime accepted the API key. Unlike /health it returns 503
+// until then, for readiness probes.
+// GET /readyz
+func (h *Handler) readyCheck(w http.ResponseWriter, r *http.Request) {
+	if err := h.db.PingContext(r.Context()); err != nil {
+		slog.Error("database not ready", "error", err)
+		writeError(w, http.StatusServiceUnavailable, "database not ready")
+		return
+	}
+	if !h.syncer.Ready() {
+		writeError(w, http.StatusServiceUnavailable, "wakatime credentials not validated yet")
+		return
+	}
+
 	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"status": "ok",
+		"status": "ready",
 	})
 }
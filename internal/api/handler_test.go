@@ -0,0 +1,392 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/config"
+	"github.com/charlie0129/wakatime-sync-go/internal/database"
+	"github.com/charlie0129/wakatime-sync-go/internal/sync"
+)
+
+func TestMergeOverlappingDurations(t *testing.T) {
+	d := func(project string, start, duration float64) database.Duration {
+		return database.Duration{Project: project, StartTime: start, Duration: duration}
+	}
+	// span is a merged duration as project, start and duration
+	type span struct {
+		project         string
+		start, duration float64
+	}
+
+	tests := []struct {
+		name      string
+		durations []database.Duration
+		want      []span
+	}{
+		{
+			name: "empty",
+			want: []span{},
+		},
+		{
+			name:      "overlapping",
+			durations: []database.Duration{d("a", 100, 60), d("a", 130, 60)},
+			want:      []span{{"a", 100, 90}},
+		},
+		{
+			name:      "adjacent",
+			durations: []database.Duration{d("a", 100, 60), d("a", 160, 30)},
+			want:      []span{{"a", 100, 90}},
+		},
+		{
+			name:      "gap",
+			durations: []database.Duration{d("a", 100, 60), d("a", 161, 30)},
+			want:      []span{{"a", 100, 60}, {"a", 161, 30}},
+		},
+		{
+			name:      "contained",
+			durations: []database.Duration{d("a", 100, 60), d("a", 110, 10)},
+			want:      []span{{"a", 100, 60}},
+		},
+		{
+			name:      "unsorted",
+			durations: []database.Duration{d("a", 130, 60), d("a", 100, 60)},
+			want:      []span{{"a", 100, 90}},
+		},
+		{
+			name:      "other project overlapping",
+			durations: []database.Duration{d("a", 100, 60), d("b", 130, 60), d("a", 150, 20)},
+			want:      []span{{"a", 100, 70}, {"b", 130, 60}},
+		},
+		{
+			name:      "chain",
+			durations: []database.Duration{d("a", 100, 10), d("a", 110, 10), d("a", 115, 20)},
+			want:      []span{{"a", 100, 35}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []span{}
+			for _, m := range mergeOverlappingDurations(tt.durations) {
+				got = append(got, span{m.Project, m.StartTime, m.Duration})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeOverlappingDurationsSumsLineChanges(t *testing.T) {
+	merged := mergeOverlappingDurations([]database.Duration{
+		{Project: "a", StartTime: 100, Duration: 60, AIAdditions: 1, HumanAdditions: 2, HumanDeletions: 3},
+		{Project: "a", StartTime: 120, Duration: 60, AIAdditions: 4, AIDeletions: 5, HumanAdditions: 6},
+	})
+	if len(merged) != 1 {
+		t.Fatalf("got %d durations, want 1", len(merged))
+	}
+	m := merged[0]
+	if m.AIAdditions != 5 || m.AIDeletions != 5 || m.HumanAdditions != 8 || m.HumanDeletions != 3 {
+		t.Errorf("line changes = %d/%d/%d/%d, want 5/5/8/3", m.AIAdditions, m.AIDeletions, m.HumanAdditions, m.HumanDeletions)
+	}
+}
+
+// newTestHandler returns a handler with an empty database and its API routes.
+// WakaTime is not reachable, unless configure, which can change any option,
+// sets WakaTimeBaseURL.
+func newTestHandler(t *testing.T, configure ...func(*config.Config)) (*Handler, http.Handler) {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), database.Options{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg := &config.Config{
+		WakaTimeAPI:             "test",
+		WakaTimeBaseURL:         "http://127.0.0.1:1",
+		Timezone:                "UTC",
+		StartDate:               "2016-01-01",
+		MaxEventSubscribers:     1,
+		ActiveWindow:            "5m",
+		DigitalFormat:           config.DigitalFormatShort,
+		WeekStart:               config.WeekStartMonday,
+		HeartbeatTimeoutMinutes: config.DefaultHeartbeatTimeoutMinutes,
+	}
+	for _, f := range configure {
+		f(cfg)
+	}
+	h := NewHandler(cfg, db, sync.NewSyncer(cfg, db))
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux, nil)
+	return h, mux
+}
+
+// emptyDBNullable are the response fields that are null by design when there
+// is no data, e.g. the offset of a next page that does not exist
+var emptyDBNullable = map[string]bool{
+	"next":                 true,
+	"days_since_last_used": true,
+}
+
+// findNulls returns the paths of the null values in a decoded JSON value
+func findNulls(v interface{}, path string) []string {
+	var nulls []string
+	switch v := v.(type) {
+	case nil:
+		return []string{path}
+	case map[string]interface{}:
+		for k, child := range v {
+			if child == nil && emptyDBNullable[k] {
+				continue
+			}
+			nulls = append(nulls, findNulls(child, path+"."+k)...)
+		}
+	case []interface{}:
+		for i, child := range v {
+			nulls = append(nulls, findNulls(child, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return nulls
+}
+
+func TestReadEndpointsOnEmptyDatabase(t *testing.T) {
+	_, mux := newTestHandler(t)
+
+	// A goal without any coding activity
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/goals",
+		strings.NewReader(`{"title": "Code daily", "target_seconds": 3600}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("failed to create goal: status %d: %s", rec.Code, rec.Body)
+	}
+
+	for _, path := range emptyDBEndpoints {
+		t.Run(path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var body interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON: %v: %s", err, rec.Body)
+			}
+			if nulls := findNulls(body, ""); len(nulls) > 0 {
+				t.Errorf("null values at %v: %s", nulls, rec.Body)
+			}
+		})
+	}
+}
+
+// emptyDBEndpoints are the read endpoints that only read the database
+var emptyDBEndpoints = []string{
+	"/api/v1/users/current/durations?date=2024-01-01",
+	"/api/v1/users/current/heartbeats?date=2024-01-01",
+	"/api/v1/heartbeats/search?q=main",
+	"/api/v1/users/current/summaries?start=2024-01-01&end=2024-01-07",
+	"/api/v1/users/current/summaries?range=today",
+	"/api/v1/users/current/projects",
+	"/api/v1/users/current/machine_names",
+	"/api/v1/users/current/stats/last_7_days",
+	"/api/v1/users/current/stats/last_year",
+	"/api/v1/users/current/stats/all_time",
+	"/api/v1/users/current/summaries?start=2024-01-01&end=2024-01-07&project=a",
+	"/api/v1/projects/a/languages?start=2024-01-01&end=2024-01-07",
+	"/api/v1/projects/aliases",
+	"/api/v1/goals/wakatime",
+	"/api/v1/goals",
+	"/api/v1/goals/1",
+	"/api/v1/goals/1/progress?start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/daily?start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/daily?start=2024-01-01&end=2024-01-07&language=Go",
+	"/api/v1/stats/range?start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/range?start=2024-01-01&end=2024-01-07&language=Go",
+	"/api/v1/stats/years",
+	"/api/v1/stats/yearly?year=2024",
+	"/api/v1/stats/lines?start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/hourly?start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/weekdays?start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/weekly?start=2024-01-01&end=2024-01-31",
+	"/api/v1/stats/series?type=language&start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/series?type=project&start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/series?type=editor&start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/series?type=machine&start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/series?type=category&start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/series?type=os&start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/series?type=branch&start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/series?type=entity&start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/series?type=dependency&start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/alltime",
+	"/api/v1/stats/averages",
+	"/api/v1/stats/compare?start=2024-02-01&end=2024-02-29",
+	"/api/v1/stats/languages?start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/languages/Go/activity",
+	"/api/v1/stats/editors?start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/projects?start=2024-01-01&end=2024-01-07",
+	"/api/v1/stats/projects?start=2024-01-01&end=2024-01-07&project=a",
+	"/api/v1/stats/hourly?start=2024-01-01&end=2024-01-07&project=a",
+	"/api/v1/stats/lines?start=2024-01-01&end=2024-01-07&project=a",
+	"/api/v1/activity?start=2024-01-01&end=2024-01-07",
+	"/api/v1/activity/years",
+	"/api/v1/export?type=summaries&start=2024-01-01&end=2024-01-07&format=json",
+	"/api/v1/export?type=durations&start=2024-01-01&end=2024-01-07&format=json",
+	"/api/v1/export?type=heartbeats&start=2024-01-01&end=2024-01-07&format=json",
+	"/api/v1/sync/status",
+	"/api/v1/sync/history",
+	"/api/v1/sync/gaps?start=2024-01-01&end=2024-01-07&api_key=test",
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	handler := BodyLimitMiddleware(10, 100, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			bodyError(w, err, "invalid request body")
+		}
+	}))
+
+	tests := []struct {
+		path string
+		size int
+		want int
+	}{
+		{"/api/v1/goals", 10, http.StatusOK},
+		{"/api/v1/goals", 11, http.StatusRequestEntityTooLarge},
+		{"/api/v1/import/dump", 100, http.StatusOK},
+		{"/api/v2/import/dump", 101, http.StatusRequestEntityTooLarge},
+		{"/api/v1/users/current/heartbeats.bulk", 100, http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, announced := range []bool{true, false} {
+			body := io.Reader(strings.NewReader(strings.Repeat("x", tt.size)))
+			if !announced {
+				// Hide the length, so the body is only limited while reading
+				body = io.MultiReader(body)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, body))
+			if rec.Code != tt.want {
+				t.Errorf("%s with %d bytes (length announced: %v): status %d, want %d", tt.path, tt.size, announced, rec.Code, tt.want)
+			}
+		}
+	}
+}
+
+func TestBulkHeartbeatsLimit(t *testing.T) {
+	_, mux := newTestHandler(t)
+	body := "[" + strings.Repeat("{},", maxBulkHeartbeats) + "{}]"
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/users/current/heartbeats.bulk?api_key=test", strings.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("%d heartbeats: status %d, want %d", maxBulkHeartbeats+1, rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestActiveStatusFallsBackToStoredHeartbeats(t *testing.T) {
+	// WakaTime has no heartbeats today
+	var fetches atomic.Int32
+	wakatime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte(`{"data": []}`))
+	}))
+	defer wakatime.Close()
+
+	h, _ := newTestHandler(t, func(cfg *config.Config) { cfg.WakaTimeBaseURL = wakatime.URL })
+	last := time.Now().Add(-48 * time.Hour)
+	if err := h.db.InsertHeartbeats([]database.HeartBeat{
+		{Day: last, Entity: "/src/a.go", Time: float64(last.Unix()), Project: "p", Language: "Go"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg gosync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h.getActiveStatus(rec, httptest.NewRequest(http.MethodGet, "/api/v1/status/active", nil))
+			var status struct {
+				Active  bool   `json:"active"`
+				Project string `json:"project"`
+				Source  string `json:"source"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+				t.Errorf("invalid response: %v: %s", err, rec.Body)
+				return
+			}
+			if status.Active || status.Project != "p" || status.Source != "stored" {
+				t.Errorf("status = %+v, want inactive in project p from stored heartbeats", status)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetched today's heartbeats %d times, want 1", n)
+	}
+}
+
+func TestCheckWakaTimeReusesResult(t *testing.T) {
+	var checks atomic.Int32
+	wakatime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer wakatime.Close()
+
+	h, _ := newTestHandler(t, func(cfg *config.Config) { cfg.WakaTimeBaseURL = wakatime.URL })
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		if h.checkWakaTime(rec, httptest.NewRequest(http.MethodPost, "/api/v1/sync", nil)) {
+			t.Fatalf("check %d passed with a rejected api key", i+1)
+		}
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("check %d: status %d, want %d", i+1, rec.Code, http.StatusBadGateway)
+		}
+	}
+	if n := checks.Load(); n != 1 {
+		t.Errorf("contacted wakatime %d times, want 1", n)
+	}
+}
+
+func TestProjectsArePaginatedOnlyOnRequest(t *testing.T) {
+	h, _ := newTestHandler(t)
+	for i := 0; i < defaultProjectsLimit+10; i++ {
+		if err := h.db.UpsertProject(&database.Project{Name: fmt.Sprintf("p%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", defaultProjectsLimit + 10},
+		{"?offset=0", defaultProjectsLimit},
+		{"?limit=5", 5},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.getProjects(rec, httptest.NewRequest(http.MethodGet, "/api/v1/users/current/projects"+tt.query, nil))
+		var resp struct {
+			Data  []json.RawMessage `json:"data"`
+			Total int               `json:"total"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%q: invalid response: %v: %s", tt.query, err, rec.Body)
+		}
+		if len(resp.Data) != tt.want || resp.Total != defaultProjectsLimit+10 {
+			t.Errorf("%q: %d of %d projects, want %d of %d", tt.query, len(resp.Data), resp.Total, tt.want, defaultProjectsLimit+10)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/wakatime"
+)
+
+// maxBulkHeartbeats is the most heartbeats accepted in one bulk request,
+// matching WakaTime's own limit
+const maxBulkHeartbeats = 25 * 1024
+
+// maxBulkBytes is the largest bulk request body accepted, enough for
+// maxBulkHeartbeats heartbeats of 2 KiB each
+const maxBulkBytes = maxBulkHeartbeats * 2048
+
+// ingestAPIKey returns the API key of a heartbeat request, either from the
+// api_key query param or HTTP basic auth as sent by WakaTime plugins
+// ("Authorization: Basic <base64 of the key>").
+func ingestAPIKey(r *http.Request) string {
+	if key := r.URL.Query().Get("api_key"); key != "" {
+		return key
+	}
+	encoded, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Basic ")
+	if !ok {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return ""
+	}
+	// Some clients send "key:" like a username with an empty password
+	key, _, _ := strings.Cut(string(decoded), ":")
+	return key
+}
+
+// validateHeartbeat returns why a heartbeat cannot be stored, or "" if it can
+func validateHeartbeat(hb wakatime.HeartbeatData, now time.Time) string {
+	if hb.Entity == "" {
+		return "entity is required"
+	}
+	if hb.Time <= 0 {
+		return "time is required"
+	}
+	if hb.Time > float64(now.Add(24*time.Hour).Unix()) {
+		return "time is in the future"
+	}
+	return ""
+}
+
+// ingestHeartbeats stores heartbeats pushed by editor plugins or scripts, in
+// the same format WakaTime plugins send to WakaTime. The response mirrors
+// WakaTime's: a result and status code per heartbeat, in request order.
+// POST /api/v1/users/current/heartbeats.bulk?api_key=xxx
+func (h *Handler) ingestHeartbeats(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(ingestAPIKey(r)), []byte(h.cfg.WakaTimeAPI)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+
+	// The array is decoded one heartbeat at a time, so requests with too many
+	// are rejected before they are all in memory
+	const invalidBody = "invalid request body, expected an array of heartbeats"
+	dec := json.NewDecoder(r.Body)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		bodyError(w, err, invalidBody)
+		return
+	}
+	var heartbeats []wakatime.HeartbeatData
+	for dec.More() {
+		if len(heartbeats) == maxBulkHeartbeats {
+			writeError(w, http.StatusRequestEntityTooLarge, "too many heartbeats")
+			return
+		}
+		var hb wakatime.HeartbeatData
+		if err := dec.Decode(&hb); err != nil {
+			bodyError(w, err, invalidBody)
+			return
+		}
+		heartbeats = append(heartbeats, hb)
+	}
+	if _, err := dec.Token(); err != nil {
+		bodyError(w, err, invalidBody)
+		return
+	}
+
+	// Plugins send the machine name as a header rather than per heartbeat
+	machine := r.Header.Get("X-Machine-Name")
+	now := time.Now()
+
+	responses := make([][]interface{}, len(heartbeats))
+	var valid []wakatime.HeartbeatData
+	for i, hb := range heartbeats {
+		if hb.MachineNameID == "" {
+			hb.MachineNameID = machine
+		}
+		if msg := validateHeartbeat(hb, now); msg != "" {
+			responses[i] = []interface{}{map[string]interface{}{"error": msg}, http.StatusBadRequest}
+			continue
+		}
+		responses[i] = []interface{}{map[string]interface{}{"data": hb}, http.StatusCreated}
+		valid = append(valid, hb)
+	}
+
+	if len(valid) > 0 {
+		if _, err := h.syncer.IngestHeartbeats(valid); err != nil {
+			slog.Error("failed to ingest heartbeats", "error", err)
+			writeError(w, http.StatusInternalServerError, "failed to store heartbeats")
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"responses": responses,
+	})
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes the API. It is maintained by hand, so update it along
+// with the routes in RegisterRoutes.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// getOpenAPI serves the OpenAPI 3 document of the API
+// GET /api/v1/openapi.json
+func (h *Handler) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultQueryRows and maxQueryRows bound the rows returned by a query
+	defaultQueryRows = 1000
+	maxQueryRows     = 10000
+	// queryTimeout is how long a query may run before it is interrupted
+	queryTimeout = 5 * time.Second
+)
+
+// runQuery runs a single read-only SELECT against the database, e.g. for
+// analyses the API does not offer. Anything but one SELECT is rejected, and
+// it runs on a read-only connection with a timeout.
+// POST /api/v1/query?api_key=xxx {"query": "SELECT ...", "limit": 1000}
+func (h *Handler) runQuery(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("api_key")), []byte(h.cfg.WakaTimeAPI)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+
+	var req struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Query == "" {
+		bodyError(w, err, "invalid request body, expected {\"query\": \"SELECT ...\"}")
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultQueryRows
+	}
+	req.Limit = min(req.Limit, maxQueryRows)
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	result, err := h.db.ReadOnlyQuery(ctx, req.Query, req.Limit)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			writeError(w, http.StatusGatewayTimeout, "query timed out after "+queryTimeout.String())
+			return
+		}
+		// Errors are about the query itself, e.g. a syntax error
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often buckets of idle clients are dropped
+const rateLimitSweepInterval = time.Minute
+
+// RateLimiter limits API requests per client IP with a token bucket: each
+// client may burst up to the per-minute limit, which refills continuously.
+type RateLimiter struct {
+	perMinute float64
+	trusted   []netip.Prefix
+
+	mu        gosync.Mutex
+	buckets   map[netip.Addr]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests per client.
+// trustedProxies are the IPs or CIDRs of reverse proxies whose
+// X-Forwarded-For header is used to find the client IP.
+func NewRateLimiter(perMinute int, trustedProxies []string) (*RateLimiter, error) {
+	l := &RateLimiter{
+		perMinute: float64(perMinute),
+		buckets:   make(map[netip.Addr]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	for _, p := range trustedProxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(p)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, expected an IP or CIDR", p)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		l.trusted = append(l.trusted, prefix.Masked())
+	}
+	return l, nil
+}
+
+// Middleware rate limits requests to /api/. /health, /readyz and the static
+// frontend are never limited.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip, ok := l.clientIP(r)
+		if ok {
+			if allowed, retryAfter := l.allow(ip, time.Now()); !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *RateLimiter) isTrusted(ip netip.Addr) bool {
+	for _, p := range l.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client. Behind a trusted proxy, it is the
+// last address in X-Forwarded-For that is not itself a trusted proxy, since
+// clients can put anything at the start of the header.
+func (l *RateLimiter) clientIP(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	ip = ip.Unmap()
+	if !l.isTrusted(ip) {
+		return ip, true
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		hop = hop.Unmap()
+		if !l.isTrusted(hop) {
+			return hop, true
+		}
+		ip = hop
+	}
+	return ip, true
+}
+
+// allow takes a token from the client's bucket. If there is none, it returns
+// how long until there is.
+func (l *RateLimiter) allow(ip netip.Addr, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	perSecond := l.perMinute / 60
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		// Buckets that refilled completely are the same as new ones
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*perSecond >= l.perMinute {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: l.perMinute, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.perMinute, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
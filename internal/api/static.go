@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// staticHandler serves the built frontend from static, or a JSON pointer to
+// the API when it was not built, e.g. for API-only deployments. GETs of paths
+// that are not files serve index.html, so client-side routes work on reload.
+// Unknown /api/ paths are answered by apiNotFound.
+func staticHandler(static fs.FS) http.Handler {
+	if static == nil {
+		return http.HandlerFunc(noFrontend)
+	}
+	if _, err := fs.Stat(static, "index.html"); err != nil {
+		slog.Warn("frontend not found, serving the API only")
+		return http.HandlerFunc(noFrontend)
+	}
+
+	fileServer := http.FileServerFS(static)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+		if _, err := fs.Stat(static, name); errors.Is(err, fs.ErrNotExist) {
+			http.ServeFileFS(w, r, static, "index.html")
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// noFrontend answers requests for the frontend when it is not available
+func noFrontend(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "the frontend is not available, the API is served under /api/v1",
+		"api":     "/api/v1",
+		"health":  "/health",
+	})
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// v2Passthrough lists v1 endpoints that stream non-JSON responses, serve a
+// document as is or extend the connection's deadlines, which needs the
+// connection's writer. They are served under /api/v2 unchanged instead of
+// being wrapped in the envelope.
+var v2Passthrough = map[string]bool{
+	"/api/v1/export":       true,
+	"/api/v1/export/dump":  true,
+	"/api/v1/import/dump":  true,
+	"/api/v1/sync/events":  true,
+	"/api/v1/badge":        true,
+	"/api/v1/badge.json":   true,
+	"/api/v1/openapi.json": true,
+}
+
+// bufferedResponseWriter captures a response so it can be rewritten
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// v2Handler serves every v1 endpoint under /api/v2 with a consistent envelope:
+// successful responses are always {"data": <v1 response>} and errors are
+// always {"error": "..."}, so clients never have to guess where the payload is.
+func (h *Handler) v2Handler(v1 http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r1 := r.Clone(r.Context())
+		r1.URL.Path = "/api/v1/" + strings.TrimPrefix(r.URL.Path, "/api/v2/")
+		r1.URL.RawPath = ""
+
+		if v2Passthrough[r1.URL.Path] {
+			v1.ServeHTTP(w, r1)
+			return
+		}
+
+		rec := &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+		v1.ServeHTTP(rec, r1)
+
+		for k, v := range rec.header {
+			if k == "Content-Type" || k == "Content-Length" {
+				continue
+			}
+			w.Header()[k] = v
+		}
+
+		body := bytes.TrimSpace(rec.body.Bytes())
+		if rec.status >= http.StatusBadRequest {
+			// Keep the v1 error message if there is one, e.g. not for 404s
+			// from the file server
+			var resp APIResponse
+			if err := json.Unmarshal(body, &resp); err != nil || resp.Error == "" {
+				resp.Error = strings.ToLower(http.StatusText(rec.status))
+			}
+			writeError(w, rec.status, resp.Error)
+			return
+		}
+
+		// e.g. 204 No Content
+		if len(body) == 0 {
+			w.WriteHeader(rec.status)
+			return
+		}
+		if !json.Valid(body) {
+			writeError(w, http.StatusInternalServerError, "invalid response")
+			return
+		}
+		writeJSON(w, rec.status, APIResponse{Data: json.RawMessage(body)})
+	})
+}
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -16,6 +21,46 @@ type Config struct {
 	StartDate       string `yaml:"start_date"`
 	SyncSchedule    string `yaml:"sync_schedule"` // cron expression for daily sync
 	Timezone        string `yaml:"timezone"`
+	MaxRetries      int    `yaml:"max_retries"` // retries on 429/5xx responses from WakaTime, 0 disables
+
+	MirrorDatabasePath  string `yaml:"mirror_database_path"`  // optional second database all writes are mirrored to
+	DBBusyTimeout       int    `yaml:"db_busy_timeout"`       // milliseconds to wait for a locked database before failing
+	DBJournalMode       string `yaml:"db_journal_mode"`       // SQLite journal mode, WAL, DELETE, TRUNCATE or PERSIST
+	DBMaxOpenConns      int    `yaml:"db_max_open_conns"`     // connections to the database, 1 serializes all access
+	DBMaxIdleConns      int    `yaml:"db_max_idle_conns"`     // connections kept open between requests
+	MaxEventSubscribers int    `yaml:"max_event_subscribers"` // concurrent clients of the sync events stream
+	ActiveWindow        string `yaml:"active_window"`         // how recent the last heartbeat must be to count as active, e.g. "5m"
+	MinProjectSeconds   int    `yaml:"min_project_seconds"`   // hide projects with less total time from the project list
+	MinSessionSeconds   int    `yaml:"min_session_seconds"`   // days with less time count as zero for goals and streaks
+	APIToken            string `yaml:"api_token"`             // if set, required as a bearer token on all API routes
+	WebhookURL          string `yaml:"webhook_url"`           // POSTed to after every synced day
+	WebhookSecret       string `yaml:"webhook_secret"`        // signs webhook bodies with HMAC-SHA256 if set
+	TodaySyncInterval   string `yaml:"today_sync_interval"`   // how often to append today's new heartbeats, e.g. "15m"; empty disables
+	SyncLookbackDays    int    `yaml:"sync_lookback_days"`    // days up to yesterday re-synced by every scheduled sync, 1 syncs only yesterday
+
+	SyncProjectBreakdowns bool `yaml:"sync_project_breakdowns"` // sync branches and entities, at one extra request per project and day
+
+	ExcludeProjects []string `yaml:"exclude_projects"` // glob patterns of projects whose time is never stored, e.g. "scratch-*"
+	IncludeProjects []string `yaml:"include_projects"` // glob patterns of the only projects whose time is stored, empty stores all
+
+	AccessLog bool `yaml:"access_log"` // log every request with its status and duration
+
+	MaxRequestBytes int64 `yaml:"max_request_bytes"` // largest request body accepted; larger ones get a 413
+	MaxImportBytes  int64 `yaml:"max_import_bytes"`  // largest body of dump imports
+
+	RateLimitPerMinute int      `yaml:"rate_limit_per_minute"` // API requests allowed per client IP and minute, 0 disables
+	TrustedProxies     []string `yaml:"trusted_proxies"`       // IPs or CIDRs of reverse proxies whose X-Forwarded-For is trusted
+
+	DigitalFormat string `yaml:"digital_format"` // format of the "digital" durations in summaries, "H:MM" or "HH:MM:SS"
+	WeekStart     string `yaml:"week_start"`     // first day of the week for weekly rollups and the heatmap, "monday" or "sunday"
+
+	// HeartbeatTimeoutMinutes is the idle gap after which consecutive
+	// heartbeats no longer count as one session when computing totals locally
+	HeartbeatTimeoutMinutes int `yaml:"heartbeat_timeout_minutes"`
+
+	// Debugging
+	DebugSaveFailedResponses bool   `yaml:"debug_save_failed_responses"`
+	DebugResponsesDir        string `yaml:"debug_responses_dir"`
 }
 
 func Load(path string) (*Config, error) {
@@ -41,6 +86,27 @@ func Load(path string) (*Config, error) {
 	if envDatabasePath := os.Getenv("DATABASE_PATH"); envDatabasePath != "" {
 		cfg.DatabasePath = envDatabasePath
 	}
+	if envMirrorDatabasePath := os.Getenv("MIRROR_DATABASE_PATH"); envMirrorDatabasePath != "" {
+		cfg.MirrorDatabasePath = envMirrorDatabasePath
+	}
+	if envDBBusyTimeout := os.Getenv("DB_BUSY_TIMEOUT"); envDBBusyTimeout != "" {
+		if n, err := strconv.Atoi(envDBBusyTimeout); err == nil {
+			cfg.DBBusyTimeout = n
+		}
+	}
+	if envDBJournalMode := os.Getenv("DB_JOURNAL_MODE"); envDBJournalMode != "" {
+		cfg.DBJournalMode = envDBJournalMode
+	}
+	if envDBMaxOpenConns := os.Getenv("DB_MAX_OPEN_CONNS"); envDBMaxOpenConns != "" {
+		if n, err := strconv.Atoi(envDBMaxOpenConns); err == nil {
+			cfg.DBMaxOpenConns = n
+		}
+	}
+	if envDBMaxIdleConns := os.Getenv("DB_MAX_IDLE_CONNS"); envDBMaxIdleConns != "" {
+		if n, err := strconv.Atoi(envDBMaxIdleConns); err == nil {
+			cfg.DBMaxIdleConns = n
+		}
+	}
 	if envWakaTimeAPI := os.Getenv("WAKATIME_API_KEY"); envWakaTimeAPI != "" {
 		cfg.WakaTimeAPI = envWakaTimeAPI
 	}
@@ -59,6 +125,93 @@ func Load(path string) (*Config, error) {
 	if envTimezone := os.Getenv("TZ"); envTimezone != "" {
 		cfg.Timezone = envTimezone
 	}
+	if envMaxRetries := os.Getenv("MAX_RETRIES"); envMaxRetries != "" {
+		if n, err := strconv.Atoi(envMaxRetries); err == nil {
+			cfg.MaxRetries = n
+		}
+	}
+	if envSyncLookbackDays := os.Getenv("SYNC_LOOKBACK_DAYS"); envSyncLookbackDays != "" {
+		if n, err := strconv.Atoi(envSyncLookbackDays); err == nil {
+			cfg.SyncLookbackDays = n
+		}
+	}
+	if envSyncProjectBreakdowns := os.Getenv("SYNC_PROJECT_BREAKDOWNS"); envSyncProjectBreakdowns != "" {
+		cfg.SyncProjectBreakdowns = envSyncProjectBreakdowns == "1" || envSyncProjectBreakdowns == "true"
+	}
+	if envExcludeProjects := os.Getenv("EXCLUDE_PROJECTS"); envExcludeProjects != "" {
+		cfg.ExcludeProjects = strings.Split(envExcludeProjects, ",")
+	}
+	if envIncludeProjects := os.Getenv("INCLUDE_PROJECTS"); envIncludeProjects != "" {
+		cfg.IncludeProjects = strings.Split(envIncludeProjects, ",")
+	}
+	if envMaxEventSubscribers := os.Getenv("MAX_EVENT_SUBSCRIBERS"); envMaxEventSubscribers != "" {
+		if n, err := strconv.Atoi(envMaxEventSubscribers); err == nil {
+			cfg.MaxEventSubscribers = n
+		}
+	}
+	if envActiveWindow := os.Getenv("ACTIVE_WINDOW"); envActiveWindow != "" {
+		cfg.ActiveWindow = envActiveWindow
+	}
+	if envMinProjectSeconds := os.Getenv("MIN_PROJECT_SECONDS"); envMinProjectSeconds != "" {
+		if n, err := strconv.Atoi(envMinProjectSeconds); err == nil {
+			cfg.MinProjectSeconds = n
+		}
+	}
+	if envMinSessionSeconds := os.Getenv("MIN_SESSION_SECONDS"); envMinSessionSeconds != "" {
+		if n, err := strconv.Atoi(envMinSessionSeconds); err == nil {
+			cfg.MinSessionSeconds = n
+		}
+	}
+	if envAPIToken := os.Getenv("API_TOKEN"); envAPIToken != "" {
+		cfg.APIToken = envAPIToken
+	}
+	if envWebhookURL := os.Getenv("WEBHOOK_URL"); envWebhookURL != "" {
+		cfg.WebhookURL = envWebhookURL
+	}
+	if envWebhookSecret := os.Getenv("WEBHOOK_SECRET"); envWebhookSecret != "" {
+		cfg.WebhookSecret = envWebhookSecret
+	}
+	if envTodaySyncInterval := os.Getenv("TODAY_SYNC_INTERVAL"); envTodaySyncInterval != "" {
+		cfg.TodaySyncInterval = envTodaySyncInterval
+	}
+	if envRateLimit := os.Getenv("RATE_LIMIT_PER_MINUTE"); envRateLimit != "" {
+		if n, err := strconv.Atoi(envRateLimit); err == nil {
+			cfg.RateLimitPerMinute = n
+		}
+	}
+	if envTrustedProxies := os.Getenv("TRUSTED_PROXIES"); envTrustedProxies != "" {
+		cfg.TrustedProxies = strings.Split(envTrustedProxies, ",")
+	}
+	if envMaxRequestBytes := os.Getenv("MAX_REQUEST_BYTES"); envMaxRequestBytes != "" {
+		if n, err := strconv.ParseInt(envMaxRequestBytes, 10, 64); err == nil {
+			cfg.MaxRequestBytes = n
+		}
+	}
+	if envMaxImportBytes := os.Getenv("MAX_IMPORT_BYTES"); envMaxImportBytes != "" {
+		if n, err := strconv.ParseInt(envMaxImportBytes, 10, 64); err == nil {
+			cfg.MaxImportBytes = n
+		}
+	}
+	if envAccessLog := os.Getenv("ACCESS_LOG"); envAccessLog != "" {
+		cfg.AccessLog = envAccessLog == "1" || envAccessLog == "true"
+	}
+	if envDigitalFormat := os.Getenv("DIGITAL_FORMAT"); envDigitalFormat != "" {
+		cfg.DigitalFormat = envDigitalFormat
+	}
+	if envWeekStart := os.Getenv("WEEK_START"); envWeekStart != "" {
+		cfg.WeekStart = envWeekStart
+	}
+	if envHeartbeatTimeout := os.Getenv("HEARTBEAT_TIMEOUT_MINUTES"); envHeartbeatTimeout != "" {
+		if n, err := strconv.Atoi(envHeartbeatTimeout); err == nil {
+			cfg.HeartbeatTimeoutMinutes = n
+		}
+	}
+	if envDebugSave := os.Getenv("DEBUG_SAVE_FAILED_RESPONSES"); envDebugSave != "" {
+		cfg.DebugSaveFailedResponses = envDebugSave == "1" || envDebugSave == "true"
+	}
+	if envDebugDir := os.Getenv("DEBUG_RESPONSES_DIR"); envDebugDir != "" {
+		cfg.DebugResponsesDir = envDebugDir
+	}
 
 	// Apply defaults for any still-missing values
 	if cfg.ListenAddr == "" {
@@ -67,30 +220,141 @@ func Load(path string) (*Config, error) {
 	if cfg.DatabasePath == "" {
 		cfg.DatabasePath = "wakatime.db"
 	}
+	if cfg.DBBusyTimeout <= 0 {
+		cfg.DBBusyTimeout = DefaultDBBusyTimeout
+	}
+	if cfg.DBJournalMode == "" {
+		cfg.DBJournalMode = DefaultDBJournalMode
+	}
+	if cfg.DBMaxOpenConns <= 0 {
+		cfg.DBMaxOpenConns = DefaultDBMaxOpenConns
+	}
+	if cfg.DBMaxIdleConns <= 0 {
+		cfg.DBMaxIdleConns = cfg.DBMaxOpenConns
+	}
 	if cfg.StartDate == "" {
 		cfg.StartDate = "2016-01-01"
 	}
 	if cfg.SyncSchedule == "" {
 		cfg.SyncSchedule = "0 1 * * *" // 1 AM daily
 	}
+	if cfg.SyncLookbackDays <= 0 {
+		cfg.SyncLookbackDays = DefaultSyncLookbackDays
+	}
 	if cfg.Timezone == "" {
 		cfg.Timezone = "Local"
 	}
 	if cfg.WakaTimeBaseURL == "" {
 		cfg.WakaTimeBaseURL = "https://wakatime.com/api/v1"
 	}
+	if cfg.MaxEventSubscribers <= 0 {
+		cfg.MaxEventSubscribers = 10
+	}
+	if cfg.ActiveWindow == "" {
+		cfg.ActiveWindow = "5m"
+	}
+	if cfg.HeartbeatTimeoutMinutes <= 0 {
+		cfg.HeartbeatTimeoutMinutes = DefaultHeartbeatTimeoutMinutes
+	}
+	if cfg.MaxRequestBytes <= 0 {
+		cfg.MaxRequestBytes = DefaultMaxRequestBytes
+	}
+	if cfg.MaxImportBytes <= 0 {
+		cfg.MaxImportBytes = DefaultMaxImportBytes
+	}
+	if cfg.DigitalFormat == "" {
+		cfg.DigitalFormat = DigitalFormatShort
+	}
+	if cfg.WeekStart == "" {
+		cfg.WeekStart = WeekStartMonday
+	}
+	if cfg.DebugResponsesDir == "" {
+		cfg.DebugResponsesDir = "failed_responses"
+	}
+
+	if cfg.DigitalFormat != DigitalFormatShort && cfg.DigitalFormat != DigitalFormatLong {
+		return nil, fmt.Errorf("invalid digital_format %q: must be %q or %q", cfg.DigitalFormat, DigitalFormatShort, DigitalFormatLong)
+	}
+	cfg.DBJournalMode = strings.ToUpper(cfg.DBJournalMode)
+	if !dbJournalModes[cfg.DBJournalMode] {
+		return nil, fmt.Errorf("invalid db_journal_mode %q: must be WAL, DELETE, TRUNCATE or PERSIST", cfg.DBJournalMode)
+	}
+	cfg.WeekStart = strings.ToLower(cfg.WeekStart)
+	if cfg.WeekStart != WeekStartMonday && cfg.WeekStart != WeekStartSunday {
+		return nil, fmt.Errorf("invalid week_start %q: must be %q or %q", cfg.WeekStart, WeekStartMonday, WeekStartSunday)
+	}
+
+	if err := validateProjectPatterns(cfg.ExcludeProjects, cfg.IncludeProjects); err != nil {
+		return nil, err
+	}
+
+	baseURL, err := validateBaseURL(cfg.WakaTimeBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	cfg.WakaTimeBaseURL = baseURL
 
 	return cfg, nil
 }
 
+// validateBaseURL checks that the WakaTime base URL is an absolute http(s)
+// URL, e.g. of a WakaTime-compatible server like Wakapi, and returns it
+// without a trailing slash
+func validateBaseURL(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid wakatime_base_url %q: %w", baseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid wakatime_base_url %q: must be an absolute http or https URL", baseURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid wakatime_base_url %q: must not have a query or fragment", baseURL)
+	}
+	return strings.TrimRight(baseURL, "/"), nil
+}
+
+// validateProjectPatterns checks that the exclude_projects and
+// include_projects patterns are valid globs, and that only one is set
+func validateProjectPatterns(exclude, include []string) error {
+	if len(exclude) > 0 && len(include) > 0 {
+		return fmt.Errorf("exclude_projects and include_projects cannot both be set")
+	}
+	for _, pattern := range exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude_projects pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range include {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid include_projects pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
 func defaultConfig() *Config {
 	return &Config{
-		ListenAddr:      ":3040",
-		DatabasePath:    "wakatime.db",
-		StartDate:       "2016-01-01",
-		SyncSchedule:    "0 1 * * *",
-		Timezone:        "Local",
-		WakaTimeBaseURL: "https://wakatime.com/api/v1",
+		ListenAddr:          ":3040",
+		DatabasePath:        "wakatime.db",
+		DBBusyTimeout:       DefaultDBBusyTimeout,
+		DBJournalMode:       DefaultDBJournalMode,
+		DBMaxOpenConns:      DefaultDBMaxOpenConns,
+		StartDate:           "2016-01-01",
+		SyncSchedule:        "0 1 * * *",
+		SyncLookbackDays:    DefaultSyncLookbackDays,
+		Timezone:            "Local",
+		WakaTimeBaseURL:     "https://wakatime.com/api/v1",
+		MaxRetries:          3,
+		MaxEventSubscribers: 10,
+		MaxRequestBytes:     DefaultMaxRequestBytes,
+		MaxImportBytes:      DefaultMaxImportBytes,
+		ActiveWindow:        "5m",
+		DigitalFormat:       DigitalFormatShort,
+		WeekStart:           WeekStartMonday,
+		DebugResponsesDir:   "failed_responses",
+
+		HeartbeatTimeoutMinutes: DefaultHeartbeatTimeoutMinutes,
 	}
 }
 
@@ -102,8 +366,13 @@ func (c *Config) GetStartDate() time.Time {
 	return t
 }
 
+// TimezoneAuto makes the server adopt the WakaTime account's timezone at startup
+const TimezoneAuto = "auto"
+
 func (c *Config) GetTimezone() *time.Location {
-	if c.Timezone == "" || c.Timezone == "Local" {
+	// "auto" is replaced by the account timezone once resolved; until then (or
+	// if resolving fails) fall back to Local.
+	if c.Timezone == "" || c.Timezone == "Local" || c.Timezone == TimezoneAuto {
 		return time.Local
 	}
 	loc, err := time.LoadLocation(c.Timezone)
@@ -112,3 +381,104 @@ func (c *Config) GetTimezone() *time.Location {
 	}
 	return loc
 }
+
+// GetActiveWindow returns how recent the last heartbeat must be for the user to
+// be considered actively coding. Invalid values fall back to 5 minutes.
+func (c *Config) GetActiveWindow() time.Duration {
+	d, err := time.ParseDuration(c.ActiveWindow)
+	if err != nil || d <= 0 {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+// GetTodaySyncInterval returns how often today's heartbeats are synced, or 0
+// if disabled. Invalid values disable it.
+func (c *Config) GetTodaySyncInterval() time.Duration {
+	if c.TodaySyncInterval == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(c.TodaySyncInterval)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+// Formats of the "digital" durations in summaries
+const (
+	DigitalFormatShort = "H:MM"     // e.g. 1:02
+	DigitalFormatLong  = "HH:MM:SS" // e.g. 01:02:05, as WakaTime returns in some places
+)
+
+// Defaults of the SQLite connection settings
+const (
+	DefaultDBBusyTimeout  = 5000 // milliseconds
+	DefaultDBJournalMode  = "WAL"
+	DefaultDBMaxOpenConns = 1
+)
+
+// dbJournalModes are the accepted journal modes. MEMORY and OFF are left out,
+// as they can corrupt the database if the process crashes during a write.
+var dbJournalModes = map[string]bool{"WAL": true, "DELETE": true, "TRUNCATE": true, "PERSIST": true}
+
+// First days of the week
+const (
+	WeekStartMonday = "monday" // ISO 8601
+	WeekStartSunday = "sunday"
+)
+
+// GetWeekStart returns the first day of the week
+func (c *Config) GetWeekStart() time.Weekday {
+	if c.WeekStart == WeekStartSunday {
+		return time.Sunday
+	}
+	return time.Monday
+}
+
+// DefaultMaxRequestBytes is the default limit of request bodies, 10 MiB
+const DefaultMaxRequestBytes = 10 << 20
+
+// DefaultMaxImportBytes is the default limit of dump imports, 1 GiB
+const DefaultMaxImportBytes = 1 << 30
+
+// DefaultSyncLookbackDays is how many days scheduled syncs cover, so
+// heartbeats sent a day or two late, e.g. by offline editors, are picked up
+const DefaultSyncLookbackDays = 3
+
+// IsProjectExcluded reports whether a project, known by any of names, matches
+// one of the exclude_projects patterns, or none of the include_projects
+// patterns if set, so its time is dropped when syncing. Matching ignores case.
+func (c *Config) IsProjectExcluded(names ...string) bool {
+	if len(c.IncludeProjects) > 0 {
+		return !matchProject(c.IncludeProjects, names)
+	}
+	return matchProject(c.ExcludeProjects, names)
+}
+
+// matchProject reports whether any of names matches one of the glob patterns,
+// ignoring case
+func matchProject(patterns, names []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, strings.ToLower(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DefaultHeartbeatTimeoutMinutes matches WakaTime's default "keystroke timeout"
+const DefaultHeartbeatTimeoutMinutes = 15
+
+// GetHeartbeatTimeout returns the idle gap that ends a coding session when
+// totals are computed from heartbeats, e.g. by recomputing a day or the hourly
+// stats
+func (c *Config) GetHeartbeatTimeout() time.Duration {
+	if c.HeartbeatTimeoutMinutes <= 0 {
+		return DefaultHeartbeatTimeoutMinutes * time.Minute
+	}
+	return time.Duration(c.HeartbeatTimeoutMinutes) * time.Minute
+}
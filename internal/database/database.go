@@ -2,7 +2,13 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -10,29 +16,143 @@ import (
 
 type DB struct {
 	*sql.DB
+
+	// mirror is an optional second database that every write is repeated on
+	mirror *DB
+
+	path string
+	opts Options
+
+	// readOnly is opened on first use, for user queries
+	readOnlyOnce sync.Once
+	readOnly     *sql.DB
+	readOnlyErr  error
+}
+
+// Options configure the SQLite connections
+type Options struct {
+	// BusyTimeout is how long, in milliseconds, to wait for a lock held by
+	// another connection before failing with "database is locked"
+	BusyTimeout int
+	// JournalMode is the SQLite journal mode, e.g. WAL or DELETE
+	JournalMode string
+	// MaxOpenConns bounds the connections of the pool. SQLite allows one
+	// writer at a time, so 1, the default, avoids lock contention entirely, at
+	// the cost of serializing reads. A connection must not be held, e.g. by
+	// open rows or a transaction, while running another query.
+	MaxOpenConns int
+	// MaxIdleConns is how many connections are kept open between requests
+	MaxIdleConns int
 }
 
-func New(path string) (*DB, error) {
-	db, err := sql.Open("sqlite", path+"?_journal_mode=WAL&_busy_timeout=5000")
+// DefaultOptions are used for zero fields of Options
+var DefaultOptions = Options{BusyTimeout: 5000, JournalMode: "WAL", MaxOpenConns: 1, MaxIdleConns: 1}
+
+func New(path string, opts Options) (*DB, error) {
+	if opts.BusyTimeout <= 0 {
+		opts.BusyTimeout = DefaultOptions.BusyTimeout
+	}
+	if opts.JournalMode == "" {
+		opts.JournalMode = DefaultOptions.JournalMode
+	}
+	if opts.MaxOpenConns <= 0 {
+		opts.MaxOpenConns = DefaultOptions.MaxOpenConns
+	}
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = DefaultOptions.MaxIdleConns
+	}
+
+	// SQLite creates the file but not its directory, e.g. on a fresh volume
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create the directory of the database: %w", err)
+		}
+	}
+
+	// The pragmas are run on every new connection of the pool
+	dsn := fmt.Sprintf("%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(%s)", path, opts.BusyTimeout, opts.JournalMode)
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(min(opts.MaxIdleConns, opts.MaxOpenConns))
 
 	// Test connection
 	if err := db.Ping(); err != nil {
 		return nil, err
 	}
 
-	d := &DB{db}
+	d := &DB{DB: db, path: path, opts: opts}
 	if err := d.migrate(); err != nil {
 		return nil, err
 	}
 
-	slog.Info("database initialized", "path", path)
+	slog.Info("database initialized", "path", path, "journal_mode", opts.JournalMode, "busy_timeout_ms", opts.BusyTimeout,
+		"max_open_conns", opts.MaxOpenConns)
 	return d, nil
 }
 
+// OpenMirror opens (and migrates) a second database that all subsequent writes
+// are mirrored to. Mirror writes are best-effort: failures are logged but never
+// fail the write to the primary database. Reads only use the primary.
+func (db *DB) OpenMirror(path string) error {
+	mirror, err := New(path, db.opts)
+	if err != nil {
+		return err
+	}
+	db.mirror = mirror
+	return nil
+}
+
+// Checkpoint writes the WAL into the database file and truncates it, so the
+// next open does not have to replay it. It is a no-op in other journal modes.
+func (db *DB) Checkpoint() error {
+	if db.mirror != nil {
+		if err := db.mirror.Checkpoint(); err != nil {
+			slog.Warn("failed to checkpoint mirror database", "error", err)
+		}
+	}
+	var busy, logFrames, checkpointed int
+	if err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return err
+	}
+	if busy != 0 {
+		return errors.New("wal checkpoint blocked by another connection")
+	}
+	return nil
+}
+
+// Close closes the database and its mirror, if any
+func (db *DB) Close() error {
+	if db.mirror != nil {
+		if err := db.mirror.Close(); err != nil {
+			slog.Warn("failed to close mirror database", "error", err)
+		}
+	}
+	if db.readOnly != nil {
+		db.readOnly.Close()
+	}
+	return db.DB.Close()
+}
+
+// mirrored repeats a successful write on the mirror database. err is the
+// result of the write on the primary and is returned as-is.
+func (db *DB) mirrored(err error, op string, write func(m *DB) error) error {
+	if err != nil || db.mirror == nil {
+		return err
+	}
+	if mirrorErr := write(db.mirror); mirrorErr != nil {
+		slog.Warn("failed to write to mirror database", "op", op, "error", mirrorErr)
+	}
+	return nil
+}
+
 func (db *DB) migrate() error {
+	if err := db.renameGoalsTables(); err != nil {
+		return err
+	}
+
 	migrations := []string{
 		// Projects table
 		`CREATE TABLE IF NOT EXISTS projects (
@@ -109,18 +229,21 @@ func (db *DB) migrate() error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_day_summaries_day ON day_summaries(day)`,
 
-		// Day stats table (breakdown by type: category, language, editor, os, project, dependency)
+		// Day stats table (breakdown by type: category, language, editor, os, project, dependency, machine, branch, entity).
+		// Branches and entities are kept per project, other types have an empty project.
 		`CREATE TABLE IF NOT EXISTS day_stats (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			day DATE NOT NULL,
 			type TEXT NOT NULL,
+			project TEXT NOT NULL DEFAULT '',
 			name TEXT NOT NULL,
 			total_seconds REAL NOT NULL,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			UNIQUE(day, type, name)
+			UNIQUE(day, type, project, name)
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_day_stats_day ON day_stats(day)`,
 		`CREATE INDEX IF NOT EXISTS idx_day_stats_type ON day_stats(type)`,
+		`CREATE INDEX IF NOT EXISTS idx_day_stats_type_name ON day_stats(type, name)`,
 
 		// Sync log table (track what has been synced)
 		`CREATE TABLE IF NOT EXISTS sync_log (
@@ -131,6 +254,74 @@ func (db *DB) migrate() error {
 			status TEXT DEFAULT 'success'
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_sync_log_day ON sync_log(day)`,
+
+		// Content hashes of synced API responses (to detect whether a day changed upstream)
+		`CREATE TABLE IF NOT EXISTS content_hashes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			day DATE NOT NULL,
+			kind TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(day, kind)
+		)`,
+
+		// WakaTime goals table (goals defined on WakaTime, replaced on every sync)
+		`CREATE TABLE IF NOT EXISTS wakatime_goals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			uuid TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL,
+			type TEXT,
+			delta TEXT,
+			seconds REAL,
+			improve_by_percent REAL,
+			status TEXT,
+			status_percent_calculated REAL,
+			range_text TEXT,
+			is_enabled INTEGER DEFAULT 0,
+			is_snoozed INTEGER DEFAULT 0,
+			is_inverse INTEGER DEFAULT 0,
+			languages JSONB,
+			projects JSONB,
+			editors JSONB,
+			chart_data JSONB,
+			modified_at DATETIME,
+			created_at DATETIME,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Goals table (goals defined in this app, evaluated against synced data)
+		`CREATE TABLE IF NOT EXISTS goals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL DEFAULT '',
+			type TEXT NOT NULL,
+			target_seconds REAL NOT NULL,
+			project TEXT NOT NULL DEFAULT '',
+			language TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Machines heartbeats were sent from, by their WakaTime machine name ID
+		`CREATE TABLE IF NOT EXISTS machines (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			ip TEXT,
+			timezone TEXT,
+			last_seen_at DATETIME,
+			created_at DATETIME
+		)`,
+
+		// Project aliases (project names stored under a canonical name)
+		`CREATE TABLE IF NOT EXISTS project_aliases (
+			alias TEXT PRIMARY KEY,
+			project TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Projects whose data was deleted, which are not synced again
+		`CREATE TABLE IF NOT EXISTS deleted_projects (
+			name TEXT PRIMARY KEY,
+			deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, m := range migrations {
@@ -139,22 +330,265 @@ func (db *DB) migrate() error {
 		}
 	}
 
+	// Columns added after the initial schema. SQLite has no
+	// ADD COLUMN IF NOT EXISTS, so check the table first.
+	columns := []struct {
+		table, name, definition string
+	}{
+		{"durations", "ai_additions", "INTEGER NOT NULL DEFAULT 0"},
+		{"durations", "ai_deletions", "INTEGER NOT NULL DEFAULT 0"},
+		{"durations", "human_additions", "INTEGER NOT NULL DEFAULT 0"},
+		{"durations", "human_deletions", "INTEGER NOT NULL DEFAULT 0"},
+		{"day_summaries", "ai_additions", "INTEGER NOT NULL DEFAULT 0"},
+		{"day_summaries", "ai_deletions", "INTEGER NOT NULL DEFAULT 0"},
+		{"day_summaries", "human_additions", "INTEGER NOT NULL DEFAULT 0"},
+		{"day_summaries", "human_deletions", "INTEGER NOT NULL DEFAULT 0"},
+		{"sync_log", "error", "TEXT"},
+		{"heartbeats", "source", "TEXT NOT NULL DEFAULT 'wakatime'"},
+	}
+	for _, c := range columns {
+		if err := db.addColumnIfMissing(c.table, c.name, c.definition); err != nil {
+			return err
+		}
+	}
+
+	if err := db.createHeartbeatsUniqueIndex(); err != nil {
+		return err
+	}
+	if err := db.createProjectsNameIndex(); err != nil {
+		return err
+	}
+	if err := db.addDayStatsProject(); err != nil {
+		return err
+	}
+	if _, err := db.rekeyMachineStats(); err != nil {
+		return err
+	}
+	return db.createHeartbeatsFTS()
+}
+
+// renameGoalsTables moves goals synced from WakaTime, which used to be stored
+// in goals, to wakatime_goals, and the goals defined in this app from
+// local_goals to goals
+func (db *DB) renameGoalsTables() error {
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'local_goals'").Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		"ALTER TABLE goals RENAME TO wakatime_goals",
+		"ALTER TABLE local_goals RENAME TO goals",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// createProjectsNameIndex makes projects without a UUID unique by name, as
+// some WakaTime-compatible servers do not return project IDs. Blank UUIDs are
+// stored as NULL, and duplicates stored before the index existed are removed
+// first, keeping the latest.
+func (db *DB) createProjectsNameIndex() error {
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_projects_name_no_uuid'").Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	if _, err := db.Exec("UPDATE projects SET uuid = NULL WHERE uuid = ''"); err != nil {
+		return err
+	}
+	res, err := db.Exec(`
+		DELETE FROM projects WHERE uuid IS NULL AND id NOT IN (
+			SELECT MAX(id) FROM projects WHERE uuid IS NULL GROUP BY name
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("removed duplicate projects without uuid", "count", n)
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_projects_name_no_uuid ON projects(name) WHERE uuid IS NULL")
+	return err
+}
+
+// addDayStatsProject adds the project column to day_stats, so branches and
+// entities of different projects are stored apart. It is part of the unique
+// key, which SQLite cannot change in place, so the table is rebuilt. Branches
+// and entities synced before keep an empty project until synced again.
+func (db *DB) addDayStatsProject() error {
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('day_stats') WHERE name = 'project'").Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		`CREATE TABLE day_stats_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			day DATE NOT NULL,
+			type TEXT NOT NULL,
+			project TEXT NOT NULL DEFAULT '',
+			name TEXT NOT NULL,
+			total_seconds REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(day, type, project, name)
+		)`,
+		`INSERT INTO day_stats_new (id, day, type, name, total_seconds, created_at)
+			SELECT id, day, type, name, total_seconds, created_at FROM day_stats`,
+		`DROP TABLE day_stats`,
+		`ALTER TABLE day_stats_new RENAME TO day_stats`,
+		`CREATE INDEX idx_day_stats_day ON day_stats(day)`,
+		`CREATE INDEX idx_day_stats_type ON day_stats(type)`,
+		`CREATE INDEX idx_day_stats_type_name ON day_stats(type, name)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// createHeartbeatsUniqueIndex makes a heartbeat unique by time, entity and
+// machine, so overlapping syncs cannot store it twice. Duplicates stored
+// before the index existed are removed first.
+func (db *DB) createHeartbeatsUniqueIndex() error {
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_heartbeats_unique'").Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	res, err := db.Exec(`
+		DELETE FROM heartbeats WHERE id NOT IN (
+			SELECT MIN(id) FROM heartbeats GROUP BY time, entity, machine_id
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("removed duplicate heartbeats", "count", n)
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_heartbeats_unique ON heartbeats(time, entity, machine_id)")
+	return err
+}
+
+// createHeartbeatsFTS creates a full-text index over heartbeat entities, kept
+// up to date by triggers. The trigram tokenizer allows searching for any part
+// of a file path. Heartbeats stored before the index existed are indexed once.
+func (db *DB) createHeartbeatsFTS() error {
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'heartbeats_fts'").Scan(&exists); err != nil {
+		return err
+	}
+
+	stmts := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS heartbeats_fts USING fts5(
+			entity, content='heartbeats', content_rowid='id', tokenize='trigram'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS heartbeats_fts_insert AFTER INSERT ON heartbeats BEGIN
+			INSERT INTO heartbeats_fts (rowid, entity) VALUES (new.id, new.entity);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS heartbeats_fts_delete AFTER DELETE ON heartbeats BEGIN
+			INSERT INTO heartbeats_fts (heartbeats_fts, rowid, entity) VALUES ('delete', old.id, old.entity);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS heartbeats_fts_update AFTER UPDATE OF entity ON heartbeats BEGIN
+			INSERT INTO heartbeats_fts (heartbeats_fts, rowid, entity) VALUES ('delete', old.id, old.entity);
+			INSERT INTO heartbeats_fts (rowid, entity) VALUES (new.id, new.entity);
+		END`,
+	}
+	if exists == 0 {
+		stmts = append(stmts, `INSERT INTO heartbeats_fts (heartbeats_fts) VALUES ('rebuild')`)
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func (db *DB) addColumnIfMissing(table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// parseDay parses a day read from a DATE column. The driver returns dates as
+// RFC 3339 timestamps at midnight UTC, so only the date part is used.
+func parseDay(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s[:min(len(s), 10)])
+	return t
+}
+
 // --- Duration operations ---
 
 func (db *DB) DeleteDurationsByDay(day time.Time) error {
-	_, err := db.Exec("DELETE FROM durations WHERE day = ?", day.Format("2006-01-02"))
+	err := deleteDurationsByDay(db.DB, day)
+	return db.mirrored(err, "DeleteDurationsByDay", func(m *DB) error { return m.DeleteDurationsByDay(day) })
+}
+
+func deleteDurationsByDay(ex execer, day time.Time) error {
+	_, err := ex.Exec("DELETE FROM durations WHERE day = ?", day.Format("2006-01-02"))
 	return err
 }
 
 func (db *DB) InsertDuration(d *Duration) error {
 	_, err := db.Exec(`
-		INSERT INTO durations (day, project, start_time, duration, dependencies, created_at)
-		VALUES (?, ?, ?, ?, CASE WHEN ? = '' OR ? IS NULL THEN NULL ELSE jsonb(?) END, ?)
-	`, d.Day.Format("2006-01-02"), d.Project, d.StartTime, d.Duration, d.Dependencies, d.Dependencies, d.Dependencies, time.Now())
-	return err
+		INSERT INTO durations (day, project, start_time, duration, dependencies,
+			ai_additions, ai_deletions, human_additions, human_deletions, created_at)
+		VALUES (?, ?, ?, ?, CASE WHEN ? = '' OR ? IS NULL THEN NULL ELSE jsonb(?) END, ?, ?, ?, ?, ?)
+	`, d.Day.Format("2006-01-02"), d.Project, d.StartTime, d.Duration, d.Dependencies, d.Dependencies, d.Dependencies,
+		d.AIAdditions, d.AIDeletions, d.HumanAdditions, d.HumanDeletions, time.Now())
+	return db.mirrored(err, "InsertDuration", func(m *DB) error { return m.InsertDuration(d) })
 }
 
 func (db *DB) InsertDurations(durations []Duration) error {
@@ -164,9 +598,17 @@ func (db *DB) InsertDurations(durations []Duration) error {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`
-		INSERT INTO durations (day, project, start_time, duration, dependencies, created_at)
-		VALUES (?, ?, ?, ?, CASE WHEN ? = '' OR ? IS NULL THEN NULL ELSE jsonb(?) END, ?)
+	if err := insertDurations(tx, durations); err != nil {
+		return err
+	}
+	return db.mirrored(tx.Commit(), "InsertDurations", func(m *DB) error { return m.InsertDurations(durations) })
+}
+
+func insertDurations(ex execer, durations []Duration) error {
+	stmt, err := ex.Prepare(`
+		INSERT INTO durations (day, project, start_time, duration, dependencies,
+			ai_additions, ai_deletions, human_additions, human_deletions, created_at)
+		VALUES (?, ?, ?, ?, CASE WHEN ? = '' OR ? IS NULL THEN NULL ELSE jsonb(?) END, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return err
@@ -174,18 +616,20 @@ func (db *DB) InsertDurations(durations []Duration) error {
 	defer stmt.Close()
 
 	for _, d := range durations {
-		_, err := stmt.Exec(d.Day.Format("2006-01-02"), d.Project, d.StartTime, d.Duration, d.Dependencies, d.Dependencies, d.Dependencies, time.Now())
+		_, err := stmt.Exec(d.Day.Format("2006-01-02"), d.Project, d.StartTime, d.Duration, d.Dependencies, d.Dependencies, d.Dependencies,
+			d.AIAdditions, d.AIDeletions, d.HumanAdditions, d.HumanDeletions, time.Now())
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
 func (db *DB) GetDurationsByDay(day time.Time) ([]Duration, error) {
 	rows, err := db.Query(`
-		SELECT id, day, project, start_time, duration, dependencies, created_at
+		SELECT id, day, project, start_time, duration, dependencies,
+			ai_additions, ai_deletions, human_additions, human_deletions, created_at
 		FROM durations WHERE day = ? ORDER BY start_time
 	`, day.Format("2006-01-02"))
 	if err != nil {
@@ -197,10 +641,38 @@ func (db *DB) GetDurationsByDay(day time.Time) ([]Duration, error) {
 	for rows.Next() {
 		var d Duration
 		var dayStr string
-		if err := rows.Scan(&d.ID, &dayStr, &d.Project, &d.StartTime, &d.Duration, &d.Dependencies, &d.CreatedAt); err != nil {
+		if err := rows.Scan(&d.ID, &dayStr, &d.Project, &d.StartTime, &d.Duration, &d.Dependencies,
+			&d.AIAdditions, &d.AIDeletions, &d.HumanAdditions, &d.HumanDeletions, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.Day = parseDay(dayStr)
+		durations = append(durations, d)
+	}
+	return durations, rows.Err()
+}
+
+// GetDurationsByRange returns the durations of all days from start to end,
+// inclusive, ordered by time
+func (db *DB) GetDurationsByRange(start, end time.Time) ([]Duration, error) {
+	rows, err := db.Query(`
+		SELECT id, day, project, start_time, duration, dependencies,
+			ai_additions, ai_deletions, human_additions, human_deletions, created_at
+		FROM durations WHERE day >= ? AND day <= ? ORDER BY start_time
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var durations []Duration
+	for rows.Next() {
+		var d Duration
+		var dayStr string
+		if err := rows.Scan(&d.ID, &dayStr, &d.Project, &d.StartTime, &d.Duration, &d.Dependencies,
+			&d.AIAdditions, &d.AIDeletions, &d.HumanAdditions, &d.HumanDeletions, &d.CreatedAt); err != nil {
 			return nil, err
 		}
-		d.Day, _ = time.Parse("2006-01-02", dayStr)
+		d.Day = parseDay(dayStr)
 		durations = append(durations, d)
 	}
 	return durations, rows.Err()
@@ -215,7 +687,12 @@ func (db *DB) CountDurationsByDay(day time.Time) (int, error) {
 // --- Project Duration operations ---
 
 func (db *DB) DeleteProjectDurationsByDay(day time.Time) error {
-	_, err := db.Exec("DELETE FROM project_durations WHERE day = ?", day.Format("2006-01-02"))
+	err := deleteProjectDurationsByDay(db.DB, day)
+	return db.mirrored(err, "DeleteProjectDurationsByDay", func(m *DB) error { return m.DeleteProjectDurationsByDay(day) })
+}
+
+func deleteProjectDurationsByDay(ex execer, day time.Time) error {
+	_, err := ex.Exec("DELETE FROM project_durations WHERE day = ?", day.Format("2006-01-02"))
 	return err
 }
 
@@ -226,7 +703,14 @@ func (db *DB) InsertProjectDurations(durations []ProjectDuration) error {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`
+	if err := insertProjectDurations(tx, durations); err != nil {
+		return err
+	}
+	return db.mirrored(tx.Commit(), "InsertProjectDurations", func(m *DB) error { return m.InsertProjectDurations(durations) })
+}
+
+func insertProjectDurations(ex execer, durations []ProjectDuration) error {
+	stmt, err := ex.Prepare(`
 		INSERT INTO project_durations (day, project, branch, entity, language, type, start_time, duration, dependencies, created_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CASE WHEN ? = '' OR ? IS NULL THEN NULL ELSE jsonb(?) END, ?)
 	`)
@@ -245,7 +729,7 @@ func (db *DB) InsertProjectDurations(durations []ProjectDuration) error {
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
 func (db *DB) GetProjectDurationsByDay(day time.Time, project string) ([]ProjectDuration, error) {
@@ -273,50 +757,129 @@ func (db *DB) GetProjectDurationsByDay(day time.Time, project string) ([]Project
 		if err := rows.Scan(&d.ID, &dayStr, &d.Project, &d.Branch, &d.Entity, &d.Language, &d.Type, &d.StartTime, &d.Duration, &d.Dependencies, &d.CreatedAt); err != nil {
 			return nil, err
 		}
-		d.Day, _ = time.Parse("2006-01-02", dayStr)
+		d.Day = parseDay(dayStr)
+		durations = append(durations, d)
+	}
+	return durations, rows.Err()
+}
+
+// GetProjectDurationsByRange returns the project durations of all days from
+// start to end, inclusive, ordered by time. An empty project returns all.
+func (db *DB) GetProjectDurationsByRange(start, end time.Time, project string) ([]ProjectDuration, error) {
+	query := `
+		SELECT id, day, project, branch, entity, language, type, start_time, duration, dependencies, created_at
+		FROM project_durations WHERE day >= ? AND day <= ?
+	`
+	args := []interface{}{start.Format("2006-01-02"), end.Format("2006-01-02")}
+	if project != "" {
+		query += " AND project = ?"
+		args = append(args, project)
+	}
+	query += " ORDER BY start_time"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var durations []ProjectDuration
+	for rows.Next() {
+		var d ProjectDuration
+		var dayStr string
+		if err := rows.Scan(&d.ID, &dayStr, &d.Project, &d.Branch, &d.Entity, &d.Language, &d.Type, &d.StartTime, &d.Duration, &d.Dependencies, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.Day = parseDay(dayStr)
 		durations = append(durations, d)
 	}
 	return durations, rows.Err()
 }
 
-// --- Heartbeat operations ---
+// Sources of stored heartbeats: synced from WakaTime, or stored locally by an
+// offline import or the ingestion endpoint. Re-syncing a day only replaces
+// the heartbeats synced from WakaTime, so local ones are kept.
+const (
+	HeartbeatSourceWakaTime = "wakatime"
+	HeartbeatSourceLocal    = "local"
+)
 
+// DeleteHeartbeatsByDay deletes the heartbeats of a day synced from WakaTime.
+// Heartbeats stored locally are kept.
 func (db *DB) DeleteHeartbeatsByDay(day time.Time) error {
-	_, err := db.Exec("DELETE FROM heartbeats WHERE day = ?", day.Format("2006-01-02"))
+	err := deleteHeartbeatsByDay(db.DB, day)
+	return db.mirrored(err, "DeleteHeartbeatsByDay", func(m *DB) error { return m.DeleteHeartbeatsByDay(day) })
+}
+
+func deleteHeartbeatsByDay(ex execer, day time.Time) error {
+	_, err := ex.Exec("DELETE FROM heartbeats WHERE day = ? AND source = ?", day.Format("2006-01-02"), HeartbeatSourceWakaTime)
 	return err
 }
 
+// InsertHeartbeats stores heartbeats synced from WakaTime. Heartbeats already
+// stored (same time, entity and machine) are skipped.
 func (db *DB) InsertHeartbeats(heartbeats []HeartBeat) error {
+	_, err := db.insertHeartbeatsTx(heartbeats, HeartbeatSourceWakaTime)
+	return db.mirrored(err, "InsertHeartbeats", func(m *DB) error { return m.InsertHeartbeats(heartbeats) })
+}
+
+// InsertLocalHeartbeats stores heartbeats that did not come from a sync, e.g.
+// imported or ingested ones, which re-syncs keep. Heartbeats already stored
+// are skipped. It returns the number of heartbeats inserted.
+func (db *DB) InsertLocalHeartbeats(heartbeats []HeartBeat) (int, error) {
+	n, err := db.insertHeartbeatsTx(heartbeats, HeartbeatSourceLocal)
+	return n, db.mirrored(err, "InsertLocalHeartbeats", func(m *DB) error {
+		_, err := m.InsertLocalHeartbeats(heartbeats)
+		return err
+	})
+}
+
+func (db *DB) insertHeartbeatsTx(heartbeats []HeartBeat, source string) (int, error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`
-		INSERT INTO heartbeats (day, entity, type, category, time, project, branch, language, is_write, machine_id, lines, line_no, cursor_pos, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	n, err := insertHeartbeats(tx, heartbeats, source)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// insertHeartbeats inserts heartbeats, skipping those the unique index on
+// (time, entity, machine_id) already has, and returns the number inserted
+func insertHeartbeats(ex execer, heartbeats []HeartBeat, source string) (int, error) {
+	stmt, err := ex.Prepare(`
+		INSERT INTO heartbeats (day, entity, type, category, time, project, branch, language, is_write, machine_id, lines, line_no, cursor_pos, source, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
 	`)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer stmt.Close()
 
+	inserted := 0
 	for _, h := range heartbeats {
 		isWrite := 0
 		if h.IsWrite {
 			isWrite = 1
 		}
-		_, err := stmt.Exec(
+		res, err := stmt.Exec(
 			h.Day.Format("2006-01-02"), h.Entity, h.Type, h.Category, h.Time, h.Project, h.Branch, h.Language,
-			isWrite, h.MachineID, h.Lines, h.LineNo, h.CursorPos, time.Now(),
+			isWrite, h.MachineID, h.Lines, h.LineNo, h.CursorPos, source, time.Now(),
 		)
 		if err != nil {
-			return err
+			return inserted, err
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			inserted += int(n)
 		}
 	}
 
-	return tx.Commit()
+	return inserted, nil
 }
 
 func (db *DB) GetHeartbeatsByDay(day time.Time) ([]HeartBeat, error) {
@@ -337,26 +900,207 @@ func (db *DB) GetHeartbeatsByDay(day time.Time) ([]HeartBeat, error) {
 		if err := rows.Scan(&h.ID, &dayStr, &h.Entity, &h.Type, &h.Category, &h.Time, &h.Project, &h.Branch, &h.Language, &isWrite, &h.MachineID, &h.Lines, &h.LineNo, &h.CursorPos, &h.CreatedAt); err != nil {
 			return nil, err
 		}
-		h.Day, _ = time.Parse("2006-01-02", dayStr)
+		h.Day = parseDay(dayStr)
+		h.IsWrite = isWrite == 1
+		heartbeats = append(heartbeats, h)
+	}
+	return heartbeats, rows.Err()
+}
+
+// GetHeartbeatsByDayPaged returns at most limit heartbeats for a day, starting at offset
+func (db *DB) GetHeartbeatsByDayPaged(day time.Time, limit, offset int) ([]HeartBeat, error) {
+	rows, err := db.Query(`
+		SELECT id, day, entity, type, category, time, project, branch, language, is_write, machine_id, lines, line_no, cursor_pos, created_at
+		FROM heartbeats WHERE day = ? ORDER BY time, id LIMIT ? OFFSET ?
+	`, day.Format("2006-01-02"), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var heartbeats []HeartBeat
+	for rows.Next() {
+		var h HeartBeat
+		var dayStr string
+		var isWrite int
+		if err := rows.Scan(&h.ID, &dayStr, &h.Entity, &h.Type, &h.Category, &h.Time, &h.Project, &h.Branch, &h.Language, &isWrite, &h.MachineID, &h.Lines, &h.LineNo, &h.CursorPos, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		h.Day = parseDay(dayStr)
 		h.IsWrite = isWrite == 1
 		heartbeats = append(heartbeats, h)
 	}
 	return heartbeats, rows.Err()
 }
 
+// GetLatestHeartbeat returns the most recent stored heartbeat, or nil if there are none
+func (db *DB) GetLatestHeartbeat() (*HeartBeat, error) {
+	var h HeartBeat
+	var dayStr string
+	var isWrite int
+	err := db.QueryRow(`
+		SELECT id, day, entity, type, category, time, project, branch, language, is_write, machine_id, lines, line_no, cursor_pos, created_at
+		FROM heartbeats ORDER BY time DESC LIMIT 1
+	`).Scan(&h.ID, &dayStr, &h.Entity, &h.Type, &h.Category, &h.Time, &h.Project, &h.Branch, &h.Language, &isWrite, &h.MachineID, &h.Lines, &h.LineNo, &h.CursorPos, &h.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.Day = parseDay(dayStr)
+	h.IsWrite = isWrite == 1
+	return &h, nil
+}
+
+// GetLatestSyncedHeartbeatTime returns the time of the day's most recent
+// heartbeat synced from WakaTime, or 0 if there are none. Heartbeats stored
+// locally are left out, as WakaTime may still return older ones.
+func (db *DB) GetLatestSyncedHeartbeatTime(day time.Time) (float64, error) {
+	var t float64
+	err := db.QueryRow("SELECT COALESCE(MAX(time), 0) FROM heartbeats WHERE day = ? AND source = ?",
+		day.Format("2006-01-02"), HeartbeatSourceWakaTime).Scan(&t)
+	return t, err
+}
+
 func (db *DB) CountHeartbeatsByDay(day time.Time) (int, error) {
 	var count int
 	err := db.QueryRow("SELECT COUNT(*) FROM heartbeats WHERE day = ?", day.Format("2006-01-02")).Scan(&count)
 	return count, err
 }
 
+// CountSyncedHeartbeatsByDay counts the heartbeats of a day synced from
+// WakaTime, leaving out those stored locally
+func (db *DB) CountSyncedHeartbeatsByDay(day time.Time) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM heartbeats WHERE day = ? AND source = ?",
+		day.Format("2006-01-02"), HeartbeatSourceWakaTime).Scan(&count)
+	return count, err
+}
+
+// LineDelta is the estimated change in lines of code for a single day
+type LineDelta struct {
+	Day          string `json:"date"`
+	LinesAdded   int    `json:"lines_added"`
+	LinesRemoved int    `json:"lines_removed"`
+	NetLines     int    `json:"net_lines"`
+	Files        int    `json:"files"`
+}
+
+// GetLineDeltas estimates lines changed per day from write heartbeats.
+//
+// For every file, consecutive write heartbeats of the same day are compared and
+// the difference in the file's total line count is attributed to that day.
+// This is a heuristic: truncating, regenerating or renaming a file shows up as
+// a large change, and edits that keep the line count constant are invisible.
+func (db *DB) GetLineDeltas(start, end time.Time) ([]LineDelta, error) {
+	rows, err := db.Query(`
+		SELECT day,
+			SUM(CASE WHEN delta > 0 THEN delta ELSE 0 END),
+			SUM(CASE WHEN delta < 0 THEN -delta ELSE 0 END),
+			SUM(delta),
+			COUNT(DISTINCT entity)
+		FROM (
+			SELECT day, entity,
+				lines - LAG(lines) OVER (PARTITION BY day, entity ORDER BY time) AS delta
+			FROM heartbeats
+			WHERE day >= ? AND day <= ? AND is_write = 1 AND type = 'file' AND lines IS NOT NULL
+		)
+		WHERE delta IS NOT NULL
+		GROUP BY day
+		ORDER BY day
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deltas := []LineDelta{}
+	for rows.Next() {
+		var d LineDelta
+		if err := rows.Scan(&d.Day, &d.LinesAdded, &d.LinesRemoved, &d.NetLines, &d.Files); err != nil {
+			return nil, err
+		}
+		// Normalize date to YYYY-MM-DD format
+		if len(d.Day) > 10 {
+			d.Day = d.Day[:10]
+		}
+		deltas = append(deltas, d)
+	}
+	return deltas, rows.Err()
+}
+
+// GetHourlyHistogram returns how many seconds were spent in each hour of the
+// day (0-23) between start and end, inclusive. Hours are in start's location.
+//
+// Like WakaTime durations, the time between two consecutive heartbeats counts
+// as activity unless the gap exceeds timeout. Each span is split at local hour
+// boundaries, so on DST transitions the repeated hour gets the time of both
+// occurrences and the skipped hour gets none, without counting anything twice.
+func (db *DB) GetHourlyHistogram(start, end time.Time, timeout time.Duration) ([24]float64, error) {
+	var hours [24]float64
+	loc := start.Location()
+
+	rows, err := db.Query(`
+		SELECT time FROM heartbeats
+		WHERE day >= ? AND day <= ?
+		ORDER BY time
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return hours, err
+	}
+	defer rows.Close()
+
+	var prev float64
+	first := true
+	for rows.Next() {
+		var t float64
+		if err := rows.Scan(&t); err != nil {
+			return hours, err
+		}
+		if !first && t-prev <= timeout.Seconds() {
+			addSpanToHours(&hours, unixFloatToTime(prev), unixFloatToTime(t), loc)
+		}
+		prev = t
+		first = false
+	}
+	return hours, rows.Err()
+}
+
+// addSpanToHours attributes the span [from, to) to the local hours it covers
+func addSpanToHours(hours *[24]float64, from, to time.Time, loc *time.Location) {
+	for from.Before(to) {
+		local := from.In(loc)
+		// Work in absolute time from the start of the local hour, which stays
+		// correct across DST changes (they happen on hour boundaries)
+		sinceHour := time.Duration(local.Minute())*time.Minute +
+			time.Duration(local.Second())*time.Second + time.Duration(local.Nanosecond())
+		next := from.Add(time.Hour - sinceHour)
+		if next.After(to) {
+			next = to
+		}
+		hours[local.Hour()] += next.Sub(from).Seconds()
+		from = next
+	}
+}
+
+func unixFloatToTime(t float64) time.Time {
+	sec := int64(t)
+	return time.Unix(sec, int64((t-float64(sec))*1e9))
+}
+
 // --- Project operations ---
 
+// UpsertProject stores a project by its UUID, or by its name if it has none
 func (db *DB) UpsertProject(p *Project) error {
+	conflict, uuid := "(uuid)", interface{}(p.UUID)
+	if p.UUID == "" {
+		conflict, uuid = "(name) WHERE uuid IS NULL", nil
+	}
 	_, err := db.Exec(`
 		INSERT INTO projects (uuid, name, repository, badge, color, has_public_url, last_heartbeat_at, first_heartbeat_at, created_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(uuid) DO UPDATE SET
+		ON CONFLICT`+conflict+` DO UPDATE SET
 			name = excluded.name,
 			repository = excluded.repository,
 			badge = excluded.badge,
@@ -364,18 +1108,169 @@ func (db *DB) UpsertProject(p *Project) error {
 			has_public_url = excluded.has_public_url,
 			last_heartbeat_at = excluded.last_heartbeat_at,
 			first_heartbeat_at = excluded.first_heartbeat_at
-	`, p.UUID, p.Name, p.Repository, p.Badge, p.Color, p.HasPublicURL, p.LastHeartbeatAt, p.FirstHeartbeatAt, time.Now())
-	return err
+	`, uuid, p.Name, p.Repository, p.Badge, p.Color, p.HasPublicURL, p.LastHeartbeatAt, p.FirstHeartbeatAt, time.Now())
+	return db.mirrored(err, "UpsertProject", func(m *DB) error { return m.UpsertProject(p) })
+}
+
+// --- Machine operations ---
+
+func (db *DB) UpsertMachine(m *Machine) error {
+	_, err := db.Exec(`
+		INSERT INTO machines (id, name, ip, timezone, last_seen_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name,
+			ip = excluded.ip,
+			timezone = excluded.timezone,
+			last_seen_at = excluded.last_seen_at
+	`, m.ID, m.Name, m.IP, m.Timezone, m.LastSeenAt, m.CreatedAt)
+	return db.mirrored(err, "UpsertMachine", func(mirror *DB) error { return mirror.UpsertMachine(m) })
+}
+
+// RekeyMachineStats moves machine stats stored by machine name, as days synced
+// before machines were stored by ID are, to the ID of the machine with that
+// name. Names shared by several machines cannot be resolved and are kept.
+func (db *DB) RekeyMachineStats() error {
+	n, err := db.rekeyMachineStats()
+	if n > 0 {
+		slog.Info("moved machine stats from machine names to IDs", "count", n)
+	}
+	return db.mirrored(err, "RekeyMachineStats", func(m *DB) error { return m.RekeyMachineStats() })
+}
+
+func (db *DB) rekeyMachineStats() (int64, error) {
+	res, err := db.Exec(`
+		UPDATE OR IGNORE day_stats
+		SET name = (SELECT id FROM machines WHERE machines.name = day_stats.name)
+		WHERE type = 'machine'
+			AND name NOT IN (SELECT id FROM machines)
+			AND (SELECT COUNT(*) FROM machines WHERE machines.name = day_stats.name) = 1
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
-func (db *DB) GetProjects(query string) ([]Project, error) {
-	sql := "SELECT id, uuid, name, repository, badge, color, has_public_url, last_heartbeat_at, first_heartbeat_at, created_at FROM projects"
+// GetMachines lists the known machines, most recently seen first
+func (db *DB) GetMachines() ([]Machine, error) {
+	rows, err := db.Query("SELECT id, name, ip, timezone, last_seen_at, created_at FROM machines ORDER BY last_seen_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	machines := []Machine{}
+	for rows.Next() {
+		var m Machine
+		var ip, timezone sql.NullString
+		var lastSeenAt, createdAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.Name, &ip, &timezone, &lastSeenAt, &createdAt); err != nil {
+			return nil, err
+		}
+		m.IP, m.Timezone = ip.String, timezone.String
+		m.LastSeenAt, m.CreatedAt = lastSeenAt.Time, createdAt.Time
+		machines = append(machines, m)
+	}
+	return machines, rows.Err()
+}
+
+// GetProjectColors returns the colors WakaTime assigned to projects by name.
+// Projects without a color are left out.
+func (db *DB) GetProjectColors() (map[string]string, error) {
+	rows, err := db.Query("SELECT name, color FROM projects WHERE color IS NOT NULL AND color != ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	colors := make(map[string]string)
+	for rows.Next() {
+		var name, color string
+		if err := rows.Scan(&name, &color); err != nil {
+			return nil, err
+		}
+		colors[name] = color
+	}
+	return colors, rows.Err()
+}
+
+// projectsWhere returns the WHERE clause and its arguments selecting projects
+// whose name contains query. If minSeconds is positive, projects with less
+// total tracked time than that are left out.
+//
+// The time filter aggregates all "project" rows of day_stats (one per project
+// per day, read through idx_day_stats_type_name), so its cost grows with the
+// length of the history: a few milliseconds for years of data, but it is not
+// free for every request.
+func projectsWhere(query string, minSeconds int) (string, []interface{}) {
+	var where []string
 	var args []interface{}
 	if query != "" {
-		sql += " WHERE name LIKE ?"
+		where = append(where, "name LIKE ?")
 		args = append(args, "%"+query+"%")
 	}
-	sql += " ORDER BY last_heartbeat_at DESC"
+	if minSeconds > 0 {
+		where = append(where, `name IN (
+			SELECT name FROM day_stats WHERE type = 'project'
+			GROUP BY name HAVING SUM(total_seconds) >= ?
+		)`)
+		args = append(args, minSeconds)
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+// CountProjects counts the projects GetProjectsPaged lists
+func (db *DB) CountProjects(query string, minSeconds int) (int, error) {
+	where, args := projectsWhere(query, minSeconds)
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM projects"+where, args...).Scan(&count)
+	return count, err
+}
+
+// projectSorts are the orders GetProjectsPaged accepts, by the expression
+// they sort by. Only these are put in the SQL.
+var projectSorts = map[string]string{
+	"name":              "name COLLATE NOCASE",
+	"created_at":        "created_at",
+	"last_heartbeat_at": "last_heartbeat_at",
+	"total": `(
+		SELECT COALESCE(SUM(total_seconds), 0) FROM day_stats
+		WHERE type = 'project' AND name = projects.name AND day >= ? AND day <= ?
+	)`,
+}
+
+// ValidProjectSort reports whether projects can be sorted by sortBy
+func ValidProjectSort(sortBy string) bool {
+	_, ok := projectSorts[sortBy]
+	return ok
+}
+
+// GetProjectsPaged lists at most limit projects whose name contains query,
+// starting at offset, leaving out those with less total tracked time than
+// minSeconds if it is positive. They are ordered by sortBy (see
+// ValidProjectSort), where "total" is the total time from start to end, and
+// then by the last heartbeat.
+func (db *DB) GetProjectsPaged(query string, minSeconds int, sortBy string, desc bool, start, end time.Time, limit, offset int) ([]Project, error) {
+	order, ok := projectSorts[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("invalid project sort %q", sortBy)
+	}
+	if desc {
+		order += " DESC"
+	}
+
+	where, args := projectsWhere(query, minSeconds)
+	sql := "SELECT id, COALESCE(uuid, ''), name, repository, badge, color, has_public_url, last_heartbeat_at, first_heartbeat_at, created_at FROM projects" + where
+	sql += " ORDER BY " + order + ", last_heartbeat_at DESC, id"
+	if sortBy == "total" {
+		args = append(args, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+	sql += " LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
 
 	rows, err := db.Query(sql, args...)
 	if err != nil {
@@ -394,10 +1289,143 @@ func (db *DB) GetProjects(query string) ([]Project, error) {
 	return projects, rows.Err()
 }
 
+// ProjectDeletion counts the rows removed by DeleteProjectData
+type ProjectDeletion struct {
+	Days             int64   `json:"days"`
+	TotalSeconds     float64 `json:"total_seconds"`
+	DayStats         int64   `json:"day_stats"`
+	Durations        int64   `json:"durations"`
+	ProjectDurations int64   `json:"project_durations"`
+	Heartbeats       int64   `json:"heartbeats"`
+	Projects         int64   `json:"projects"`
+
+	// Dates are the days the project had time on
+	Dates []time.Time `json:"-"`
+}
+
+// DeleteProjectData removes a project's durations, heartbeats, daily stats and
+// project entry, takes its time off the daily totals of the days it was on,
+// and records it in deleted_projects so syncs leave it out from now on. Other
+// breakdowns of those days (languages, editors etc.) are not stored per
+// project, so they keep its time until the days are synced again. Returns
+// sql.ErrNoRows if there is no data for the project.
+func (db *DB) DeleteProjectData(project string) (*ProjectDeletion, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	d := &ProjectDeletion{}
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(total_seconds), 0) FROM day_stats WHERE type = 'project' AND name = ?
+	`, project).Scan(&d.Days, &d.TotalSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT day FROM day_stats WHERE type = 'project' AND name = ? ORDER BY day", project)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.Dates = append(d.Dates, parseDay(day))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The daily totals are the sum of all projects, so subtracting the
+	// project's time keeps them exact. This must run before its stats go.
+	_, err = tx.Exec(`
+		UPDATE day_summaries SET total_seconds = MAX(0, total_seconds - (
+			SELECT ds.total_seconds FROM day_stats ds
+			WHERE ds.day = day_summaries.day AND ds.type = 'project' AND ds.name = ?
+		))
+		WHERE day IN (SELECT day FROM day_stats WHERE type = 'project' AND name = ?)
+	`, project, project)
+	if err != nil {
+		return nil, err
+	}
+
+	deletes := []struct {
+		query string
+		count *int64
+	}{
+		{"DELETE FROM day_stats WHERE (type = 'project' AND name = ?1) OR project = ?1", &d.DayStats},
+		{"DELETE FROM durations WHERE project = ?", &d.Durations},
+		{"DELETE FROM project_durations WHERE project = ?", &d.ProjectDurations},
+		{"DELETE FROM heartbeats WHERE project = ?", &d.Heartbeats},
+		{"DELETE FROM projects WHERE name = ?", &d.Projects},
+	}
+	var deleted int64
+	for _, del := range deletes {
+		res, err := tx.Exec(del.query, project)
+		if err != nil {
+			return nil, err
+		}
+		if *del.count, err = res.RowsAffected(); err != nil {
+			return nil, err
+		}
+		deleted += *del.count
+	}
+	if deleted == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO deleted_projects (name, deleted_at) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET deleted_at = excluded.deleted_at
+	`, project, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.mirrored(tx.Commit(), "DeleteProjectData", func(m *DB) error {
+		_, err := m.DeleteProjectData(project)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// GetDeletedProjects returns the names of the projects deleted with
+// DeleteProjectData
+func (db *DB) GetDeletedProjects() (map[string]bool, error) {
+	rows, err := db.Query("SELECT name FROM deleted_projects")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deleted := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		deleted[name] = true
+	}
+	return deleted, rows.Err()
+}
+
 // --- Day Summary operations ---
 
 func (db *DB) UpsertDaySummary(day time.Time, totalSeconds float64) error {
-	_, err := db.Exec(`
+	err := upsertDaySummary(db.DB, day, totalSeconds)
+	return db.mirrored(err, "UpsertDaySummary", func(m *DB) error { return m.UpsertDaySummary(day, totalSeconds) })
+}
+
+func upsertDaySummary(ex execer, day time.Time, totalSeconds float64) error {
+	_, err := ex.Exec(`
 		INSERT INTO day_summaries (day, total_seconds, created_at)
 		VALUES (?, ?, ?)
 		ON CONFLICT(day) DO UPDATE SET total_seconds = excluded.total_seconds
@@ -405,26 +1433,45 @@ func (db *DB) UpsertDaySummary(day time.Time, totalSeconds float64) error {
 	return err
 }
 
+// UpdateDaySummaryLineChanges stores the AI and human line changes for a day.
+// The day summary row must already exist.
+func (db *DB) UpdateDaySummaryLineChanges(day time.Time, aiAdditions, aiDeletions, humanAdditions, humanDeletions int) error {
+	err := updateDaySummaryLineChanges(db.DB, day, aiAdditions, aiDeletions, humanAdditions, humanDeletions)
+	return db.mirrored(err, "UpdateDaySummaryLineChanges", func(m *DB) error {
+		return m.UpdateDaySummaryLineChanges(day, aiAdditions, aiDeletions, humanAdditions, humanDeletions)
+	})
+}
+
+func updateDaySummaryLineChanges(ex execer, day time.Time, aiAdditions, aiDeletions, humanAdditions, humanDeletions int) error {
+	_, err := ex.Exec(`
+		UPDATE day_summaries
+		SET ai_additions = ?, ai_deletions = ?, human_additions = ?, human_deletions = ?
+		WHERE day = ?
+	`, aiAdditions, aiDeletions, humanAdditions, humanDeletions, day.Format("2006-01-02"))
+	return err
+}
+
 func (db *DB) GetDaySummary(day time.Time) (*DaySummary, error) {
 	var s DaySummary
 	var dayStr string
 	err := db.QueryRow(`
-		SELECT id, day, total_seconds, created_at
+		SELECT id, day, total_seconds, ai_additions, ai_deletions, human_additions, human_deletions, created_at
 		FROM day_summaries WHERE day = ?
-	`, day.Format("2006-01-02")).Scan(&s.ID, &dayStr, &s.TotalSeconds, &s.CreatedAt)
+	`, day.Format("2006-01-02")).Scan(&s.ID, &dayStr, &s.TotalSeconds,
+		&s.AIAdditions, &s.AIDeletions, &s.HumanAdditions, &s.HumanDeletions, &s.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	s.Day, _ = time.Parse("2006-01-02", dayStr)
+	s.Day = parseDay(dayStr)
 	return &s, nil
 }
 
 func (db *DB) GetDaySummaries(start, end time.Time) ([]DaySummary, error) {
 	rows, err := db.Query(`
-		SELECT id, day, total_seconds, created_at
+		SELECT id, day, total_seconds, ai_additions, ai_deletions, human_additions, human_deletions, created_at
 		FROM day_summaries WHERE day >= ? AND day <= ? ORDER BY day
 	`, start.Format("2006-01-02"), end.Format("2006-01-02"))
 	if err != nil {
@@ -436,276 +1483,1072 @@ func (db *DB) GetDaySummaries(start, end time.Time) ([]DaySummary, error) {
 	for rows.Next() {
 		var s DaySummary
 		var dayStr string
-		if err := rows.Scan(&s.ID, &dayStr, &s.TotalSeconds, &s.CreatedAt); err != nil {
+		if err := rows.Scan(&s.ID, &dayStr, &s.TotalSeconds,
+			&s.AIAdditions, &s.AIDeletions, &s.HumanAdditions, &s.HumanDeletions, &s.CreatedAt); err != nil {
 			return nil, err
 		}
-		s.Day, _ = time.Parse("2006-01-02", dayStr)
+		s.Day = parseDay(dayStr)
 		summaries = append(summaries, s)
 	}
 	return summaries, rows.Err()
 }
 
-// --- Day Stats operations ---
+// AllTimeStats summarizes all synced days
+type AllTimeStats struct {
+	TotalSeconds float64 `json:"total_seconds"`
+	ActiveDays   int     `json:"active_days"`
+	FirstDay     string  `json:"first_day"` // empty if there is no data
+	LastDay      string  `json:"last_day"`
+}
 
-func (db *DB) DeleteDayStatsByDay(day time.Time) error {
-	_, err := db.Exec("DELETE FROM day_stats WHERE day = ?", day.Format("2006-01-02"))
-	return err
+// GetAllTimeStats returns the lifetime total. Only days with time tracked
+// count as active and as first/last day.
+func (db *DB) GetAllTimeStats() (AllTimeStats, error) {
+	var stats AllTimeStats
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(total_seconds), 0), COUNT(*), COALESCE(MIN(day), ''), COALESCE(MAX(day), '')
+		FROM day_summaries WHERE total_seconds > 0
+	`).Scan(&stats.TotalSeconds, &stats.ActiveDays, &stats.FirstDay, &stats.LastDay)
+	// Normalize date to YYYY-MM-DD format
+	if len(stats.FirstDay) > 10 {
+		stats.FirstDay = stats.FirstDay[:10]
+	}
+	if len(stats.LastDay) > 10 {
+		stats.LastDay = stats.LastDay[:10]
+	}
+	return stats, err
 }
 
-func (db *DB) InsertDayStats(stats []DayStats) error {
-	tx, err := db.Begin()
+// LanguageActivity is how consistently a language has been used
+type LanguageActivity struct {
+	Language string `json:"language"`
+	// CurrentStreak is the number of consecutive days with the language, up to
+	// today, or up to yesterday if today has no time yet
+	CurrentStreak int     `json:"current_streak"`
+	LongestStreak int     `json:"longest_streak"`
+	ActiveDays    int     `json:"active_days"`
+	TotalSeconds  float64 `json:"total_seconds"`
+	FirstDay      string  `json:"first_day"` // empty if the language was never used
+	LastDay       string  `json:"last_day"`
+}
+
+// GetLanguageActivity returns the streaks and last day of a language from its
+// daily totals. The language is matched ignoring case. A day without time in
+// the language, or with less than minSeconds, breaks a streak; the other
+// figures count every day with time in it.
+func (db *DB) GetLanguageActivity(language string, today time.Time, minSeconds float64) (*LanguageActivity, error) {
+	rows, err := db.Query(`
+		SELECT day, SUM(total_seconds) FROM day_stats
+		WHERE type = 'language' AND name = ? COLLATE NOCASE AND total_seconds > 0
+		GROUP BY day ORDER BY day
+	`, language)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer tx.Rollback()
+	defer rows.Close()
 
-	stmt, err := tx.Prepare(`
-		INSERT INTO day_stats (day, type, name, total_seconds, created_at)
-		VALUES (?, ?, ?, ?, ?)
-		ON CONFLICT(day, type, name) DO UPDATE SET total_seconds = excluded.total_seconds
-	`)
+	activity := &LanguageActivity{Language: language}
+	var prev, prevStreakDay time.Time
+	streak := 0
+	for rows.Next() {
+		var dayStr string
+		var seconds float64
+		if err := rows.Scan(&dayStr, &seconds); err != nil {
+			return nil, err
+		}
+		day := parseDay(dayStr)
+		activity.ActiveDays++
+		activity.TotalSeconds += seconds
+		if activity.FirstDay == "" {
+			activity.FirstDay = day.Format("2006-01-02")
+		}
+		prev = day
+
+		if seconds < minSeconds {
+			continue
+		}
+		if streak > 0 && day.Equal(prevStreakDay.AddDate(0, 0, 1)) {
+			streak++
+		} else {
+			streak = 1
+		}
+		activity.LongestStreak = max(activity.LongestStreak, streak)
+		prevStreakDay = day
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if activity.ActiveDays > 0 {
+		activity.LastDay = prev.Format("2006-01-02")
+	}
+	// Today is usually synced only once it is over, so a streak ending
+	// yesterday is still alive
+	if streak > 0 && !prevStreakDay.Before(today.AddDate(0, 0, -1)) {
+		activity.CurrentStreak = streak
+	}
+	return activity, nil
+}
+
+// GetWeekdayTotals returns the total seconds per weekday between start and
+// end, inclusive, indexed from weekStart (0), e.g. Monday (0) to Sunday (6).
+//
+// Days are stored as calendar dates already in the configured timezone, so the
+// weekday is that of the date itself. It is derived from the date string alone,
+// independent of the server's timezone and locale.
+func (db *DB) GetWeekdayTotals(start, end time.Time, weekStart time.Weekday) ([7]float64, error) {
+	var totals [7]float64
+
+	rows, err := db.Query(`
+		SELECT day, total_seconds
+		FROM day_summaries WHERE day >= ? AND day <= ?
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"))
 	if err != nil {
-		return err
+		return totals, err
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
-	for _, s := range stats {
-		_, err := stmt.Exec(s.Day.Format("2006-01-02"), s.Type, s.Name, s.TotalSeconds, time.Now())
+	for rows.Next() {
+		var dayStr string
+		var seconds float64
+		if err := rows.Scan(&dayStr, &seconds); err != nil {
+			return totals, err
+		}
+		day := parseDay(dayStr)
+		totals[(day.Weekday()-weekStart+7)%7] += seconds
+	}
+	return totals, rows.Err()
+}
+
+// WeekTotal is the total time of the week starting on WeekStart
+type WeekTotal struct {
+	WeekStart    string  `json:"week_start"`
+	TotalSeconds float64 `json:"total_seconds"`
+}
+
+// GetWeeklyTotals returns the total seconds of each week with data between
+// start and end, inclusive, for weeks starting on weekStart. Weeks are keyed
+// by the date of their first day rather than by strftime's %W (Monday) or %U
+// (Sunday) week numbers, which restart every year and would split the week
+// spanning New Year.
+func (db *DB) GetWeeklyTotals(start, end time.Time, weekStart time.Weekday) ([]WeekTotal, error) {
+	rows, err := db.Query(`
+		SELECT date(day, '-' || ((CAST(strftime('%w', day) AS INTEGER) - ? + 7) % 7) || ' days') AS week,
+			SUM(total_seconds)
+		FROM day_summaries WHERE day >= ? AND day <= ?
+		GROUP BY week ORDER BY week
+	`, int(weekStart), start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var weeks []WeekTotal
+	for rows.Next() {
+		var w WeekTotal
+		if err := rows.Scan(&w.WeekStart, &w.TotalSeconds); err != nil {
+			return nil, err
+		}
+		weeks = append(weeks, w)
+	}
+	return weeks, rows.Err()
+}
+
+// --- Day Stats operations ---
+
+func (db *DB) DeleteDayStatsByDay(day time.Time) error {
+	err := deleteDayStatsByDay(db.DB, day)
+	return db.mirrored(err, "DeleteDayStatsByDay", func(m *DB) error { return m.DeleteDayStatsByDay(day) })
+}
+
+func deleteDayStatsByDay(ex execer, day time.Time) error {
+	_, err := ex.Exec("DELETE FROM day_stats WHERE day = ?", day.Format("2006-01-02"))
+	return err
+}
+
+func deleteDayStatsByType(ex execer, day time.Time, statType string) error {
+	_, err := ex.Exec("DELETE FROM day_stats WHERE day = ? AND type = ?", day.Format("2006-01-02"), statType)
+	return err
+}
+
+func (db *DB) InsertDayStats(stats []DayStats) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertDayStats(tx, stats); err != nil {
+		return err
+	}
+	return db.mirrored(tx.Commit(), "InsertDayStats", func(m *DB) error { return m.InsertDayStats(stats) })
+}
+
+func insertDayStats(ex execer, stats []DayStats) error {
+	stmt, err := ex.Prepare(`
+		INSERT INTO day_stats (day, type, project, name, total_seconds, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(day, type, project, name) DO UPDATE SET total_seconds = excluded.total_seconds
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, s := range stats {
+		_, err := stmt.Exec(s.Day.Format("2006-01-02"), s.Type, s.Project, s.Name, s.TotalSeconds, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) GetDayStatsByDayAndType(day time.Time, statType string) ([]DayStats, error) {
+	rows, err := db.Query(`
+		SELECT id, day, type, project, name, total_seconds, created_at
+		FROM day_stats WHERE day = ? AND type = ?
+	`, day.Format("2006-01-02"), statType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DayStats
+	for rows.Next() {
+		var s DayStats
+		var dayStr string
+		if err := rows.Scan(&s.ID, &dayStr, &s.Type, &s.Project, &s.Name, &s.TotalSeconds, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		s.Day = parseDay(dayStr)
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetDailyStatsByTypeName returns the daily totals of one name of a breakdown
+// type, e.g. the "Go" language, for the days in a range that have any
+func (db *DB) GetDailyStatsByTypeName(start, end time.Time, statType, name string) ([]DayStats, error) {
+	rows, err := db.Query(`
+		SELECT date(day), total_seconds
+		FROM day_stats WHERE day >= ? AND day <= ? AND type = ? AND name = ?
+		ORDER BY day
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"), statType, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DayStats
+	for rows.Next() {
+		s := DayStats{Type: statType, Name: name}
+		var dayStr string
+		if err := rows.Scan(&dayStr, &s.TotalSeconds); err != nil {
+			return nil, err
+		}
+		s.Day = parseDay(dayStr)
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetAggregatedStats sums a breakdown type over a date range, largest first.
+// A limit of 0 or less returns all names.
+func (db *DB) GetAggregatedStats(start, end time.Time, statType string, limit int) ([]struct {
+	Name         string  `json:"name"`
+	TotalSeconds float64 `json:"total_seconds"`
+}, error) {
+	if limit <= 0 {
+		limit = -1 // no limit in SQLite
+	}
+	rows, err := db.Query(`
+		SELECT name, SUM(total_seconds) as total
+		FROM day_stats WHERE day >= ? AND day <= ? AND type = ?
+		GROUP BY name ORDER BY total DESC
+		LIMIT ?
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"), statType, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []struct {
+		Name         string  `json:"name"`
+		TotalSeconds float64 `json:"total_seconds"`
+	}
+	for rows.Next() {
+		var s struct {
+			Name         string  `json:"name"`
+			TotalSeconds float64 `json:"total_seconds"`
+		}
+		if err := rows.Scan(&s.Name, &s.TotalSeconds); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetAggregatedProjectStats sums a per-project breakdown type, branch or
+// entity, over a date range by project and name, largest first
+func (db *DB) GetAggregatedProjectStats(start, end time.Time, statType string) ([]DayStats, error) {
+	rows, err := db.Query(`
+		SELECT project, name, SUM(total_seconds) as total
+		FROM day_stats WHERE day >= ? AND day <= ? AND type = ?
+		GROUP BY project, name ORDER BY total DESC
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"), statType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DayStats
+	for rows.Next() {
+		s := DayStats{Type: statType}
+		if err := rows.Scan(&s.Project, &s.Name, &s.TotalSeconds); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetAggregatedStatsTotal sums all names of a breakdown type over a date range
+func (db *DB) GetAggregatedStatsTotal(start, end time.Time, statType string) (float64, error) {
+	var total float64
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(total_seconds), 0)
+		FROM day_stats WHERE day >= ? AND day <= ? AND type = ?
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"), statType).Scan(&total)
+	return total, err
+}
+
+// GetProjectComparison sums each project's time in a current period (A) and a
+// previous one (B), sorted by the current period's total. Projects with time
+// in only one of the periods have 0 in the other.
+func (db *DB) GetProjectComparison(periodAStart, periodAEnd, periodBStart, periodBEnd time.Time) ([]ProjectComparison, error) {
+	aStart, aEnd := periodAStart.Format("2006-01-02"), periodAEnd.Format("2006-01-02")
+	bStart, bEnd := periodBStart.Format("2006-01-02"), periodBEnd.Format("2006-01-02")
+	rows, err := db.Query(`
+		SELECT name,
+			SUM(CASE WHEN day >= ? AND day <= ? THEN total_seconds ELSE 0 END) AS current,
+			SUM(CASE WHEN day >= ? AND day <= ? THEN total_seconds ELSE 0 END) AS previous
+		FROM day_stats
+		WHERE type = 'project' AND ((day >= ? AND day <= ?) OR (day >= ? AND day <= ?))
+		GROUP BY name ORDER BY current DESC, previous DESC, name
+	`, aStart, aEnd, bStart, bEnd, aStart, aEnd, bStart, bEnd)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comparison := []ProjectComparison{}
+	for rows.Next() {
+		var c ProjectComparison
+		if err := rows.Scan(&c.Name, &c.CurrentSeconds, &c.PreviousSeconds); err != nil {
+			return nil, err
+		}
+		c.DeltaSeconds = c.CurrentSeconds - c.PreviousSeconds
+		if c.PreviousSeconds > 0 {
+			percent := c.DeltaSeconds / c.PreviousSeconds * 100
+			c.PercentChange = &percent
+		}
+		comparison = append(comparison, c)
+	}
+	return comparison, rows.Err()
+}
+
+// GetProjectLanguageBreakdown sums a project's detailed durations by language
+// over a date range, largest first. Durations without a language count as
+// "Other". Returns an empty list if the project has no detailed durations.
+func (db *DB) GetProjectLanguageBreakdown(project string, start, end time.Time) ([]struct {
+	Name         string  `json:"name"`
+	TotalSeconds float64 `json:"total_seconds"`
+}, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(NULLIF(language, ''), 'Other') AS lang, SUM(duration) as total
+		FROM project_durations WHERE project = ? AND day >= ? AND day <= ?
+		GROUP BY lang ORDER BY total DESC
+	`, project, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []struct {
+		Name         string  `json:"name"`
+		TotalSeconds float64 `json:"total_seconds"`
+	}{}
+	for rows.Next() {
+		var s struct {
+			Name         string  `json:"name"`
+			TotalSeconds float64 `json:"total_seconds"`
+		}
+		if err := rows.Scan(&s.Name, &s.TotalSeconds); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// StatTypes lists the types of stats stored in day_stats
+var StatTypes = []string{"category", "language", "editor", "os", "project", "branch", "entity", "dependency", "machine"}
+
+// ValidStatType reports whether statType is stored in day_stats
+func ValidStatType(statType string) bool {
+	for _, t := range StatTypes {
+		if t == statType {
+			return true
+		}
+	}
+	return false
+}
+
+// DailyStat is the time spent on one name of a stat type on one day
+type DailyStat struct {
+	Day          string  `json:"day"`
+	Name         string  `json:"name"`
+	TotalSeconds float64 `json:"total_seconds"`
+}
+
+// GetDailyStatsSeries returns the time per day and name of a stat type over a
+// date range, by day and largest first. Days without time are left out.
+func (db *DB) GetDailyStatsSeries(start, end time.Time, statType string) ([]DailyStat, error) {
+	rows, err := db.Query(`
+		SELECT date(day), name, total_seconds
+		FROM day_stats WHERE day >= ? AND day <= ? AND type = ?
+		ORDER BY day, total_seconds DESC
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"), statType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []DailyStat{}
+	for rows.Next() {
+		var s DailyStat
+		if err := rows.Scan(&s.Day, &s.Name, &s.TotalSeconds); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// --- Yearly Activity operations (for GitHub-style heatmap) ---
+
+// GetAvailableYears returns distinct years that have data in day_summaries or,
+// for days whose summary is missing, project stats in day_stats
+func (db *DB) GetAvailableYears() ([]int, error) {
+	rows, err := db.Query(`
+		SELECT CAST(strftime('%Y', day) AS INTEGER) as year FROM day_summaries
+		UNION
+		SELECT CAST(strftime('%Y', day) AS INTEGER) FROM day_stats WHERE type = 'project'
+		ORDER BY year DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	years := []int{}
+	for rows.Next() {
+		var year int
+		if err := rows.Scan(&year); err != nil {
+			return nil, err
+		}
+		years = append(years, year)
+	}
+	return years, rows.Err()
+}
+
+// GetActivityBounds returns the earliest and latest day with activity data,
+// as YYYY-MM-DD, or empty strings if there is none
+func (db *DB) GetActivityBounds() (earliest, latest string, err error) {
+	var first, last sql.NullString
+	err = db.QueryRow(`
+		SELECT MIN(day), MAX(day) FROM (
+			SELECT substr(day, 1, 10) AS day FROM day_summaries
+			UNION ALL
+			SELECT substr(day, 1, 10) FROM day_stats WHERE type = 'project'
+		)
+	`).Scan(&first, &last)
+	return first.String, last.String, err
+}
+
+// YearlyActivityDay represents activity data for a single day
+type YearlyActivityDay struct {
+	Date         string             `json:"date"`
+	TotalSeconds float64            `json:"total_seconds"`
+	Projects     []ProjectBreakdown `json:"projects,omitempty"`
+}
+
+type ProjectBreakdown struct {
+	Name         string  `json:"name"`
+	TotalSeconds float64 `json:"total_seconds"`
+}
+
+// GetYearlyActivity returns daily totals and project breakdown for an entire year
+func (db *DB) GetYearlyActivity(year int) ([]YearlyActivityDay, error) {
+	return db.GetActivityRange(
+		time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC),
+	)
+}
+
+// GetActivityRange returns daily totals and project breakdown for every day
+// from start to end, ordered by date. Days with project stats but no summary
+// are included, with their total summed from the project stats.
+func (db *DB) GetActivityRange(start, end time.Time) ([]YearlyActivityDay, error) {
+	startDate := start.Format("2006-01-02")
+	endDate := end.Format("2006-01-02")
+
+	// Days are normalized to YYYY-MM-DD (SQLite may return RFC3339) so the
+	// two tables can be joined
+	rows, err := db.Query(`
+		WITH summaries AS (
+			SELECT substr(day, 1, 10) AS day, total_seconds
+			FROM day_summaries
+			WHERE day >= ? AND day <= ?
+		),
+		projects AS (
+			SELECT substr(day, 1, 10) AS day, name, total_seconds
+			FROM day_stats
+			WHERE day >= ? AND day <= ? AND type = 'project'
+		),
+		days AS (
+			SELECT day, total_seconds FROM summaries
+			UNION ALL
+			SELECT day, SUM(total_seconds) FROM projects
+			WHERE day NOT IN (SELECT day FROM summaries)
+			GROUP BY day
+		)
+		SELECT days.day, days.total_seconds, projects.name, projects.total_seconds
+		FROM days
+		LEFT JOIN projects ON projects.day = days.day
+		ORDER BY days.day, projects.total_seconds DESC
+	`, startDate, endDate, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []YearlyActivityDay{}
+	for rows.Next() {
+		var day string
+		var totalSeconds float64
+		var project sql.NullString
+		var projectSeconds sql.NullFloat64
+		if err := rows.Scan(&day, &totalSeconds, &project, &projectSeconds); err != nil {
+			return nil, err
+		}
+
+		// Rows are ordered by day, one per project
+		if len(result) == 0 || result[len(result)-1].Date != day {
+			result = append(result, YearlyActivityDay{
+				Date:         day,
+				TotalSeconds: totalSeconds,
+				Projects:     []ProjectBreakdown{},
+			})
+		}
+		if project.Valid {
+			last := &result[len(result)-1]
+			last.Projects = append(last.Projects, ProjectBreakdown{
+				Name:         project.String,
+				TotalSeconds: projectSeconds.Float64,
+			})
+		}
+	}
+	return result, rows.Err()
+}
+
+// --- Content hash operations ---
+
+// GetContentHash returns the stored hash of the last synced response of the given kind for a day,
+// or an empty string if there is none
+func (db *DB) GetContentHash(day time.Time, kind string) (string, error) {
+	var hash string
+	err := db.QueryRow("SELECT hash FROM content_hashes WHERE day = ? AND kind = ?", day.Format("2006-01-02"), kind).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+func (db *DB) SetContentHash(day time.Time, kind string, hash string) error {
+	err := setContentHash(db.DB, day, kind, hash)
+	return db.mirrored(err, "SetContentHash", func(m *DB) error { return m.SetContentHash(day, kind, hash) })
+}
+
+func setContentHash(ex execer, day time.Time, kind string, hash string) error {
+	_, err := ex.Exec(`
+		INSERT INTO content_hashes (day, kind, hash, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(day, kind) DO UPDATE SET hash = excluded.hash, updated_at = excluded.updated_at
+	`, day.Format("2006-01-02"), kind, hash, time.Now())
+	return err
+}
+
+// --- WakaTime goal operations ---
+
+// ReplaceWakaTimeGoals replaces all stored WakaTime goals with the given ones
+func (db *DB) ReplaceWakaTimeGoals(goals []WakaTimeGoal) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM wakatime_goals"); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO wakatime_goals (uuid, title, type, delta, seconds, improve_by_percent, status, status_percent_calculated,
+			range_text, is_enabled, is_snoozed, is_inverse, languages, projects, editors, chart_data,
+			modified_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, jsonb(?), jsonb(?), jsonb(?), jsonb(?), ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, g := range goals {
+		_, err := stmt.Exec(
+			g.UUID, g.Title, g.Type, g.Delta, g.Seconds, g.ImproveByPercent, g.Status, g.StatusPercentCalculated,
+			g.RangeText, g.IsEnabled, g.IsSnoozed, g.IsInverse, g.Languages, g.Projects, g.Editors, g.ChartData,
+			g.ModifiedAt, g.CreatedAt, time.Now(),
+		)
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	return db.mirrored(tx.Commit(), "ReplaceWakaTimeGoals", func(m *DB) error { return m.ReplaceWakaTimeGoals(goals) })
 }
 
-func (db *DB) GetDayStatsByDayAndType(day time.Time, statType string) ([]DayStats, error) {
+func (db *DB) GetWakaTimeGoals() ([]WakaTimeGoal, error) {
 	rows, err := db.Query(`
-		SELECT id, day, type, name, total_seconds, created_at
-		FROM day_stats WHERE day = ? AND type = ?
-	`, day.Format("2006-01-02"), statType)
+		SELECT uuid, title, COALESCE(type, ''), COALESCE(delta, ''), COALESCE(seconds, 0), COALESCE(improve_by_percent, 0),
+			COALESCE(status, ''), COALESCE(status_percent_calculated, 0), COALESCE(range_text, ''),
+			is_enabled, is_snoozed, is_inverse,
+			COALESCE(json(languages), '[]'), COALESCE(json(projects), '[]'), COALESCE(json(editors), '[]'),
+			COALESCE(json(chart_data), '[]'), modified_at, created_at, updated_at
+		FROM wakatime_goals ORDER BY title
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var stats []DayStats
+	goals := []WakaTimeGoal{}
 	for rows.Next() {
-		var s DayStats
-		var dayStr string
-		if err := rows.Scan(&s.ID, &dayStr, &s.Type, &s.Name, &s.TotalSeconds, &s.CreatedAt); err != nil {
+		var g WakaTimeGoal
+		if err := rows.Scan(
+			&g.UUID, &g.Title, &g.Type, &g.Delta, &g.Seconds, &g.ImproveByPercent,
+			&g.Status, &g.StatusPercentCalculated, &g.RangeText,
+			&g.IsEnabled, &g.IsSnoozed, &g.IsInverse,
+			&g.Languages, &g.Projects, &g.Editors,
+			&g.ChartData, &g.ModifiedAt, &g.CreatedAt, &g.UpdatedAt,
+		); err != nil {
 			return nil, err
 		}
-		s.Day, _ = time.Parse("2006-01-02", dayStr)
-		stats = append(stats, s)
+		goals = append(goals, g)
 	}
-	return stats, rows.Err()
+	return goals, rows.Err()
 }
 
-func (db *DB) GetAggregatedStats(start, end time.Time, statType string) ([]struct {
-	Name         string  `json:"name"`
-	TotalSeconds float64 `json:"total_seconds"`
-}, error) {
-	rows, err := db.Query(`
-		SELECT name, SUM(total_seconds) as total
-		FROM day_stats WHERE day >= ? AND day <= ? AND type = ?
-		GROUP BY name ORDER BY total DESC
-	`, start.Format("2006-01-02"), end.Format("2006-01-02"), statType)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// --- Goal operations ---
+
+// GoalTypeDailySeconds is a goal to code at least TargetSeconds every day
+const GoalTypeDailySeconds = "daily_seconds"
+
+// Goal is a goal defined in this app. At most one of Project and
+// Language may be set to only count time spent on it.
+type Goal struct {
+	ID            int64     `json:"id"`
+	Title         string    `json:"title"`
+	Type          string    `json:"type"`
+	TargetSeconds float64   `json:"target_seconds"`
+	Project       string    `json:"project,omitempty"`
+	Language      string    `json:"language,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
 
-	var stats []struct {
-		Name         string  `json:"name"`
-		TotalSeconds float64 `json:"total_seconds"`
+// CreateGoal inserts g and sets its ID. If g.ID is already set, that ID is
+// used, which keeps IDs identical in the mirror database.
+func (db *DB) CreateGoal(g *Goal) error {
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = time.Now()
 	}
-	for rows.Next() {
-		var s struct {
-			Name         string  `json:"name"`
-			TotalSeconds float64 `json:"total_seconds"`
-		}
-		if err := rows.Scan(&s.Name, &s.TotalSeconds); err != nil {
-			return nil, err
-		}
-		stats = append(stats, s)
+	res, err := db.Exec(`
+		INSERT INTO goals (id, title, type, target_seconds, project, language, created_at)
+		VALUES (NULLIF(?, 0), ?, ?, ?, ?, ?, ?)
+	`, g.ID, g.Title, g.Type, g.TargetSeconds, g.Project, g.Language, g.CreatedAt)
+	if err == nil {
+		g.ID, err = res.LastInsertId()
 	}
-	return stats, rows.Err()
+	return db.mirrored(err, "CreateGoal", func(m *DB) error { return m.CreateGoal(g) })
 }
 
-func (db *DB) GetProjectDailyStats(start, end time.Time) ([]struct {
-	Day          string  `json:"day"`
-	Name         string  `json:"name"`
-	TotalSeconds float64 `json:"total_seconds"`
-}, error) {
+func (db *DB) GetGoals() ([]Goal, error) {
 	rows, err := db.Query(`
-		SELECT day, name, total_seconds
-		FROM day_stats WHERE day >= ? AND day <= ? AND type = 'project'
-		ORDER BY day, total_seconds DESC
-	`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		SELECT id, title, type, target_seconds, project, language, created_at
+		FROM goals ORDER BY id
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var stats []struct {
-		Day          string  `json:"day"`
-		Name         string  `json:"name"`
-		TotalSeconds float64 `json:"total_seconds"`
-	}
+	goals := []Goal{}
 	for rows.Next() {
-		var s struct {
-			Day          string  `json:"day"`
-			Name         string  `json:"name"`
-			TotalSeconds float64 `json:"total_seconds"`
-		}
-		if err := rows.Scan(&s.Day, &s.Name, &s.TotalSeconds); err != nil {
+		var g Goal
+		if err := rows.Scan(&g.ID, &g.Title, &g.Type, &g.TargetSeconds, &g.Project, &g.Language, &g.CreatedAt); err != nil {
 			return nil, err
 		}
-		stats = append(stats, s)
+		goals = append(goals, g)
 	}
-	return stats, rows.Err()
+	return goals, rows.Err()
 }
 
-// --- Yearly Activity operations (for GitHub-style heatmap) ---
-
-// GetAvailableYears returns distinct years that have data in day_summaries
-func (db *DB) GetAvailableYears() ([]int, error) {
-	rows, err := db.Query(`
-		SELECT DISTINCT CAST(strftime('%Y', day) AS INTEGER) as year
-		FROM day_summaries
-		ORDER BY year DESC
-	`)
+// GetGoal returns nil if there is no goal with the given ID
+func (db *DB) GetGoal(id int64) (*Goal, error) {
+	var g Goal
+	err := db.QueryRow(`
+		SELECT id, title, type, target_seconds, project, language, created_at
+		FROM goals WHERE id = ?
+	`, id).Scan(&g.ID, &g.Title, &g.Type, &g.TargetSeconds, &g.Project, &g.Language, &g.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return &g, nil
+}
 
-	var years []int
-	for rows.Next() {
-		var year int
-		if err := rows.Scan(&year); err != nil {
-			return nil, err
-		}
-		years = append(years, year)
+// UpdateGoal updates the goal with g.ID, returning sql.ErrNoRows if there is none
+func (db *DB) UpdateGoal(g *Goal) error {
+	res, err := db.Exec(`
+		UPDATE goals SET title = ?, type = ?, target_seconds = ?, project = ?, language = ?
+		WHERE id = ?
+	`, g.Title, g.Type, g.TargetSeconds, g.Project, g.Language, g.ID)
+	if err == nil {
+		err = requireAffected(res)
 	}
-	return years, rows.Err()
+	return db.mirrored(err, "UpdateGoal", func(m *DB) error { return m.UpdateGoal(g) })
 }
 
-// YearlyActivityDay represents activity data for a single day
-type YearlyActivityDay struct {
-	Date         string             `json:"date"`
-	TotalSeconds float64            `json:"total_seconds"`
-	Projects     []ProjectBreakdown `json:"projects,omitempty"`
+// DeleteGoal deletes a goal, returning sql.ErrNoRows if there is none
+func (db *DB) DeleteGoal(id int64) error {
+	res, err := db.Exec("DELETE FROM goals WHERE id = ?", id)
+	if err == nil {
+		err = requireAffected(res)
+	}
+	return db.mirrored(err, "DeleteGoal", func(m *DB) error { return m.DeleteGoal(id) })
 }
 
-type ProjectBreakdown struct {
-	Name         string  `json:"name"`
-	TotalSeconds float64 `json:"total_seconds"`
+func requireAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
 
-// GetYearlyActivity returns daily totals and project breakdown for an entire year
-func (db *DB) GetYearlyActivity(year int) ([]YearlyActivityDay, error) {
-	// First get all day summaries for the year
-	startDate := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
-	endDate := time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+// GoalDay is the progress towards a goal on a single day
+type GoalDay struct {
+	Day             string  `json:"date"`
+	AchievedSeconds float64 `json:"achieved_seconds"`
+	TargetSeconds   float64 `json:"target_seconds"`
+	Met             bool    `json:"met"`
+}
 
-	rows, err := db.Query(`
-		SELECT day, total_seconds
-		FROM day_summaries
-		WHERE day >= ? AND day <= ?
-		ORDER BY day
-	`, startDate, endDate)
+// GoalProgress is the evaluation of a goal over a date range
+type GoalProgress struct {
+	Days []GoalDay `json:"days"`
+	// CurrentStreak is the number of consecutive days the goal was met, up to
+	// and including the last day of the range
+	CurrentStreak int `json:"current_streak"`
+	LongestStreak int `json:"longest_streak"`
+	DaysMet       int `json:"days_met"`
+}
+
+// EvaluateGoal computes, for every day between start and end (inclusive), the
+// time counting towards the goal and whether its target was met. Unfiltered
+// goals use the day's grand total; project or language goals use that entry
+// of the day's breakdown. Days with less than minSeconds count as zero, so a
+// few scattered seconds do not keep a streak going.
+func (db *DB) EvaluateGoal(g *Goal, start, end time.Time, minSeconds float64) (*GoalProgress, error) {
+	var rows *sql.Rows
+	var err error
+	switch {
+	case g.Project != "":
+		rows, err = db.Query(`
+			SELECT day, total_seconds FROM day_stats
+			WHERE day >= ? AND day <= ? AND type = 'project' AND name = ?
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), g.Project)
+	case g.Language != "":
+		rows, err = db.Query(`
+			SELECT day, total_seconds FROM day_stats
+			WHERE day >= ? AND day <= ? AND type = 'language' AND name = ?
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"), g.Language)
+	default:
+		rows, err = db.Query(`
+			SELECT day, total_seconds FROM day_summaries
+			WHERE day >= ? AND day <= ?
+		`, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	dayMap := make(map[string]*YearlyActivityDay)
+	achieved := make(map[string]float64)
 	for rows.Next() {
 		var day string
-		var totalSeconds float64
-		if err := rows.Scan(&day, &totalSeconds); err != nil {
+		var seconds float64
+		if err := rows.Scan(&day, &seconds); err != nil {
 			return nil, err
 		}
-		// Normalize date to YYYY-MM-DD format (handle possible RFC3339 format from SQLite)
-		normalizedDay := day
+		// Normalize date to YYYY-MM-DD format
 		if len(day) > 10 {
-			normalizedDay = day[:10]
-		}
-		dayMap[normalizedDay] = &YearlyActivityDay{
-			Date:         normalizedDay,
-			TotalSeconds: totalSeconds,
-			Projects:     []ProjectBreakdown{},
+			day = day[:10]
 		}
+		achieved[day] += seconds
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	// Get project breakdown for each day
-	projectRows, err := db.Query(`
-		SELECT day, name, total_seconds
-		FROM day_stats
-		WHERE day >= ? AND day <= ? AND type = 'project'
-		ORDER BY day, total_seconds DESC
-	`, startDate, endDate)
+	progress := &GoalProgress{Days: []GoalDay{}}
+	streak := 0
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		if achieved[day] < minSeconds {
+			achieved[day] = 0
+		}
+		met := achieved[day] >= g.TargetSeconds
+		progress.Days = append(progress.Days, GoalDay{
+			Day:             day,
+			AchievedSeconds: achieved[day],
+			TargetSeconds:   g.TargetSeconds,
+			Met:             met,
+		})
+		if met {
+			streak++
+			progress.DaysMet++
+		} else {
+			streak = 0
+		}
+		if streak > progress.LongestStreak {
+			progress.LongestStreak = streak
+		}
+	}
+	progress.CurrentStreak = streak
+	return progress, nil
+}
+
+// --- Project alias operations ---
+
+// ProjectAlias stores the data of project Alias under the canonical name Project
+type ProjectAlias struct {
+	Alias     string    `json:"alias"`
+	Project   string    `json:"project"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrAliasCycle is returned when an alias would map a project onto itself
+var ErrAliasCycle = errors.New("alias would map the project onto itself")
+
+func (db *DB) GetProjectAliases() ([]ProjectAlias, error) {
+	rows, err := db.Query("SELECT alias, project, created_at FROM project_aliases ORDER BY project, alias")
 	if err != nil {
 		return nil, err
 	}
-	defer projectRows.Close()
+	defer rows.Close()
 
-	for projectRows.Next() {
-		var day, name string
-		var totalSeconds float64
-		if err := projectRows.Scan(&day, &name, &totalSeconds); err != nil {
+	aliases := []ProjectAlias{}
+	for rows.Next() {
+		var a ProjectAlias
+		if err := rows.Scan(&a.Alias, &a.Project, &a.CreatedAt); err != nil {
 			return nil, err
 		}
-		// Normalize date to YYYY-MM-DD format
-		normalizedDay := day
-		if len(day) > 10 {
-			normalizedDay = day[:10]
-		}
-		if dayData, exists := dayMap[normalizedDay]; exists {
-			dayData.Projects = append(dayData.Projects, ProjectBreakdown{
-				Name:         name,
-				TotalSeconds: totalSeconds,
-			})
-		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+// GetProjectAliasMap returns the canonical project name of every alias
+func (db *DB) GetProjectAliasMap() (map[string]string, error) {
+	aliases, err := db.GetProjectAliases()
+	if err != nil {
+		return nil, err
 	}
+	m := make(map[string]string, len(aliases))
+	for _, a := range aliases {
+		m[a.Alias] = a.Project
+	}
+	return m, nil
+}
+
+// SetProjectAlias stores alias under project from now on, and moves the data
+// already stored under alias to project. If project is an alias itself, its
+// canonical name is used; aliases of alias are moved to project as well.
+func (db *DB) SetProjectAlias(alias, project string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	// Convert map to slice, ordered by date
-	var result []YearlyActivityDay
-	for _, v := range dayMap {
-		result = append(result, *v)
+	var canonical string
+	err = tx.QueryRow("SELECT project FROM project_aliases WHERE alias = ?", project).Scan(&canonical)
+	if err == nil {
+		project = canonical
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	if project == alias {
+		return ErrAliasCycle
 	}
 
-	// Sort by date
-	for i := 0; i < len(result)-1; i++ {
-		for j := i + 1; j < len(result); j++ {
-			if result[i].Date > result[j].Date {
-				result[i], result[j] = result[j], result[i]
-			}
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE project_aliases SET project = ? WHERE project = ?", []interface{}{project, alias}},
+		{`INSERT INTO project_aliases (alias, project, created_at) VALUES (?, ?, ?)
+			ON CONFLICT(alias) DO UPDATE SET project = excluded.project`, []interface{}{alias, project, time.Now()}},
+		// Add the alias' daily totals to the project's, then drop them
+		{`INSERT INTO day_stats (day, type, project, name, total_seconds, created_at)
+			SELECT day, type, project, ?, total_seconds, created_at FROM day_stats WHERE type = 'project' AND name = ?
+			ON CONFLICT(day, type, project, name) DO UPDATE SET total_seconds = total_seconds + excluded.total_seconds`, []interface{}{project, alias}},
+		{"DELETE FROM day_stats WHERE type = 'project' AND name = ?", []interface{}{alias}},
+		// and the same for its branches and entities
+		{`INSERT INTO day_stats (day, type, project, name, total_seconds, created_at)
+			SELECT day, type, ?, name, total_seconds, created_at FROM day_stats WHERE project = ?
+			ON CONFLICT(day, type, project, name) DO UPDATE SET total_seconds = total_seconds + excluded.total_seconds`, []interface{}{project, alias}},
+		{"DELETE FROM day_stats WHERE project = ?", []interface{}{alias}},
+		{"UPDATE durations SET project = ? WHERE project = ?", []interface{}{project, alias}},
+		{"UPDATE project_durations SET project = ? WHERE project = ?", []interface{}{project, alias}},
+		{"UPDATE heartbeats SET project = ? WHERE project = ?", []interface{}{project, alias}},
+		{"UPDATE goals SET project = ? WHERE project = ?", []interface{}{project, alias}},
+	}
+	for _, st := range stmts {
+		if _, err := tx.Exec(st.query, st.args...); err != nil {
+			return err
 		}
 	}
 
-	return result, nil
+	return db.mirrored(tx.Commit(), "SetProjectAlias", func(m *DB) error { return m.SetProjectAlias(alias, project) })
+}
+
+// DeleteProjectAlias stops storing alias under its project. Data that was
+// already moved to the project stays there.
+func (db *DB) DeleteProjectAlias(alias string) error {
+	res, err := db.Exec("DELETE FROM project_aliases WHERE alias = ?", alias)
+	if err == nil {
+		err = requireAffected(res)
+	}
+	return db.mirrored(err, "DeleteProjectAlias", func(m *DB) error { return m.DeleteProjectAlias(alias) })
+}
+
+// --- Heartbeat search ---
+
+// HeartbeatSearchDay lists the entities matching a search on a single day
+type HeartbeatSearchDay struct {
+	Day        string                  `json:"date"`
+	Heartbeats int                     `json:"heartbeats"`
+	Entities   []HeartbeatSearchEntity `json:"entities"`
+}
+
+// HeartbeatSearchEntity summarizes the heartbeats of an entity on a day
+type HeartbeatSearchEntity struct {
+	Entity     string  `json:"entity"`
+	Project    string  `json:"project"`
+	Heartbeats int     `json:"heartbeats"`
+	FirstTime  float64 `json:"first_time"`
+	LastTime   float64 `json:"last_time"`
+}
+
+// SearchHeartbeats finds heartbeats whose entity contains query (case
+// insensitive) from start to end, grouped by day and entity, ordered by day.
+// Queries of 3 or more characters use the full-text index.
+func (db *DB) SearchHeartbeats(query string, start, end time.Time) ([]HeartbeatSearchDay, error) {
+	var cond, arg string
+	if len([]rune(query)) >= 3 {
+		// A quoted string is matched as a substring by the trigram tokenizer
+		cond = "id IN (SELECT rowid FROM heartbeats_fts WHERE heartbeats_fts MATCH ?)"
+		arg = `"` + strings.ReplaceAll(query, `"`, `""`) + `"`
+	} else {
+		cond = `entity LIKE ? ESCAPE '\'`
+		arg = "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	}
+
+	rows, err := db.Query(`
+		SELECT substr(day, 1, 10) AS d, entity, COALESCE(project, ''), COUNT(*) AS n, MIN(time), MAX(time)
+		FROM heartbeats
+		WHERE day >= ? AND day <= ? AND `+cond+`
+		GROUP BY d, entity, project
+		ORDER BY d, n DESC
+	`, start.Format("2006-01-02"), end.Format("2006-01-02"), arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []HeartbeatSearchDay{}
+	for rows.Next() {
+		var day string
+		var e HeartbeatSearchEntity
+		if err := rows.Scan(&day, &e.Entity, &e.Project, &e.Heartbeats, &e.FirstTime, &e.LastTime); err != nil {
+			return nil, err
+		}
+		// Rows are ordered by day
+		if len(result) == 0 || result[len(result)-1].Day != day {
+			result = append(result, HeartbeatSearchDay{Day: day, Entities: []HeartbeatSearchEntity{}})
+		}
+		last := &result[len(result)-1]
+		last.Heartbeats += e.Heartbeats
+		last.Entities = append(last.Entities, e)
+	}
+	return result, rows.Err()
 }
 
 // --- Sync Log operations ---
 
-func (db *DB) RecordSync(day time.Time, totalSeconds float64, status string) error {
-	_, err := db.Exec(`
-		INSERT INTO sync_log (day, synced_at, total_seconds, status)
-		VALUES (?, ?, ?, ?)
-		ON CONFLICT(day) DO UPDATE SET synced_at = excluded.synced_at, total_seconds = excluded.total_seconds, status = excluded.status
-	`, day.Format("2006-01-02"), time.Now(), totalSeconds, status)
+// RecordSync records the outcome of syncing a day. errMsg is the reason a
+// failed sync failed; it is stored as NULL when empty.
+func (db *DB) RecordSync(day time.Time, totalSeconds float64, status, errMsg string) error {
+	err := recordSync(db.DB, day, totalSeconds, status, errMsg)
+	return db.mirrored(err, "RecordSync", func(m *DB) error { return m.RecordSync(day, totalSeconds, status, errMsg) })
+}
+
+func recordSync(ex execer, day time.Time, totalSeconds float64, status, errMsg string) error {
+	var errText sql.NullString
+	if errMsg != "" {
+		errText = sql.NullString{String: errMsg, Valid: true}
+	}
+	_, err := ex.Exec(`
+		INSERT INTO sync_log (day, synced_at, total_seconds, status, error)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(day) DO UPDATE SET synced_at = excluded.synced_at, total_seconds = excluded.total_seconds, status = excluded.status, error = excluded.error
+	`, day.Format("2006-01-02"), time.Now(), totalSeconds, status, errText)
 	return err
 }
 
 func (db *DB) GetLastSyncedDay() (time.Time, error) {
 	var dayStr string
-	err := db.QueryRow("SELECT day FROM sync_log WHERE status = 'success' ORDER BY day DESC LIMIT 1").Scan(&dayStr)
+	err := db.QueryRow("SELECT day FROM sync_log WHERE status IN ('success', 'partial') ORDER BY day DESC LIMIT 1").Scan(&dayStr)
 	if err == sql.ErrNoRows {
 		return time.Time{}, nil
 	}
@@ -725,21 +2568,172 @@ func (db *DB) GetLastSyncedDay() (time.Time, error) {
 	return t, err
 }
 
+// SyncLogEntry is a single row of the sync_log table
+type SyncLogEntry struct {
+	Day          string    `json:"day"`
+	SyncedAt     time.Time `json:"synced_at"`
+	TotalSeconds float64   `json:"total_seconds"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"` // why the sync failed, or which data a partial sync lacks
+}
+
+// SyncStats summarizes the sync_log table
+type SyncStats struct {
+	LastSyncedAt   time.Time      `json:"last_synced_at"` // zero if nothing was synced yet
+	LastSyncDay    string         `json:"last_sync_day"`  // day of the most recent sync attempt
+	LastStatus     string         `json:"last_status"`
+	LastError      string         `json:"last_error"` // empty unless the most recent sync failed or was partial
+	SuccessDays    int            `json:"success_days"`
+	PartialDays    int            `json:"partial_days"` // synced, but WakaTime left out some breakdowns
+	FailedDays     int            `json:"failed_days"`
+	RecentFailures []SyncLogEntry `json:"recent_failures"` // most recent first
+}
+
+// GetSyncStats returns the most recent sync attempt, the number of days whose
+// last sync succeeded, was partial or failed, and up to failureLimit of the
+// latest failures
+func (db *DB) GetSyncStats(failureLimit int) (*SyncStats, error) {
+	stats := &SyncStats{RecentFailures: []SyncLogEntry{}}
+
+	err := db.QueryRow(`
+		SELECT day, synced_at, COALESCE(status, ''), COALESCE(error, '')
+		FROM sync_log ORDER BY synced_at DESC LIMIT 1
+	`).Scan(&stats.LastSyncDay, &stats.LastSyncedAt, &stats.LastStatus, &stats.LastError)
+	if err == sql.ErrNoRows {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Normalize date to YYYY-MM-DD format
+	if len(stats.LastSyncDay) > 10 {
+		stats.LastSyncDay = stats.LastSyncDay[:10]
+	}
+
+	err = db.QueryRow(`
+		SELECT COUNT(CASE WHEN status = 'success' THEN 1 END), COUNT(CASE WHEN status = 'partial' THEN 1 END),
+			COUNT(CASE WHEN status = 'failed' THEN 1 END)
+		FROM sync_log
+	`).Scan(&stats.SuccessDays, &stats.PartialDays, &stats.FailedDays)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT day, synced_at, COALESCE(total_seconds, 0), COALESCE(status, ''), COALESCE(error, '')
+		FROM sync_log WHERE status = 'failed'
+		ORDER BY synced_at DESC, day DESC
+		LIMIT ?
+	`, failureLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e SyncLogEntry
+		if err := rows.Scan(&e.Day, &e.SyncedAt, &e.TotalSeconds, &e.Status, &e.Error); err != nil {
+			return nil, err
+		}
+		// Normalize date to YYYY-MM-DD format
+		if len(e.Day) > 10 {
+			e.Day = e.Day[:10]
+		}
+		stats.RecentFailures = append(stats.RecentFailures, e)
+	}
+	return stats, rows.Err()
+}
+
+// GetSyncLog returns sync_log rows ordered by most recent sync first, along with the total row count
+func (db *DB) GetSyncLog(limit, offset int) ([]SyncLogEntry, int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sync_log").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT day, synced_at, COALESCE(total_seconds, 0), COALESCE(status, ''), COALESCE(error, '')
+		FROM sync_log
+		ORDER BY synced_at DESC, day DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []SyncLogEntry{}
+	for rows.Next() {
+		var e SyncLogEntry
+		if err := rows.Scan(&e.Day, &e.SyncedAt, &e.TotalSeconds, &e.Status, &e.Error); err != nil {
+			return nil, 0, err
+		}
+		// Normalize date to YYYY-MM-DD format
+		if len(e.Day) > 10 {
+			e.Day = e.Day[:10]
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+// HasSyncHistory reports whether any day has ever been synced
+func (db *DB) HasSyncHistory() (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sync_log").Scan(&count)
+	return count > 0, err
+}
+
+// GetMissingDays returns the days from start to end, in order, that have no
+// successful (or partial) sync
+func (db *DB) GetMissingDays(start, end time.Time) ([]time.Time, error) {
+	rows, err := db.Query("SELECT date(day) FROM sync_log WHERE day >= ? AND day <= ? AND status IN ('success', 'partial')",
+		start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	synced := make(map[string]bool)
+	for rows.Next() {
+		var day string
+		if err := rows.Scan(&day); err != nil {
+			return nil, err
+		}
+		synced[day] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var missing []time.Time
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if !synced[d.Format("2006-01-02")] {
+			missing = append(missing, d)
+		}
+	}
+	return missing, nil
+}
+
 func (db *DB) IsDaySynced(day time.Time) (bool, error) {
 	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM sync_log WHERE day = ? AND status = 'success'", day.Format("2006-01-02")).Scan(&count)
+	err := db.QueryRow("SELECT COUNT(*) FROM sync_log WHERE day = ? AND status IN ('success', 'partial')", day.Format("2006-01-02")).Scan(&count)
 	return count > 0, err
 }
 
 // Type aliases for external usage
 type Duration = struct {
-	ID           int64     `json:"id"`
-	Day          time.Time `json:"day"`
-	Project      string    `json:"project"`
-	StartTime    float64   `json:"time"`
-	Duration     float64   `json:"duration"`
-	Dependencies string    `json:"dependencies,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID             int64     `json:"id"`
+	Day            time.Time `json:"day"`
+	Project        string    `json:"project"`
+	StartTime      float64   `json:"time"`
+	Duration       float64   `json:"duration"`
+	Dependencies   string    `json:"dependencies,omitempty"`
+	AIAdditions    int       `json:"ai_additions"`
+	AIDeletions    int       `json:"ai_deletions"`
+	HumanAdditions int       `json:"human_additions"`
+	HumanDeletions int       `json:"human_deletions"`
+	CreatedAt      time.Time `json:"created_at"`
 }
 
 type ProjectDuration = struct {
@@ -787,17 +2781,65 @@ type Project = struct {
 	CreatedAt        time.Time `json:"created_at"`
 }
 
+type Machine = struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	IP         string    `json:"ip,omitempty"`
+	Timezone   string    `json:"timezone,omitempty"`
+	LastSeenAt time.Time `json:"last_seen_at,omitempty"`
+	CreatedAt  time.Time `json:"created_at,omitempty"`
+}
+
+// ProjectComparison is a project's time in two periods. PercentChange is nil
+// if the project has no time in the previous period.
+type ProjectComparison = struct {
+	Name            string   `json:"name"`
+	CurrentSeconds  float64  `json:"current_seconds"`
+	PreviousSeconds float64  `json:"previous_seconds"`
+	DeltaSeconds    float64  `json:"delta_seconds"`
+	PercentChange   *float64 `json:"percent_change"`
+}
+
 type DaySummary = struct {
-	ID           int64     `json:"id"`
-	Day          time.Time `json:"day"`
-	TotalSeconds float64   `json:"total_seconds"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID             int64     `json:"id"`
+	Day            time.Time `json:"day"`
+	TotalSeconds   float64   `json:"total_seconds"`
+	AIAdditions    int       `json:"ai_additions"`
+	AIDeletions    int       `json:"ai_deletions"`
+	HumanAdditions int       `json:"human_additions"`
+	HumanDeletions int       `json:"human_deletions"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// WakaTimeGoal is a goal defined on WakaTime. Languages, Projects, Editors and
+// ChartData hold JSON as returned by the API.
+type WakaTimeGoal = struct {
+	UUID                    string    `json:"id"`
+	Title                   string    `json:"title"`
+	Type                    string    `json:"type"`
+	Delta                   string    `json:"delta"`
+	Seconds                 float64   `json:"seconds"`
+	ImproveByPercent        float64   `json:"improve_by_percent"`
+	Status                  string    `json:"status"`
+	StatusPercentCalculated float64   `json:"status_percent_calculated"`
+	RangeText               string    `json:"range_text"`
+	IsEnabled               bool      `json:"is_enabled"`
+	IsSnoozed               bool      `json:"is_snoozed"`
+	IsInverse               bool      `json:"is_inverse"`
+	Languages               string    `json:"languages"`
+	Projects                string    `json:"projects"`
+	Editors                 string    `json:"editors"`
+	ChartData               string    `json:"chart_data"`
+	ModifiedAt              time.Time `json:"modified_at"`
+	CreatedAt               time.Time `json:"created_at"`
+	UpdatedAt               time.Time `json:"updated_at"`
 }
 
 type DayStats = struct {
 	ID           int64     `json:"id"`
 	Day          time.Time `json:"day"`
 	Type         string    `json:"type"`
+	Project      string    `json:"project,omitempty"` // of branches and entities, empty for other types
 	Name         string    `json:"name"`
 	TotalSeconds float64   `json:"total_seconds"`
 	CreatedAt    time.Time `json:"created_at"`
@@ -0,0 +1,310 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestDB opens a migrated database in a temporary directory
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "test.db"), Options{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestParseDay(t *testing.T) {
+	for _, s := range []string{"2024-01-02", "2024-01-02T00:00:00Z"} {
+		if got := parseDay(s).Format("2006-01-02"); got != "2024-01-02" {
+			t.Errorf("parseDay(%q) = %s, want 2024-01-02", s, got)
+		}
+	}
+}
+
+// Days are calendar dates in the configured timezone. Far from UTC, e.g. in
+// Pacific/Kiritimati (UTC+14), the local date differs from the UTC date for
+// most of the day, and must be stored and read back unchanged.
+func TestDaysDoNotShiftFarFromUTC(t *testing.T) {
+	loc, err := time.LoadLocation("Pacific/Kiritimati")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	db := newTestDB(t)
+
+	// 2024-01-01 11:00 UTC is already 2024-01-02 01:00 in Kiritimati
+	local := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC).In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	if err := db.UpsertDaySummary(day, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertDayStats([]DayStats{{Day: day, Type: "language", Name: "Go", TotalSeconds: 3600}}); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := db.GetDaySummaries(day, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Day.Format("2006-01-02") != "2024-01-02" {
+		t.Fatalf("GetDaySummaries(2024-01-02) = %+v, want one summary on 2024-01-02", summaries)
+	}
+
+	summary, err := db.GetDaySummary(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary == nil || summary.Day.Format("2006-01-02") != "2024-01-02" {
+		t.Fatalf("GetDaySummary(2024-01-02) = %+v, want the summary of 2024-01-02", summary)
+	}
+
+	stats, err := db.GetDailyStatsByTypeName(day, day, "language", "Go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Day.Format("2006-01-02") != "2024-01-02" {
+		t.Fatalf("GetDailyStatsByTypeName(2024-01-02) = %+v, want one day on 2024-01-02", stats)
+	}
+
+	// The UTC date of the same instant has no data
+	previous, err := db.GetDaySummaries(day.AddDate(0, 0, -1), day.AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previous) != 0 {
+		t.Fatalf("GetDaySummaries(2024-01-01) = %+v, want none", previous)
+	}
+}
+
+func TestRekeyMachineStats(t *testing.T) {
+	db := newTestDB(t)
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	// Stored by name before machines were stored by ID
+	if err := db.InsertDayStats([]DayStats{
+		{Day: day, Type: "machine", Name: "laptop", TotalSeconds: 60},
+		{Day: day, Type: "machine", Name: "desktop", TotalSeconds: 120},
+		{Day: day, Type: "machine", Name: "id-3", TotalSeconds: 180},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []Machine{
+		{ID: "id-1", Name: "laptop"},
+		{ID: "id-2", Name: "desktop"},
+		{ID: "id-3", Name: "desktop"},
+	} {
+		if err := db.UpsertMachine(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.RekeyMachineStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := db.GetDayStatsByDayAndType(day, "machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, s := range stats {
+		got[s.Name] = s.TotalSeconds
+	}
+	// desktop is shared by two machines, so it cannot be resolved
+	want := map[string]float64{"id-1": 60, "desktop": 120, "id-3": 180}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("machine stats = %v, want %v", got, want)
+	}
+}
+
+func TestImportDumpWritesMirror(t *testing.T) {
+	src := newTestDB(t)
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if err := src.UpsertDaySummary(day, 3600); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.InsertDurations([]Duration{{Day: day, Project: "p", StartTime: 1704189600, Duration: 60, Dependencies: `["fmt"]`}}); err != nil {
+		t.Fatal(err)
+	}
+	var dump bytes.Buffer
+	if _, err := src.WriteDump(&dump, func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	db := newTestDB(t)
+	if err := db.OpenMirror(filepath.Join(t.TempDir(), "mirror.db")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ImportDump(&dump); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := db.mirror.GetDaySummary(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary == nil || summary.TotalSeconds != 3600 {
+		t.Errorf("mirror summary = %+v, want 3600 seconds", summary)
+	}
+	durations, err := db.mirror.GetDurationsByDay(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(durations) != 1 {
+		t.Errorf("mirror has %d durations, want 1", len(durations))
+	}
+}
+
+func TestDayStatsProjectMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// day_stats as it was before branches and entities were kept per project
+	for _, stmt := range []string{
+		`CREATE TABLE day_stats (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			day DATE NOT NULL,
+			type TEXT NOT NULL,
+			name TEXT NOT NULL,
+			total_seconds REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(day, type, name)
+		)`,
+		`INSERT INTO day_stats (day, type, name, total_seconds) VALUES ('2024-01-02', 'branch', 'main', 60)`,
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := New(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if err := db.InsertDayStats([]DayStats{
+		{Day: day, Type: "branch", Project: "a", Name: "main", TotalSeconds: 120},
+		{Day: day, Type: "branch", Project: "b", Name: "main", TotalSeconds: 180},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := db.GetDayStatsByDayAndType(day, "branch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, s := range stats {
+		got[s.Project+"/"+s.Name] = s.TotalSeconds
+	}
+	if want := map[string]float64{"/main": 60, "a/main": 120, "b/main": 180}; !reflect.DeepEqual(got, want) {
+		t.Errorf("branch stats = %v, want %v", got, want)
+	}
+}
+
+func TestGoalsTablesRename(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// WakaTime goals were stored in goals, and the goals of this app in local_goals
+	for _, stmt := range []string{
+		`CREATE TABLE goals (id INTEGER PRIMARY KEY AUTOINCREMENT, uuid TEXT NOT NULL UNIQUE, title TEXT NOT NULL)`,
+		`INSERT INTO goals (uuid, title) VALUES ('g-1', 'synced')`,
+		`CREATE TABLE local_goals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL DEFAULT '',
+			type TEXT NOT NULL,
+			target_seconds REAL NOT NULL,
+			project TEXT NOT NULL DEFAULT '',
+			language TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`INSERT INTO local_goals (title, type, target_seconds) VALUES ('local', 'daily_seconds', 3600)`,
+	} {
+		if _, err := old.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := New(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var synced string
+	if err := db.QueryRow("SELECT title FROM wakatime_goals").Scan(&synced); err != nil || synced != "synced" {
+		t.Errorf("wakatime goal = %q (%v), want synced", synced, err)
+	}
+	goals, err := db.GetGoals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goals) != 1 || goals[0].Title != "local" {
+		t.Errorf("goals = %+v, want the local goal", goals)
+	}
+}
+
+func TestLanguageActivity(t *testing.T) {
+	db := newTestDB(t)
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	if err := db.InsertDayStats([]DayStats{
+		{Day: day(1), Type: "language", Name: "Go", TotalSeconds: 600},
+		{Day: day(2), Type: "language", Name: "go", TotalSeconds: 600},
+		{Day: day(3), Type: "language", Name: "Go", TotalSeconds: 60},
+		{Day: day(4), Type: "language", Name: "Go", TotalSeconds: 600},
+		{Day: day(5), Type: "language", Name: "Go", TotalSeconds: 600},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetLanguageActivity("GO", day(6), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The short day breaks the streak but still counts as used
+	want := &LanguageActivity{
+		Language:      "GO",
+		CurrentStreak: 2,
+		LongestStreak: 2,
+		ActiveDays:    5,
+		TotalSeconds:  2460,
+		FirstDay:      "2024-01-01",
+		LastDay:       "2024-01-05",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetLanguageActivity = %+v, want %+v", got, want)
+	}
+}
+
+func TestLatestSyncedHeartbeatTimeIgnoresLocalHeartbeats(t *testing.T) {
+	db := newTestDB(t)
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	base := float64(day.Add(10 * time.Hour).Unix())
+	if err := db.InsertHeartbeats([]HeartBeat{{Day: day, Entity: "/src/a.go", Type: "file", Time: base}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertLocalHeartbeats([]HeartBeat{{Day: day, Entity: "/src/b.go", Type: "file", Time: base + 600}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetLatestSyncedHeartbeatTime(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != base {
+		t.Errorf("GetLatestSyncedHeartbeatTime = %v, want %v", got, base)
+	}
+}
@@ -0,0 +1,356 @@
+package database
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DumpVersion is the version of the dump format, in the header of every dump
+const DumpVersion = 2
+
+// dumpV1Types are the record types of version 1 dumps that were renamed since:
+// goals synced from WakaTime used to be stored in goals, and the goals
+// defined in this app in local_goals
+var dumpV1Types = map[string]string{"goals": "wakatime_goals", "local_goals": "goals"}
+
+// dumpHeaderType tags the first record of a dump
+const dumpHeaderType = "header"
+
+// dumpTable describes how the rows of a table are dumped and imported. Rows
+// are matched to existing ones by their natural key, so importing a dump
+// twice, or into an instance that synced some of the same days, does not
+// duplicate them. Autoincrement IDs differ between instances and are left out.
+type dumpTable struct {
+	name string
+	// omitID leaves out the autoincrement id column
+	omitID bool
+	// conflicts are the ON CONFLICT targets of the natural keys; matching rows
+	// are updated
+	conflicts []string
+	// replaceDay replaces all rows of a day with those in the dump, for tables
+	// without a natural key that are written a whole day at a time
+	replaceDay bool
+	// match are the columns that identify a row without a unique index; rows
+	// are only inserted if none matches
+	match []string
+}
+
+// dumpTables are the tables in a dump, in the order they are written
+var dumpTables = []dumpTable{
+	{name: "projects", omitID: true, conflicts: []string{"(uuid)", "(name) WHERE uuid IS NULL"}},
+	{name: "machines", conflicts: []string{"(id)"}},
+	{name: "project_aliases", conflicts: []string{"(alias)"}},
+	{name: "deleted_projects", conflicts: []string{"(name)"}},
+	{name: "wakatime_goals", omitID: true, conflicts: []string{"(uuid)"}},
+	{name: "goals", omitID: true, match: []string{"title", "type", "target_seconds", "project", "language"}},
+	{name: "sync_log", omitID: true, conflicts: []string{"(day)"}},
+	{name: "content_hashes", omitID: true, conflicts: []string{"(day, kind)"}},
+	{name: "day_summaries", omitID: true, conflicts: []string{"(day)"}},
+	{name: "day_stats", omitID: true, conflicts: []string{"(day, type, project, name)"}},
+	{name: "durations", omitID: true, replaceDay: true},
+	{name: "project_durations", omitID: true, replaceDay: true},
+	{name: "heartbeats", omitID: true, conflicts: []string{"(time, entity, machine_id)"}},
+}
+
+// dumpRecord is a line of a dump: a row of the table named by Type, or the
+// header
+type dumpRecord struct {
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// dumpColumn is a column of a dumped table
+type dumpColumn struct {
+	name string
+	// jsonb is set for JSONB columns, which are dumped as JSON text
+	jsonb bool
+}
+
+// tableColumns returns the columns of a table, in schema order
+func tableColumns(q queryer, table string) ([]dumpColumn, error) {
+	rows, err := q.Query("SELECT name, type FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []dumpColumn
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		columns = append(columns, dumpColumn{name: name, jsonb: strings.EqualFold(typ, "JSONB")})
+	}
+	return columns, rows.Err()
+}
+
+// WriteDump writes every table as JSON Lines, one row per line tagged with
+// its table, after a header line. Rows are read and written one at a time, so
+// the dump is never held in memory; flush is called after every table. Rows
+// are read on the read-only connection in a single transaction, so the dump is
+// consistent, and a slow client does not hold up other queries and syncs.
+func (db *DB) WriteDump(w io.Writer, flush func()) (map[string]int, error) {
+	ro, err := db.readOnlyDB()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := ro.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(dumpRecord{Type: dumpHeaderType, Data: map[string]interface{}{
+		"version":     DumpVersion,
+		"exported_at": time.Now().UTC().Format(time.RFC3339),
+	}}); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(dumpTables))
+	for _, t := range dumpTables {
+		n, err := dumpTableRows(tx, enc, t)
+		counts[t.name] = n
+		if err != nil {
+			return counts, fmt.Errorf("%s: %w", t.name, err)
+		}
+		flush()
+	}
+	return counts, nil
+}
+
+func dumpTableRows(q queryer, enc *json.Encoder, t dumpTable) (int, error) {
+	columns, err := tableColumns(q, t.name)
+	if err != nil {
+		return 0, err
+	}
+	var selected, names []string
+	for _, c := range columns {
+		if t.omitID && c.name == "id" {
+			continue
+		}
+		if c.jsonb {
+			selected = append(selected, fmt.Sprintf(`json("%s")`, c.name))
+		} else {
+			// An expression has no declared type, so the driver returns dates
+			// and times as the text they are stored as instead of parsing them,
+			// and they import unchanged. Numbers keep their type.
+			selected = append(selected, fmt.Sprintf(`CASE WHEN typeof("%s") = 'text' THEN CAST("%s" AS TEXT) ELSE "%s" END`, c.name, c.name, c.name))
+		}
+		names = append(names, c.name)
+	}
+
+	rows, err := q.Query("SELECT " + strings.Join(selected, ", ") + " FROM " + t.name + " ORDER BY rowid")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	values := make([]interface{}, len(names))
+	ptrs := make([]interface{}, len(names))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, err
+		}
+		data := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			data[name] = values[i]
+		}
+		if err := enc.Encode(dumpRecord{Type: t.name, Data: data}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// ErrInvalidDump is returned by ImportDump for input that is not a dump
+var ErrInvalidDump = errors.New("invalid dump")
+
+// ImportDump imports a dump written by WriteDump in a single transaction,
+// reading it one line at a time. Rows are matched to existing ones by their
+// natural key and updated, so importing is idempotent. It returns the number
+// of rows read per table. Writes are repeated on the mirror database once
+// committed, like those of a sync.
+func (db *DB) ImportDump(r io.Reader) (map[string]int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	dec.UseNumber()
+
+	var header dumpRecord
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDump, err)
+	}
+	if header.Type != dumpHeaderType {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidDump)
+	}
+	version, _ := header.Data["version"].(json.Number)
+	if version.String() != fmt.Sprint(DumpVersion) && version.String() != "1" {
+		return nil, fmt.Errorf("%w: unsupported version %v, expected %d", ErrInvalidDump, header.Data["version"], DumpVersion)
+	}
+
+	// Only columns of the schema are accepted, as they are put in the SQL
+	columns := make(map[string]map[string]dumpColumn, len(dumpTables))
+	tables := make(map[string]dumpTable, len(dumpTables))
+	for _, t := range dumpTables {
+		cols, err := tableColumns(db, t.name)
+		if err != nil {
+			return nil, err
+		}
+		columns[t.name] = make(map[string]dumpColumn, len(cols))
+		for _, c := range cols {
+			if !(t.omitID && c.name == "id") {
+				columns[t.name][c.name] = c
+			}
+		}
+		tables[t.name] = t
+	}
+
+	tx, err := db.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmts := make(map[string]*sql.Stmt)
+	queries := make(map[string]string)
+	defer func() {
+		for _, s := range stmts {
+			s.Close()
+		}
+	}()
+	// cleared are the days already replaced, by table
+	cleared := make(map[string]bool)
+
+	counts := make(map[string]int)
+	for line := 2; ; line++ {
+		var rec dumpRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return counts, fmt.Errorf("%w: record %d: %w", ErrInvalidDump, line, err)
+		}
+		if renamed, ok := dumpV1Types[rec.Type]; ok && version.String() == "1" {
+			rec.Type = renamed
+		}
+		t, ok := tables[rec.Type]
+		if !ok {
+			return counts, fmt.Errorf("%w: record %d: unknown type %q", ErrInvalidDump, line, rec.Type)
+		}
+
+		recColumns := make([]dumpColumn, 0, len(rec.Data))
+		for name := range rec.Data {
+			c, ok := columns[t.name][name]
+			if !ok {
+				return counts, fmt.Errorf("%w: record %d: unknown column %q of %s", ErrInvalidDump, line, name, t.name)
+			}
+			recColumns = append(recColumns, c)
+		}
+		sort.Slice(recColumns, func(i, j int) bool { return recColumns[i].name < recColumns[j].name })
+
+		if t.replaceDay {
+			day, _ := rec.Data["day"].(string)
+			if key := t.name + "\x00" + day; !cleared[key] {
+				if err := tx.exec("DELETE FROM "+t.name+" WHERE day = ?", day); err != nil {
+					return counts, err
+				}
+				cleared[key] = true
+			}
+		}
+
+		// Rows of a table normally have the same columns, so this prepares
+		// one statement per table
+		key := t.name
+		for _, c := range recColumns {
+			key += "\x00" + c.name
+		}
+		stmt, ok := stmts[key]
+		if !ok {
+			queries[key] = dumpInsertSQL(t, recColumns)
+			if stmt, err = tx.tx.Prepare(queries[key]); err != nil {
+				return counts, fmt.Errorf("%s: %w", t.name, err)
+			}
+			stmts[key] = stmt
+		}
+		query := queries[key]
+
+		args := make([]interface{}, 0, len(recColumns)+len(t.match))
+		for _, c := range recColumns {
+			args = append(args, dumpValue(rec.Data[c.name]))
+		}
+		for _, c := range t.match {
+			args = append(args, dumpValue(rec.Data[c]))
+		}
+		_, err := stmt.Exec(args...)
+		if err := tx.record(err, func(m *Tx) error { return m.exec(query, args...) }); err != nil {
+			return counts, fmt.Errorf("record %d (%s): %w", line, t.name, err)
+		}
+		counts[t.name]++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return counts, err
+	}
+	return counts, nil
+}
+
+// dumpInsertSQL builds the statement importing a row of t with the given
+// columns
+func dumpInsertSQL(t dumpTable, columns []dumpColumn) string {
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	updates := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = `"` + c.name + `"`
+		placeholders[i] = "?"
+		if c.jsonb {
+			placeholders[i] = "jsonb(?)"
+		}
+		updates[i] = quoted[i] + " = excluded." + quoted[i]
+	}
+	insert := "INSERT INTO " + t.name + " (" + strings.Join(quoted, ", ") + ") "
+
+	if len(t.match) > 0 {
+		conds := make([]string, len(t.match))
+		for i, c := range t.match {
+			conds[i] = `"` + c + `" IS ?`
+		}
+		return insert + "SELECT " + strings.Join(placeholders, ", ") +
+			" WHERE NOT EXISTS (SELECT 1 FROM " + t.name + " WHERE " + strings.Join(conds, " AND ") + ")"
+	}
+
+	query := insert + "VALUES (" + strings.Join(placeholders, ", ") + ")"
+	for _, target := range t.conflicts {
+		query += " ON CONFLICT" + target + " DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	return query
+}
+
+// dumpValue converts a decoded JSON value to a value for the database.
+// Numbers are decoded as json.Number to keep integers exact.
+func dumpValue(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrQueryNotAllowed is returned for queries other than a single SELECT
+var ErrQueryNotAllowed = errors.New("only a single SELECT statement is allowed")
+
+// forbiddenKeywords may not appear anywhere in a read-only query, outside of
+// strings and quoted identifiers. The read-only connection rejects writes
+// anyway; this catches them early with a clear error, and covers statements
+// like ATTACH that a read-only connection would still run. REPLACE is not
+// listed, as it is also a string function; see ValidateReadOnlyQuery.
+var forbiddenKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "UPSERT": true,
+	"CREATE": true, "DROP": true, "ALTER": true, "REINDEX": true, "VACUUM": true,
+	"ATTACH": true, "DETACH": true, "PRAGMA": true, "ANALYZE": true,
+	"BEGIN": true, "COMMIT": true, "ROLLBACK": true, "SAVEPOINT": true, "RELEASE": true,
+}
+
+// queryTokens splits a query into its words and symbols, leaving out
+// comments, strings and quoted identifiers. It returns the query without a
+// trailing semicolon, and an error if it has more than one statement.
+func queryTokens(query string) ([]string, string, error) {
+	var tokens []string
+	runes := []rune(query)
+	end := len(runes)
+	// A trailing semicolon (and whitespace) is allowed
+	for end > 0 && (unicode.IsSpace(runes[end-1]) || runes[end-1] == ';') {
+		end--
+	}
+
+	for i := 0; i < end; {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < end && runes[i+1] == '-':
+			for i < end && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < end && runes[i+1] == '*':
+			i += 2
+			for i < end && !(runes[i] == '*' && i+1 < end && runes[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case r == '\'' || r == '"' || r == '`' || r == '[':
+			closing := r
+			if r == '[' {
+				closing = ']'
+			}
+			i++
+			for i < end {
+				if runes[i] == closing {
+					// Doubled quotes escape the quote
+					if closing != ']' && i+1 < end && runes[i+1] == closing {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			if i >= end {
+				return nil, "", errors.New("unterminated string or identifier")
+			}
+			i++
+			tokens = append(tokens, string(closing))
+		case r == ';':
+			return nil, "", ErrQueryNotAllowed
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < end && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, strings.ToUpper(string(runes[start:i])))
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens, string(runes[:end]), nil
+}
+
+// ValidateReadOnlyQuery checks that query is a single SELECT (optionally with
+// a WITH clause) and returns it without a trailing semicolon
+func ValidateReadOnlyQuery(query string) (string, error) {
+	tokens, query, err := queryTokens(query)
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) == 0 || (tokens[0] != "SELECT" && tokens[0] != "WITH" && tokens[0] != "VALUES") {
+		return "", ErrQueryNotAllowed
+	}
+	for i, t := range tokens {
+		// REPLACE(x, y, z) is a string function, other uses write
+		replace := t == "REPLACE" && (i+1 == len(tokens) || tokens[i+1] != "(")
+		if forbiddenKeywords[t] || replace {
+			return "", fmt.Errorf("%w: %s is not allowed", ErrQueryNotAllowed, t)
+		}
+	}
+	return query, nil
+}
+
+// QueryResult is the result of a read-only query. Truncated is set if there
+// were more rows than the limit.
+type QueryResult struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated"`
+}
+
+// readOnlyDB opens the read-only connection used for user queries on first use
+func (db *DB) readOnlyDB() (*sql.DB, error) {
+	db.readOnlyOnce.Do(func() {
+		dsn := fmt.Sprintf("file:%s?mode=ro&_pragma=query_only(1)&_pragma=busy_timeout(%d)",
+			(&url.URL{Path: db.path}).EscapedPath(), db.opts.BusyTimeout)
+		db.readOnly, db.readOnlyErr = sql.Open("sqlite", dsn)
+	})
+	return db.readOnly, db.readOnlyErr
+}
+
+// ReadOnlyQuery runs a single SELECT on a read-only connection, returning at
+// most maxRows rows. It is cancelled when ctx is done.
+func (db *DB) ReadOnlyQuery(ctx context.Context, query string, maxRows int) (*QueryResult, error) {
+	query, err := ValidateReadOnlyQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	ro, err := db.readOnlyDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := ro.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QueryResult{Columns: columns, Rows: [][]interface{}{}}
+	for rows.Next() {
+		if len(result.Rows) >= maxRows {
+			result.Truncated = true
+			break
+		}
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			switch v := v.(type) {
+			case []byte:
+				values[i] = string(v)
+			case time.Time:
+				values[i] = v.Format(time.RFC3339)
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
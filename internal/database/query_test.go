@@ -0,0 +1,31 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateReadOnlyQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		allowed bool
+	}{
+		{"SELECT name FROM day_stats", true},
+		{"SELECT REPLACE(name, '-', ' ') FROM day_stats;", true},
+		{"select replace (name, '-', ' ') from day_stats", true},
+		{"WITH t AS (SELECT 1) REPLACE INTO day_stats SELECT * FROM t", false},
+		{"WITH t AS (SELECT 1) INSERT OR REPLACE INTO day_stats SELECT * FROM t", false},
+		{"REPLACE INTO day_stats VALUES (1)", false},
+		{"SELECT 1; DELETE FROM day_stats", false},
+		{"SELECT 'DELETE' AS word", true},
+	}
+	for _, tt := range tests {
+		_, err := ValidateReadOnlyQuery(tt.query)
+		if allowed := err == nil; allowed != tt.allowed {
+			t.Errorf("ValidateReadOnlyQuery(%q) = %v, want allowed %v", tt.query, err, tt.allowed)
+		}
+		if err != nil && !errors.Is(err, ErrQueryNotAllowed) {
+			t.Errorf("ValidateReadOnlyQuery(%q) = %v, want ErrQueryNotAllowed", tt.query, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// execer is implemented by *sql.DB and *sql.Tx, so writes can run on either
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx, so reads can run on either
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Tx groups the writes of a day's sync or of an import, so they are committed
+// or rolled back together. Writes are repeated on the mirror database once
+// committed.
+type Tx struct {
+	tx *sql.Tx
+	db *DB
+
+	// writes replays the writes on the mirror's Tx
+	writes []func(m *Tx) error
+}
+
+// BeginTx starts a transaction for the writes of a day's sync
+func (db *DB) BeginTx() (*Tx, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx, db: db}, nil
+}
+
+// Commit commits the transaction. A failure to write the mirror is logged.
+func (t *Tx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	if t.db.mirror == nil || len(t.writes) == 0 {
+		return nil
+	}
+
+	m, err := t.db.mirror.BeginTx()
+	if err == nil {
+		for _, write := range t.writes {
+			if err = write(m); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = m.Commit()
+		} else {
+			m.Rollback()
+		}
+	}
+	if err != nil {
+		slog.Warn("failed to write to mirror database", "op", "Tx", "error", err)
+	}
+	return nil
+}
+
+// Rollback discards the transaction. It is a no-op after Commit.
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// record remembers a successful write for the mirror
+func (t *Tx) record(err error, write func(m *Tx) error) error {
+	if err == nil && t.db.mirror != nil {
+		t.writes = append(t.writes, write)
+	}
+	return err
+}
+
+// exec runs a statement built elsewhere, e.g. by ImportDump
+func (t *Tx) exec(query string, args ...interface{}) error {
+	_, err := t.tx.Exec(query, args...)
+	return t.record(err, func(m *Tx) error { return m.exec(query, args...) })
+}
+
+func (t *Tx) UpsertDaySummary(day time.Time, totalSeconds float64) error {
+	return t.record(upsertDaySummary(t.tx, day, totalSeconds), func(m *Tx) error { return m.UpsertDaySummary(day, totalSeconds) })
+}
+
+func (t *Tx) UpdateDaySummaryLineChanges(day time.Time, aiAdditions, aiDeletions, humanAdditions, humanDeletions int) error {
+	err := updateDaySummaryLineChanges(t.tx, day, aiAdditions, aiDeletions, humanAdditions, humanDeletions)
+	return t.record(err, func(m *Tx) error {
+		return m.UpdateDaySummaryLineChanges(day, aiAdditions, aiDeletions, humanAdditions, humanDeletions)
+	})
+}
+
+func (t *Tx) DeleteDayStatsByDay(day time.Time) error {
+	return t.record(deleteDayStatsByDay(t.tx, day), func(m *Tx) error { return m.DeleteDayStatsByDay(day) })
+}
+
+// DeleteDayStatsByType deletes the day's stats of one type, e.g. "language"
+func (t *Tx) DeleteDayStatsByType(day time.Time, statType string) error {
+	return t.record(deleteDayStatsByType(t.tx, day, statType), func(m *Tx) error { return m.DeleteDayStatsByType(day, statType) })
+}
+
+func (t *Tx) InsertDayStats(stats []DayStats) error {
+	return t.record(insertDayStats(t.tx, stats), func(m *Tx) error { return m.InsertDayStats(stats) })
+}
+
+func (t *Tx) DeleteDurationsByDay(day time.Time) error {
+	return t.record(deleteDurationsByDay(t.tx, day), func(m *Tx) error { return m.DeleteDurationsByDay(day) })
+}
+
+func (t *Tx) InsertDurations(durations []Duration) error {
+	return t.record(insertDurations(t.tx, durations), func(m *Tx) error { return m.InsertDurations(durations) })
+}
+
+func (t *Tx) DeleteProjectDurationsByDay(day time.Time) error {
+	return t.record(deleteProjectDurationsByDay(t.tx, day), func(m *Tx) error { return m.DeleteProjectDurationsByDay(day) })
+}
+
+func (t *Tx) InsertProjectDurations(durations []ProjectDuration) error {
+	return t.record(insertProjectDurations(t.tx, durations), func(m *Tx) error { return m.InsertProjectDurations(durations) })
+}
+
+func (t *Tx) DeleteHeartbeatsByDay(day time.Time) error {
+	return t.record(deleteHeartbeatsByDay(t.tx, day), func(m *Tx) error { return m.DeleteHeartbeatsByDay(day) })
+}
+
+// InsertHeartbeats stores heartbeats synced from WakaTime
+func (t *Tx) InsertHeartbeats(heartbeats []HeartBeat) error {
+	_, err := insertHeartbeats(t.tx, heartbeats, HeartbeatSourceWakaTime)
+	return t.record(err, func(m *Tx) error { return m.InsertHeartbeats(heartbeats) })
+}
+
+func (t *Tx) SetContentHash(day time.Time, kind string, hash string) error {
+	return t.record(setContentHash(t.tx, day, kind, hash), func(m *Tx) error { return m.SetContentHash(day, kind, hash) })
+}
+
+func (t *Tx) RecordSync(day time.Time, totalSeconds float64, status, errMsg string) error {
+	return t.record(recordSync(t.tx, day, totalSeconds, status, errMsg), func(m *Tx) error {
+		return m.RecordSync(day, totalSeconds, status, errMsg)
+	})
+}
@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"errors"
+	gosync "sync"
+	"time"
+)
+
+// ErrTooManySubscribers is returned by Subscribe when the subscriber cap is reached
+var ErrTooManySubscribers = errors.New("too many event subscribers")
+
+// Event describes something that happened during a sync
+type Event struct {
+	Type         string    `json:"type"` // day_synced, day_failed
+	Date         string    `json:"date"`
+	TotalSeconds float64   `json:"total_seconds"`
+	Error        string    `json:"error,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// eventBus fans out sync events to a bounded number of subscribers
+type eventBus struct {
+	mu             gosync.Mutex
+	maxSubscribers int
+	subscribers    map[chan Event]struct{}
+	// closed is set by close, after which subscriptions end right away
+	closed bool
+}
+
+func newEventBus(maxSubscribers int) *eventBus {
+	return &eventBus{
+		maxSubscribers: maxSubscribers,
+		subscribers:    make(map[chan Event]struct{}),
+	}
+}
+
+func (b *eventBus) subscribe() (<-chan Event, func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		ch := make(chan Event)
+		close(ch)
+		return ch, func() {}, nil
+	}
+	if len(b.subscribers) >= b.maxSubscribers {
+		return nil, nil, ErrTooManySubscribers
+	}
+
+	ch := make(chan Event, 16)
+	b.subscribers[ch] = struct{}{}
+
+	var once gosync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			// close may have closed it already
+			if _, ok := b.subscribers[ch]; ok {
+				delete(b.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+	return ch, unsubscribe, nil
+}
+
+// close closes the channels of all subscribers, and of later ones right away
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		// Never block the sync on a slow subscriber; drop the event instead
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a listener for sync events. The returned function must
+// be called to unsubscribe once the listener is done. ErrTooManySubscribers
+// is returned when max_event_subscribers listeners are already registered.
+func (s *Syncer) Subscribe() (<-chan Event, func(), error) {
+	return s.events.subscribe()
+}
+
+// CloseEvents ends every subscription to sync events, e.g. on shutdown, where
+// open event streams would otherwise keep the server from shutting down.
+// Later subscriptions end right away.
+func (s *Syncer) CloseEvents() {
+	s.events.close()
+}
@@ -0,0 +1,235 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/wakatime"
+)
+
+// sqliteHeader is the magic string every SQLite database file starts with
+const sqliteHeader = "SQLite format 3\x00"
+
+// ImportOfflineHeartbeats imports heartbeats buffered by wakatime-cli while
+// offline and recomputes the summaries of affected days that were not synced
+// from WakaTime. Synced days keep WakaTime's totals, and re-syncs keep the
+// imported heartbeats. It returns the number of heartbeats inserted.
+//
+// Supported formats:
+//   - JSON lines, one heartbeat per line, as sent by editor plugins
+//   - a JSON array of heartbeats
+//   - the legacy SQLite offline queue (.wakatime.db, table heartbeat_2)
+//
+// Heartbeats already stored (same time, entity and machine) are skipped, so
+// importing the same file twice is harmless.
+func (s *Syncer) ImportOfflineHeartbeats(path string) (int, error) {
+	heartbeats, err := readOfflineHeartbeats(path)
+	if err != nil {
+		return 0, err
+	}
+
+	inserted, days, err := s.storeHeartbeats(heartbeats)
+	if err != nil {
+		return inserted, err
+	}
+
+	slog.Info("imported offline heartbeats", "path", path, "read", len(heartbeats), "inserted", inserted, "days", days)
+	return inserted, nil
+}
+
+// IngestHeartbeats stores heartbeats sent directly to this server, e.g. by
+// editor plugins, and recomputes the summaries of affected days that were not
+// synced from WakaTime. It returns the number of heartbeats inserted.
+func (s *Syncer) IngestHeartbeats(heartbeats []wakatime.HeartbeatData) (int, error) {
+	inserted, days, err := s.storeHeartbeats(heartbeats)
+	if err != nil {
+		return inserted, err
+	}
+
+	slog.Info("ingested heartbeats", "received", len(heartbeats), "inserted", inserted, "days", days)
+	return inserted, nil
+}
+
+// storeHeartbeats inserts the heartbeats not stored yet, grouped by day, as
+// local heartbeats that re-syncs keep, and recomputes the summaries of the
+// days with new heartbeats (see recomputePending). It returns the number
+// inserted and the number of days they span.
+func (s *Syncer) storeHeartbeats(heartbeats []wakatime.HeartbeatData) (int, int, error) {
+	loc := s.cfg.GetTimezone()
+	names := s.loadProjectNames()
+	byDay := make(map[string][]wakatime.HeartbeatData)
+	for _, h := range s.includedHeartbeats(heartbeats, names) {
+		if h.Entity == "" || h.Time <= 0 {
+			continue
+		}
+		day := time.Unix(int64(h.Time), 0).In(loc).Format("2006-01-02")
+		byDay[day] = append(byDay[day], h)
+	}
+
+	days := make([]string, 0, len(byDay))
+	for day := range byDay {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	inserted := 0
+	for _, dayStr := range days {
+		day, _ := time.Parse("2006-01-02", dayStr)
+
+		// Duplicates are skipped by the unique index
+		n, err := s.db.InsertLocalHeartbeats(toHeartbeats(day, byDay[dayStr], names))
+		if err != nil {
+			return inserted, len(days), err
+		}
+		inserted += n
+		if n > 0 {
+			s.pendingMu.Lock()
+			if s.pending == nil {
+				s.pending = make(map[string]time.Time)
+			}
+			s.pending[dayStr] = day
+			s.pendingMu.Unlock()
+		}
+	}
+	s.recomputePending()
+	return inserted, len(days), nil
+}
+
+// recomputePending recomputes the summaries of the days with ingested
+// heartbeats that were not synced from WakaTime; synced days keep WakaTime's
+// totals. Recomputing a day while a sync writes it could overwrite the synced
+// summary, so while a sync is running the days stay pending, and are
+// recomputed once it is done. Syncs never wait for more than the day being
+// recomputed.
+func (s *Syncer) recomputePending() {
+	s.runningMu.Lock()
+	if s.stopping.Err() != nil {
+		s.runningMu.Unlock()
+		return
+	}
+	s.running.Add(1)
+	s.runningMu.Unlock()
+	defer s.running.Done()
+	s.recomputePendingDays()
+}
+
+// recomputePendingDays is recomputePending for callers counted in running
+func (s *Syncer) recomputePendingDays() {
+	s.recomputeMu.Lock()
+	defer s.recomputeMu.Unlock()
+	for s.stopping.Err() == nil && !s.IsSyncing() {
+		s.pendingMu.Lock()
+		var dayStr string
+		var day time.Time
+		for dayStr, day = range s.pending {
+			break
+		}
+		delete(s.pending, dayStr)
+		s.pendingMu.Unlock()
+		if dayStr == "" {
+			return
+		}
+
+		synced, err := s.db.IsDaySynced(day)
+		if err != nil {
+			slog.Error("failed to check whether a day with new heartbeats is synced", "date", dayStr, "error", err)
+			continue
+		}
+		if synced {
+			slog.Debug("keeping the synced summary of a day with new heartbeats", "date", dayStr)
+			continue
+		}
+		if _, err := s.recomputeDay(day); err != nil {
+			slog.Error("failed to recompute day summary", "date", dayStr, "error", err)
+		}
+	}
+	s.pendingMu.Lock()
+	n := len(s.pending)
+	s.pendingMu.Unlock()
+	if n > 0 {
+		slog.Debug("not recomputing days with new heartbeats while a sync is running or stopping", "days", n)
+	}
+}
+
+// readOfflineHeartbeats detects the format of the file at path and decodes all heartbeats in it
+func readOfflineHeartbeats(path string) ([]wakatime.HeartbeatData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	header, _ := br.Peek(len(sqliteHeader))
+	if string(header) == sqliteHeader {
+		return readOfflineSQLite(path)
+	}
+
+	// Skip leading whitespace to see whether this is a JSON array
+	for {
+		b, err := br.Peek(1)
+		if err != nil || !bytes.ContainsAny(b, " \t\r\n") {
+			break
+		}
+		br.ReadByte()
+	}
+	if b, _ := br.Peek(1); len(b) == 1 && b[0] == '[' {
+		var heartbeats []wakatime.HeartbeatData
+		if err := json.NewDecoder(br).Decode(&heartbeats); err != nil {
+			return nil, fmt.Errorf("failed to decode heartbeat array: %w", err)
+		}
+		return heartbeats, nil
+	}
+
+	var heartbeats []wakatime.HeartbeatData
+	dec := json.NewDecoder(br)
+	for {
+		var h wakatime.HeartbeatData
+		if err := dec.Decode(&h); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode heartbeat %d: %w", len(heartbeats)+1, err)
+		}
+		heartbeats = append(heartbeats, h)
+	}
+	return heartbeats, nil
+}
+
+// readOfflineSQLite reads the legacy wakatime offline queue, which stores each
+// heartbeat as a JSON string in the heartbeat column of the heartbeat_2 table
+func readOfflineSQLite(path string) ([]wakatime.HeartbeatData, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT heartbeat FROM heartbeat_2")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offline queue: %w", err)
+	}
+	defer rows.Close()
+
+	var heartbeats []wakatime.HeartbeatData
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var h wakatime.HeartbeatData
+		if err := json.Unmarshal([]byte(raw), &h); err != nil {
+			slog.Warn("skipping undecodable offline heartbeat", "error", err)
+			continue
+		}
+		heartbeats = append(heartbeats, h)
+	}
+	return heartbeats, rows.Err()
+}
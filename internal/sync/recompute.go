@@ -0,0 +1,136 @@
+package sync
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/database"
+)
+
+// ErrNoHeartbeats is returned when recomputing a day without stored heartbeats
+var ErrNoHeartbeats = errors.New("no heartbeats stored for this day")
+
+// recomputedStatTypes are the day_stats types that can be derived from
+// heartbeats. Others (editors, operating systems, dependencies) are kept as
+// synced from WakaTime.
+var recomputedStatTypes = []string{"category", "language", "project", "branch", "entity", "machine"}
+
+// statKey identifies a stat of a day, see database.DayStats
+type statKey struct {
+	statType, project, name string
+}
+
+// aggregateHeartbeats sums the time between consecutive heartbeats, skipping
+// gaps longer than timeout, the same way WakaTime turns heartbeats into
+// durations. The time up to the next heartbeat is attributed to the project,
+// language etc. of the earlier one. It returns the total and the seconds per
+// stat type and name, with branches and entities kept per project.
+func aggregateHeartbeats(heartbeats []database.HeartBeat, timeout time.Duration) (float64, map[statKey]float64) {
+	sorted := make([]database.HeartBeat, len(heartbeats))
+	copy(sorted, heartbeats)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+
+	stats := make(map[statKey]float64)
+	add := func(statType, project, name, fallback string, seconds float64) {
+		if name == "" {
+			name = fallback
+		}
+		if name != "" {
+			stats[statKey{statType, project, name}] += seconds
+		}
+	}
+
+	var total float64
+	for i := 1; i < len(sorted); i++ {
+		gap := sorted[i].Time - sorted[i-1].Time
+		if gap > timeout.Seconds() || gap <= 0 {
+			continue
+		}
+		total += gap
+
+		h := sorted[i-1]
+		project := h.Project
+		if project == "" {
+			project = "Unknown Project"
+		}
+		add("category", "", h.Category, "coding", gap)
+		add("language", "", h.Language, "Other", gap)
+		add("project", "", project, "", gap)
+		add("branch", project, h.Branch, "", gap)
+		add("entity", project, h.Entity, "", gap)
+		add("machine", "", h.MachineID, "", gap)
+	}
+	return total, stats
+}
+
+// ComputeDay computes a day's grand total and its category, language,
+// project, branch, entity and machine stats from the locally stored
+// heartbeats, without storing them, e.g. for today before it is synced. It
+// returns ErrNoHeartbeats if there are none.
+func (s *Syncer) ComputeDay(day time.Time) (float64, []database.DayStats, error) {
+	heartbeats, err := s.db.GetHeartbeatsByDay(day)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(heartbeats) == 0 {
+		return 0, nil, ErrNoHeartbeats
+	}
+
+	totalSeconds, totals := aggregateHeartbeats(heartbeats, s.cfg.GetHeartbeatTimeout())
+
+	stats := make([]database.DayStats, 0, len(totals))
+	for k, seconds := range totals {
+		stats = append(stats, database.DayStats{Day: day, Type: k.statType, Project: k.project, Name: k.name, TotalSeconds: seconds})
+	}
+	return totalSeconds, stats, nil
+}
+
+// RecomputeDay rebuilds a day's grand total and its category, language,
+// project, branch, entity and machine stats from the locally stored
+// heartbeats, e.g. after importing heartbeats that never reached WakaTime.
+// It returns ErrNoHeartbeats if there are none, leaving the day unchanged, and
+// ErrSyncInProgress if a sync is running, as it could overwrite the day.
+func (s *Syncer) RecomputeDay(day time.Time) (float64, error) {
+	release, ok := s.tryBegin()
+	if !ok {
+		return 0, ErrSyncInProgress
+	}
+	defer release()
+	return s.recomputeDay(day)
+}
+
+// recomputeDay is RecomputeDay for callers that hold the sync lock
+func (s *Syncer) recomputeDay(day time.Time) (float64, error) {
+	totalSeconds, stats, err := s.ComputeDay(day)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.UpsertDaySummary(day, totalSeconds); err != nil {
+		return 0, err
+	}
+	for _, statType := range recomputedStatTypes {
+		if err := tx.DeleteDayStatsByType(day, statType); err != nil {
+			return 0, err
+		}
+	}
+	if len(stats) > 0 {
+		if err := tx.InsertDayStats(stats); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	slog.Info("recomputed day from heartbeats", "date", day.Format("2006-01-02"), "total_seconds", totalSeconds, "stats_count", len(stats))
+	return totalSeconds, nil
+}
@@ -1,9 +1,19 @@
 package sync
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/charlie0129/wakatime-sync-go/internal/config"
@@ -17,13 +27,87 @@ type Syncer struct {
 	db     *database.DB
 	client *wakatime.Client
 	cron   *cron.Cron
+	events *eventBus
+
+	// user is the WakaTime account, fetched once by ValidateCredentials
+	user *wakatime.UserData
+
+	// ctx is cancelled by Stop to abort in-flight WakaTime requests
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stopping is cancelled as soon as Stop is called, so running syncs finish
+	// the day they are on and no new ones start
+	stopping  context.Context
+	stopSyncs context.CancelFunc
+
+	// running counts the syncs in progress, for Stop to wait for. runningMu
+	// keeps a sync from starting while Stop waits.
+	running   gosync.WaitGroup
+	runningMu gosync.Mutex
+
+	// syncOwner is the token of the sync currently in progress, 0 if idle.
+	// syncActivity is when that sync last made progress, in unix nanoseconds.
+	syncOwner    atomic.Int64
+	syncActivity atomic.Int64
+	syncSeq      atomic.Int64
+
+	// validated is set once WakaTime accepted the API key; validating while a
+	// background check is running
+	validated  atomic.Bool
+	validating atomic.Bool
+
+	// lastScheduled is the result of the latest scheduled sync, nil if none ran yet
+	lastScheduled atomic.Pointer[ScheduledSyncResult]
+
+	// todayMu keeps syncs of today's heartbeats from overlapping, without
+	// blocking other syncs. todayDate and todayTotal are WakaTime's total of
+	// the day when its heartbeats were last fetched.
+	todayMu    gosync.Mutex
+	todayDate  string
+	todayTotal float64
+
+	// recomputeMu serializes recomputes of days with ingested heartbeats,
+	// which a starting sync waits for. pending are the days waiting to be
+	// recomputed, by date, e.g. until a running sync is done.
+	recomputeMu gosync.Mutex
+	pendingMu   gosync.Mutex
+	pending     map[string]time.Time
+}
+
+// ScheduledSyncResult is the outcome of a scheduled sync of yesterday's data.
+// Error lists the days that failed, including earlier days of the lookback.
+type ScheduledSyncResult struct {
+	Date  string    `json:"date"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
 }
 
+// ErrSyncInProgress is returned when a sync is started while another one is running
+var ErrSyncInProgress = errors.New("a sync is already in progress")
+
+// syncStuckTimeout is how long a sync may go without syncing a day before it
+// is considered stuck and another sync may take over
+const syncStuckTimeout = 30 * time.Minute
+
 func NewSyncer(cfg *config.Config, db *database.DB) *Syncer {
+	client := wakatime.NewClientWithBaseURL(cfg.WakaTimeAPI, cfg.ProxyURL, cfg.WakaTimeBaseURL)
+	client.SetMaxRetries(cfg.MaxRetries)
+	if cfg.DebugSaveFailedResponses {
+		client.SaveFailedResponses(cfg.DebugResponsesDir)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopping, stopSyncs := context.WithCancel(ctx)
+
 	return &Syncer{
-		cfg:    cfg,
-		db:     db,
-		client: wakatime.NewClientWithBaseURL(cfg.WakaTimeAPI, cfg.ProxyURL, cfg.WakaTimeBaseURL),
+		cfg:       cfg,
+		db:        db,
+		client:    client,
+		events:    newEventBus(cfg.MaxEventSubscribers),
+		ctx:       ctx,
+		cancel:    cancel,
+		stopping:  stopping,
+		stopSyncs: stopSyncs,
 	}
 }
 
@@ -38,15 +122,205 @@ func dependenciesToString(deps []string) string {
 	return string(b)
 }
 
+// goalFilterToString converts the languages, projects or editors a goal is
+// limited to to a JSON array for storage, empty if it is not limited
+func goalFilterToString(names []string) string {
+	if names == nil {
+		names = []string{}
+	}
+	b, err := json.Marshal(names)
+	if err != nil {
+		return "[]"
+	}
+	return string(b)
+}
+
+// projectNames maps project names to the canonical name they are stored
+// under, and holds the projects deleted through the API
+type projectNames struct {
+	aliases map[string]string
+	deleted map[string]bool
+}
+
+func (n projectNames) canonical(project string) string {
+	if p, ok := n.aliases[project]; ok {
+		return p
+	}
+	return project
+}
+
+// loadProjectNames returns the configured project aliases and the deleted
+// projects. On error, names are stored as WakaTime reports them, and deleted
+// projects are excluded by exclude_projects or include_projects only.
+func (s *Syncer) loadProjectNames() projectNames {
+	var names projectNames
+	var err error
+	if names.aliases, err = s.db.GetProjectAliasMap(); err != nil {
+		slog.Error("failed to get project aliases", "error", err)
+	}
+	if names.deleted, err = s.db.GetDeletedProjects(); err != nil {
+		slog.Error("failed to get deleted projects", "error", err)
+	}
+	return names
+}
+
+// excluded reports whether a project is excluded by exclude_projects or
+// include_projects, or was deleted, by the name WakaTime reports or the one
+// it is stored under
+func (s *Syncer) excluded(project string, names projectNames) bool {
+	canonical := names.canonical(project)
+	return names.deleted[project] || names.deleted[canonical] || s.cfg.IsProjectExcluded(project, canonical)
+}
+
+// includedHeartbeats returns the heartbeats not in excluded projects
+func (s *Syncer) includedHeartbeats(data []wakatime.HeartbeatData, names projectNames) []wakatime.HeartbeatData {
+	if len(s.cfg.ExcludeProjects) == 0 && len(s.cfg.IncludeProjects) == 0 && len(names.deleted) == 0 {
+		return data
+	}
+	var included []wakatime.HeartbeatData
+	for _, h := range data {
+		if !s.excluded(h.Project, names) {
+			included = append(included, h)
+		}
+	}
+	return included
+}
+
+// GetUser returns the current WakaTime user
+func (s *Syncer) GetUser(ctx context.Context) (*wakatime.UserData, error) {
+	resp, err := s.client.GetUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// GetSlicedDurations fetches a day's durations from WakaTime, optionally of a
+// single project, sliced by sliceBy
+func (s *Syncer) GetSlicedDurations(ctx context.Context, day time.Time, project, sliceBy string) ([]wakatime.DurationData, error) {
+	resp, err := s.client.GetDurationsWithProject(ctx, day, project, sliceBy)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// GetLatestHeartbeat fetches today's heartbeats from WakaTime and returns the
+// most recent one, or nil if there are none yet.
+func (s *Syncer) GetLatestHeartbeat(ctx context.Context) (*wakatime.HeartbeatData, error) {
+	today := time.Now().In(s.cfg.GetTimezone())
+	resp, err := s.client.GetHeartbeats(ctx, today)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *wakatime.HeartbeatData
+	for i := range resp.Data {
+		if latest == nil || resp.Data[i].Time > latest.Time {
+			latest = &resp.Data[i]
+		}
+	}
+	return latest, nil
+}
+
+// ValidateCredentials fetches the WakaTime user once to verify that the API key
+// works, so a misconfiguration is reported at startup rather than at the first sync.
+func (s *Syncer) ValidateCredentials() error {
+	user, err := s.GetUser(s.ctx)
+	if err != nil {
+		if errors.Is(err, wakatime.ErrUnauthorized) {
+			slog.Error("wakatime rejected the api key, check wakatime_api_key", "error", err)
+		} else {
+			slog.Error("failed to connect to wakatime", "base_url", s.cfg.WakaTimeBaseURL, "error", err)
+		}
+		return err
+	}
+
+	s.user = user
+	s.validated.Store(true)
+	slog.Info("connected to wakatime", "user", user.DisplayName, "username", user.Username)
+	return nil
+}
+
+// Ready reports whether WakaTime accepted the API key. If it has not yet,
+// e.g. because WakaTime was unreachable at startup, the check is retried in
+// the background.
+func (s *Syncer) Ready() bool {
+	if s.validated.Load() {
+		return true
+	}
+	if s.validating.CompareAndSwap(false, true) {
+		go func() {
+			defer s.validating.Store(false)
+			s.ValidateCredentials()
+		}()
+	}
+	return false
+}
+
+// LastScheduledSync returns the result of the latest scheduled sync, or nil
+// if none ran yet
+func (s *Syncer) LastScheduledSync() *ScheduledSyncResult {
+	return s.lastScheduled.Load()
+}
+
+// ResolveTimezone replaces the "auto" timezone setting with the timezone of
+// the WakaTime account. On failure the server keeps using the Local timezone.
+func (s *Syncer) ResolveTimezone() {
+	if s.cfg.Timezone != config.TimezoneAuto {
+		return
+	}
+
+	user := s.user
+	if user == nil {
+		var err error
+		user, err = s.GetUser(s.ctx)
+		if err != nil {
+			slog.Error("failed to get wakatime user for timezone detection, falling back to Local", "error", err)
+			return
+		}
+	}
+
+	tz := user.Timezone
+	if _, err := time.LoadLocation(tz); tz == "" || err != nil {
+		slog.Error("wakatime account has an invalid timezone, falling back to Local", "timezone", tz, "error", err)
+		return
+	}
+
+	s.cfg.Timezone = tz
+	slog.Info("resolved timezone from wakatime account", "timezone", tz)
+}
+
+// contentHash returns a stable hash of an API response payload
+func contentHash(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 func (s *Syncer) StartScheduler() {
-	// Sync yesterday's data immediately on startup
+	// Sync yesterday's data immediately on startup, or backfill everything
+	// since start_date if nothing has ever been synced
 	if v := os.Getenv("SKIP_INITIAL_SYNC"); v == "1" || v == "true" {
 		slog.Info("skipping initial sync due to SKIP_INITIAL_SYNC env var")
+	} else if s.needsBackfill() {
+		slog.Info("backfilling from start date", "start_date", s.cfg.StartDate)
+		if err := s.Backfill(); err != nil {
+			slog.Error("backfill failed", "error", err)
+		}
 	} else {
 		slog.Info("performing initial sync for yesterday's data")
 		s.SyncYesterday()
 	}
 
+	if interval := s.cfg.GetTodaySyncInterval(); interval > 0 {
+		slog.Info("scheduled sync of today's heartbeats", "interval", interval.String())
+		go s.runTodaySync(interval)
+	}
+
 	// Set up cron scheduler with configured timezone
 	loc := s.cfg.GetTimezone()
 	s.cron = cron.New(cron.WithLocation(loc))
@@ -72,25 +346,341 @@ func (s *Syncer) StartScheduler() {
 	s.cron.Start()
 }
 
-func (s *Syncer) Stop() {
+// Stop stops the cron scheduler and waits for running syncs to finish the day
+// they are on, until ctx is done. In-flight WakaTime requests are then
+// cancelled. It returns ctx.Err() if syncs were still running.
+func (s *Syncer) Stop(ctx context.Context) error {
+	defer s.cancel()
+
+	s.runningMu.Lock()
+	s.stopSyncs()
+	s.runningMu.Unlock()
 	if s.cron != nil {
 		s.cron.Stop()
 	}
+	return s.Wait(ctx)
 }
 
+// Wait waits until no sync is running, e.g. for syncs that Stop aborted to
+// return, until ctx is done. It returns ctx.Err() if syncs were still running.
+func (s *Syncer) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tryBegin marks a sync as running until the returned function is called.
+// It returns false if another sync is running and has made progress within
+// syncStuckTimeout, or if the syncer is stopping.
+func (s *Syncer) tryBegin() (func(), bool) {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	if s.stopping.Err() != nil {
+		return nil, false
+	}
+
+	token := s.syncSeq.Add(1)
+	for {
+		owner := s.syncOwner.Load()
+		if owner != 0 {
+			if !s.syncStuck() {
+				return nil, false
+			}
+			slog.Warn("previous sync made no progress, taking over", "timeout", syncStuckTimeout)
+		}
+		s.touch()
+		if s.syncOwner.CompareAndSwap(owner, token) {
+			s.running.Add(1)
+			// A recompute of ingested heartbeats sees the sync and stops
+			// after the day it is on
+			s.recomputeMu.Lock()
+			s.recomputeMu.Unlock()
+			// Only release if a newer sync has not taken over in the meantime.
+			// Days ingested during the sync are recomputed once it is done.
+			return func() {
+				s.syncOwner.CompareAndSwap(token, 0)
+				go func() {
+					defer s.running.Done()
+					s.recomputePendingDays()
+				}()
+			}, true
+		}
+	}
+}
+
+// touch records that the running sync made progress
+func (s *Syncer) touch() {
+	s.syncActivity.Store(time.Now().UnixNano())
+}
+
+func (s *Syncer) syncStuck() bool {
+	return time.Since(time.Unix(0, s.syncActivity.Load())) > syncStuckTimeout
+}
+
+// IsSyncing reports whether a sync is currently running
+func (s *Syncer) IsSyncing() bool {
+	return s.syncOwner.Load() != 0 && !s.syncStuck()
+}
+
+// StartSync syncs the last days days (or, with catchUp, every day since the
+// last synced one), then projects and goals, in the
+// background. It returns ErrSyncInProgress if another sync is running.
+func (s *Syncer) StartSync(days int, force, catchUp bool) error {
+	return s.startInBackground(func() error { return s.syncDays(days, force, catchUp) })
+}
+
+// StartSyncRange syncs every day from start to end, then projects and goals,
+// in the background. It returns ErrSyncInProgress if another sync is running.
+func (s *Syncer) StartSyncRange(start, end time.Time, force bool) error {
+	return s.startInBackground(func() error { return s.syncDateRange(start, end, force) })
+}
+
+// StartSyncDays syncs the given days, then projects and goals, in the
+// background. It returns ErrSyncInProgress if another sync is running.
+func (s *Syncer) StartSyncDays(days []time.Time, force bool) error {
+	return s.startInBackground(func() error {
+		for _, d := range days {
+			if err := s.stopping.Err(); err != nil {
+				return err
+			}
+			if err := s.SyncDay(d, force); err != nil {
+				slog.Error("failed to sync day", "date", d.Format("2006-01-02"), "error", err)
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteProject deletes a project's data, so syncs leave it out from now on,
+// then re-syncs the days it was on in the background, which takes its time
+// off their language, editor, category and OS breakdowns too. It returns
+// ErrSyncInProgress if another sync is running, as that sync could store the
+// project again.
+func (s *Syncer) DeleteProject(project string) (*database.ProjectDeletion, error) {
+	release, ok := s.tryBegin()
+	if !ok {
+		return nil, ErrSyncInProgress
+	}
+
+	deleted, err := s.db.DeleteProjectData(project)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	go func() {
+		defer release()
+		for _, d := range deleted.Dates {
+			if s.stopping.Err() != nil {
+				return
+			}
+			if err := s.SyncDay(d, true); err != nil {
+				slog.Error("failed to re-sync day of deleted project", "date", d.Format("2006-01-02"), "project", project, "error", err)
+			}
+		}
+	}()
+	return deleted, nil
+}
+
+func (s *Syncer) startInBackground(syncFn func() error) error {
+	release, ok := s.tryBegin()
+	if !ok {
+		return ErrSyncInProgress
+	}
+
+	go func() {
+		defer release()
+		err := syncFn()
+		if s.stopping.Err() != nil {
+			slog.Info("sync stopped for shutdown")
+			return
+		}
+		if err != nil {
+			slog.Error("sync failed", "error", err)
+		}
+		s.SyncProjects()
+		if err := s.SyncMachines(); err != nil {
+			slog.Error("failed to sync machines", "error", err)
+		}
+		if err := s.SyncGoals(); err != nil {
+			slog.Error("failed to sync goals", "error", err)
+		}
+	}()
+	return nil
+}
+
+// SyncYesterday syncs yesterday and the days before it, sync_lookback_days in
+// total. The earlier days were synced by previous runs, so they are forced to
+// pick up heartbeats that arrived late, e.g. from offline editors.
 func (s *Syncer) SyncYesterday() {
+	release, ok := s.tryBegin()
+	if !ok {
+		slog.Warn("skipping sync of yesterday's data, another sync is in progress")
+		return
+	}
+	defer release()
+
 	yesterday := time.Now().In(s.cfg.GetTimezone()).AddDate(0, 0, -1)
-	if err := s.SyncDay(yesterday); err != nil {
-		slog.Error("failed to sync yesterday's data", "date", yesterday.Format("2006-01-02"), "error", err)
+	result := &ScheduledSyncResult{Date: yesterday.Format("2006-01-02"), Time: time.Now()}
+	var failed []string
+	for i := s.cfg.SyncLookbackDays - 1; i >= 0; i-- {
+		if s.stopping.Err() != nil {
+			break
+		}
+		day := yesterday.AddDate(0, 0, -i)
+		if day.Format("2006-01-02") < s.cfg.GetStartDate().Format("2006-01-02") {
+			continue
+		}
+		if err := s.SyncDay(day, i > 0); err != nil {
+			slog.Error("failed to sync day", "date", day.Format("2006-01-02"), "error", err)
+			failed = append(failed, day.Format("2006-01-02")+": "+err.Error())
+		}
+	}
+	result.Error = strings.Join(failed, "; ")
+	s.lastScheduled.Store(result)
+	if err := s.SyncMachines(); err != nil {
+		slog.Error("failed to sync machines", "error", err)
+	}
+	if err := s.SyncGoals(); err != nil {
+		slog.Error("failed to sync goals", "error", err)
 	}
 }
 
-func (s *Syncer) SyncDays(days int) error {
-	end := time.Now().AddDate(0, 0, -1)
-	start := time.Now().AddDate(0, 0, -days)
+// needsBackfill reports whether the sync history is empty, or ends before
+// yesterday because an earlier backfill was interrupted
+func (s *Syncer) needsBackfill() bool {
+	hasHistory, err := s.db.HasSyncHistory()
+	if err != nil {
+		slog.Error("failed to check sync history", "error", err)
+		return false
+	}
+	if !hasHistory {
+		return true
+	}
+
+	lastSynced, err := s.db.GetLastSyncedDay()
+	if err != nil || lastSynced.IsZero() {
+		return false
+	}
+	yesterday := time.Now().In(s.cfg.GetTimezone()).AddDate(0, 0, -1).Format("2006-01-02")
+	return lastSynced.Format("2006-01-02") < yesterday
+}
+
+// backfillProgressInterval is how many days are synced between progress logs
+const backfillProgressInterval = 30
+
+// Backfill syncs every day from the configured start date to yesterday. It
+// resumes after the last successfully synced day if that is later than the
+// start date, and skips days that are already synced.
+func (s *Syncer) Backfill() error {
+	release, ok := s.tryBegin()
+	if !ok {
+		return ErrSyncInProgress
+	}
+	defer release()
+
+	loc := s.cfg.GetTimezone()
+	startDate := s.cfg.GetStartDate()
+	start := time.Date(startDate.Year(), startDate.Month(), startDate.Day(), 0, 0, 0, 0, loc)
+	now := time.Now().In(loc)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -1)
+
+	lastSynced, err := s.db.GetLastSyncedDay()
+	if err != nil {
+		return err
+	}
+	if !lastSynced.IsZero() {
+		resume := time.Date(lastSynced.Year(), lastSynced.Month(), lastSynced.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+		if resume.After(start) {
+			start = resume
+		}
+	}
+
+	if start.After(end) {
+		slog.Info("backfill not needed, already up to date")
+		return nil
+	}
+
+	totalDays := int(end.Sub(start).Hours()/24) + 1
+	slog.Info("starting backfill", "start", start.Format("2006-01-02"), "end", end.Format("2006-01-02"), "days", totalDays)
+
+	done, synced, failed := 0, 0, 0
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if err := s.stopping.Err(); err != nil {
+			return err
+		}
+
+		done++
+		if ok, err := s.db.IsDaySynced(d); err == nil && ok {
+			continue
+		}
+
+		if err := s.SyncDay(d, false); err != nil {
+			failed++
+		} else {
+			synced++
+		}
+
+		if done%backfillProgressInterval == 0 {
+			slog.Info("backfill progress", "date", d.Format("2006-01-02"), "done", done, "total", totalDays, "synced", synced, "failed", failed)
+		}
+	}
+
+	slog.Info("backfill completed", "days", totalDays, "synced", synced, "failed", failed)
+	return nil
+}
+
+// SyncDays syncs the last days days. With catchUp, it instead syncs every day
+// after the last successfully synced one through yesterday, however many that
+// is, so days missed while the service was down are filled in. It returns
+// ErrSyncInProgress if another sync is running.
+func (s *Syncer) SyncDays(days int, force, catchUp bool) error {
+	release, ok := s.tryBegin()
+	if !ok {
+		return ErrSyncInProgress
+	}
+	defer release()
+	return s.syncDays(days, force, catchUp)
+}
+
+func (s *Syncer) syncDays(days int, force, catchUp bool) error {
+	// Days are counted back from today in the configured timezone, not the
+	// server's
+	loc := s.cfg.GetTimezone()
+	now := time.Now().In(loc)
+	end := now.AddDate(0, 0, -1)
+	start := now.AddDate(0, 0, -days)
+
+	if catchUp {
+		lastSynced, err := s.db.GetLastSyncedDay()
+		if err != nil {
+			return err
+		}
+		// Without any synced day there is nothing to catch up from
+		if !lastSynced.IsZero() {
+			end = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -1)
+			start = time.Date(lastSynced.Year(), lastSynced.Month(), lastSynced.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+			if start.After(end) {
+				slog.Info("catch-up sync not needed, already up to date", "last_synced_day", lastSynced.Format("2006-01-02"))
+				return nil
+			}
+			slog.Info("catching up since last synced day", "start", start.Format("2006-01-02"), "end", end.Format("2006-01-02"))
+		}
+	}
 
 	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
-		if err := s.SyncDay(d); err != nil {
+		if err := s.stopping.Err(); err != nil {
+			return err
+		}
+		if err := s.SyncDay(d, force); err != nil {
 			slog.Error("failed to sync day", "date", d.Format("2006-01-02"), "error", err)
 			continue
 		}
@@ -98,9 +688,23 @@ func (s *Syncer) SyncDays(days int) error {
 	return nil
 }
 
-func (s *Syncer) SyncDateRange(start, end time.Time) error {
+// SyncDateRange syncs every day from start to end. It returns
+// ErrSyncInProgress if another sync is running.
+func (s *Syncer) SyncDateRange(start, end time.Time, force bool) error {
+	release, ok := s.tryBegin()
+	if !ok {
+		return ErrSyncInProgress
+	}
+	defer release()
+	return s.syncDateRange(start, end, force)
+}
+
+func (s *Syncer) syncDateRange(start, end time.Time, force bool) error {
 	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
-		if err := s.SyncDay(d); err != nil {
+		if err := s.stopping.Err(); err != nil {
+			return err
+		}
+		if err := s.SyncDay(d, force); err != nil {
 			slog.Error("failed to sync day", "date", d.Format("2006-01-02"), "error", err)
 			continue
 		}
@@ -108,67 +712,150 @@ func (s *Syncer) SyncDateRange(start, end time.Time) error {
 	return nil
 }
 
-func (s *Syncer) SyncDay(day time.Time) error {
+// SyncDay syncs a single day. If force is set, the day's data is replaced
+// even if it looks up to date.
+func (s *Syncer) SyncDay(day time.Time, force bool) error {
 	dateStr := day.Format("2006-01-02")
 	slog.Info("syncing data", "date", dateStr)
+	s.touch()
 
+	// Everything is fetched first and then stored in a single transaction.
 	// Sync summaries first (this gives us the grand total and breakdowns)
-	totalSeconds, err := s.syncSummary(day)
+	totalSeconds, missing, summaryWrite, err := s.syncSummary(s.ctx, day, force)
 	if err != nil {
-		slog.Error("failed to sync summary", "date", dateStr, "error", err)
-		s.db.RecordSync(day, 0, "failed")
-		return err
+		var rateLimitErr *wakatime.RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			slog.Warn("rate limited by wakatime, skipping day", "date", dateStr, "retry_after", rateLimitErr.RetryAfter.String())
+		} else {
+			slog.Error("failed to sync summary", "date", dateStr, "error", err)
+		}
+		return s.dayFailed(day, err)
 	}
 
 	// Sync durations
-	if err := s.syncDurations(day); err != nil {
+	durationCount, durationsWrite, err := s.syncDurations(s.ctx, day, force)
+	if err != nil {
 		slog.Error("failed to sync durations", "date", dateStr, "error", err)
 	}
 
 	// Sync heartbeats
-	if err := s.syncHeartbeats(day); err != nil {
+	heartbeatCount, heartbeatsWrite, err := s.syncHeartbeats(s.ctx, day, force)
+	if err != nil {
 		slog.Error("failed to sync heartbeats", "date", dateStr, "error", err)
 	}
 
-	// Record successful sync
-	s.db.RecordSync(day, totalSeconds, "success")
-	slog.Info("sync completed", "date", dateStr, "total_seconds", totalSeconds)
+	// Days with time but without some breakdowns are kept, but recorded as
+	// partial, as the breakdowns are usually missing because of the account
+	status, note := "success", ""
+	if len(missing) > 0 {
+		status = "partial"
+		note = "wakatime returned no " + strings.Join(missing, ", ") + " for a day with coding time, " +
+			"which usually means the account does not include this data or history"
+		slog.Warn("summary is missing breakdowns, recording the day as partial", "date", dateStr, "missing", missing)
+	}
+
+	if err := s.storeDay(day, totalSeconds, status, note, summaryWrite, durationsWrite, heartbeatsWrite); err != nil {
+		slog.Error("failed to store synced data", "date", dateStr, "error", err)
+		return s.dayFailed(day, err)
+	}
+
+	slog.Info("sync completed", "date", dateStr, "total_seconds", totalSeconds, "status", status)
+	s.events.publish(Event{Type: "day_synced", Date: dateStr, TotalSeconds: totalSeconds, Time: time.Now()})
+	s.notifyWebhook(WebhookPayload{
+		Date:         dateStr,
+		Status:       status,
+		TotalSeconds: totalSeconds,
+		Durations:    durationCount,
+		Heartbeats:   heartbeatCount,
+		Time:         time.Now(),
+	})
 
 	return nil
 }
 
-func (s *Syncer) syncSummary(day time.Time) (float64, error) {
-	resp, err := s.client.GetSummaries(day, day)
+// storeDay applies the writes of a day's sync and records it with status
+// (success or partial), all in a single transaction
+func (s *Syncer) storeDay(day time.Time, totalSeconds float64, status, note string, writes ...dayWrite) error {
+	tx, err := s.db.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, write := range writes {
+		if write == nil {
+			continue
+		}
+		if err := write(tx); err != nil {
+			return err
+		}
+	}
+	if err := tx.RecordSync(day, totalSeconds, status, note); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// dayFailed records a failed sync of the day and reports it
+func (s *Syncer) dayFailed(day time.Time, err error) error {
+	dateStr := day.Format("2006-01-02")
+	s.db.RecordSync(day, 0, "failed", err.Error())
+	s.events.publish(Event{Type: "day_failed", Date: dateStr, Error: err.Error(), Time: time.Now()})
+	s.notifyWebhook(WebhookPayload{Date: dateStr, Status: "failed", Error: err.Error(), Time: time.Now()})
+	return err
+}
+
+// dayWrite stores part of a day's synced data
+type dayWrite func(tx *database.Tx) error
+
+// syncSummary fetches the day's summary and returns its total, the
+// breakdowns missing from it and the writes to store it, nil if it is already
+// up to date
+func (s *Syncer) syncSummary(ctx context.Context, day time.Time, force bool) (float64, []string, dayWrite, error) {
+	resp, err := s.client.GetSummaries(ctx, day, day)
 	if err != nil {
-		return 0, err
+		return 0, nil, nil, err
 	}
 
 	if len(resp.Data) == 0 {
 		slog.Info("no summary data for day", "date", day.Format("2006-01-02"))
-		return 0, nil
+		return 0, nil, nil, nil
 	}
 
 	summary := resp.Data[0]
-	totalSeconds := summary.GrandTotal.TotalSeconds
+	grandTotal := summary.GrandTotal
+	missing := missingBreakdowns(summary)
 
-	// Check if we already have this day with same total
-	existing, err := s.db.GetDaySummary(day)
-	if err != nil {
-		return 0, err
+	// Time in excluded projects is left out of the day. With include_projects,
+	// the day is the sum of the included projects, so time WakaTime does not
+	// break down by project is left out too.
+	names := s.loadProjectNames()
+	var included, excluded []wakatime.SummaryItem
+	var includedSeconds, excludedSeconds float64
+	for _, item := range summary.Projects {
+		if s.excluded(item.Name, names) {
+			excluded = append(excluded, item)
+			excludedSeconds += item.TotalSeconds
+			continue
+		}
+		included = append(included, item)
+		includedSeconds += item.TotalSeconds
 	}
-	if existing != nil && existing.TotalSeconds == totalSeconds {
-		slog.Info("summary already up to date", "date", day.Format("2006-01-02"))
-		return totalSeconds, nil
+	totalSeconds := max(0, grandTotal.TotalSeconds-excludedSeconds)
+	if len(s.cfg.IncludeProjects) > 0 {
+		totalSeconds = includedSeconds
 	}
 
-	// Save grand total
-	if err := s.db.UpsertDaySummary(day, totalSeconds); err != nil {
-		return 0, err
+	// Check if we already have this day with same totals
+	existing, err := s.db.GetDaySummary(day)
+	if err != nil {
+		return 0, nil, nil, err
 	}
-
-	// Delete existing stats for this day
-	if err := s.db.DeleteDayStatsByDay(day); err != nil {
-		return 0, err
+	if !force && existing != nil && existing.TotalSeconds == totalSeconds &&
+		existing.AIAdditions == grandTotal.AIAdditions && existing.AIDeletions == grandTotal.AIDeletions &&
+		existing.HumanAdditions == grandTotal.HumanAdditions && existing.HumanDeletions == grandTotal.HumanDeletions {
+		slog.Info("summary already up to date", "date", day.Format("2006-01-02"))
+		return totalSeconds, missing, nil, nil
 	}
 
 	// Collect all stats
@@ -214,12 +901,19 @@ func (s *Syncer) syncSummary(day time.Time) (float64, error) {
 		})
 	}
 
-	// Projects
-	for _, item := range summary.Projects {
+	// Projects, with aliases merged into their canonical project
+	projectIndex := make(map[string]int)
+	for _, item := range included {
+		name := names.canonical(item.Name)
+		if i, ok := projectIndex[name]; ok {
+			stats[i].TotalSeconds += item.TotalSeconds
+			continue
+		}
+		projectIndex[name] = len(stats)
 		stats = append(stats, database.DayStats{
 			Day:          day,
 			Type:         "project",
-			Name:         item.Name,
+			Name:         name,
 			TotalSeconds: item.TotalSeconds,
 		})
 	}
@@ -234,86 +928,316 @@ func (s *Syncer) syncSummary(day time.Time) (float64, error) {
 		})
 	}
 
-	// Machines
+	// Machines, by ID like in heartbeats, as different machines can share a
+	// name. The names are synced separately.
 	for _, item := range summary.Machines {
+		name := item.MachineNameID
+		if name == "" {
+			name = item.Name
+		}
 		stats = append(stats, database.DayStats{
 			Day:          day,
 			Type:         "machine",
-			Name:         item.Name,
+			Name:         name,
 			TotalSeconds: item.TotalSeconds,
 		})
 	}
 
-	if len(stats) > 0 {
-		if err := s.db.InsertDayStats(stats); err != nil {
-			return 0, err
+	// The other breakdowns include every project. With include_projects they
+	// are summed from the included projects' summaries instead, so it costs
+	// one request per included project however many others there are.
+	// Otherwise the time of excluded projects is subtracted, taken from their
+	// summaries. Without these the breakdowns would be wrong, so the day fails
+	// instead.
+	var fetched map[string][]wakatime.SummaryDay
+	switch {
+	case len(s.cfg.IncludeProjects) > 0:
+		if fetched, err = s.fetchProjectSummaries(ctx, day, included); err != nil {
+			return 0, nil, nil, err
+		}
+		stats = append(projectStats(stats), breakdownStats(day, sumBreakdowns(fetched))...)
+	case len(excluded) > 0:
+		excludedSummaries, err := s.fetchProjectSummaries(ctx, day, excluded)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		stats = subtractStats(stats, sumBreakdowns(excludedSummaries))
+	}
+
+	// Branches and entities are only returned for single-project queries
+	if s.cfg.SyncProjectBreakdowns {
+		stats = append(stats, s.syncProjectBreakdowns(ctx, day, included, names, fetched)...)
+	}
+
+	return totalSeconds, missing, func(tx *database.Tx) error {
+		// Save grand total
+		if err := tx.UpsertDaySummary(day, totalSeconds); err != nil {
+			return err
+		}
+		if err := tx.UpdateDaySummaryLineChanges(day,
+			grandTotal.AIAdditions, grandTotal.AIDeletions, grandTotal.HumanAdditions, grandTotal.HumanDeletions); err != nil {
+			return err
+		}
+
+		// Replace the stats of this day
+		if err := tx.DeleteDayStatsByDay(day); err != nil {
+			return err
+		}
+		if len(stats) > 0 {
+			if err := tx.InsertDayStats(stats); err != nil {
+				return err
+			}
+		}
+
+		slog.Info("synced summary", "date", day.Format("2006-01-02"), "total_seconds", totalSeconds, "stats_count", len(stats))
+		return nil
+	}, nil
+}
+
+// missingBreakdowns returns the breakdowns that are empty in a summary with
+// coding time. WakaTime leaves them out e.g. for days beyond the history of
+// free accounts, returning only the total.
+func missingBreakdowns(summary wakatime.SummaryDay) []string {
+	if summary.GrandTotal.TotalSeconds <= 0 {
+		return nil
+	}
+	var missing []string
+	for _, b := range []struct {
+		name  string
+		items []wakatime.SummaryItem
+	}{
+		{"categories", summary.Categories},
+		{"languages", summary.Languages},
+		{"editors", summary.Editors},
+		{"operating systems", summary.OperatingSystems},
+		{"projects", summary.Projects},
+	} {
+		if len(b.items) == 0 {
+			missing = append(missing, b.name)
+		}
+	}
+	return missing
+}
+
+// syncProjectBreakdowns fetches the branches and entities breakdowns of a day,
+// which WakaTime only includes when summaries are queried for a single
+// project, so it costs one request per project. They are stored per project,
+// with aliases merged into their canonical project, as e.g. "main" is a
+// different branch in every project. Summaries already fetched are passed in
+// fetched by project name. Failed projects are logged and skipped, so the
+// breakdowns may be incomplete or empty.
+func (s *Syncer) syncProjectBreakdowns(ctx context.Context, day time.Time, projects []wakatime.SummaryItem, names projectNames, fetched map[string][]wakatime.SummaryDay) []database.DayStats {
+	type key struct{ statType, project, name string }
+	index := make(map[key]int)
+	var stats []database.DayStats
+
+	for _, project := range projects {
+		days, ok := fetched[project.Name]
+		if !ok {
+			resp, err := s.client.GetSummariesWithProject(ctx, day, day, project.Name)
+			if err != nil {
+				slog.Error("failed to get project summary", "date", day.Format("2006-01-02"), "project", project.Name, "error", err)
+				continue
+			}
+			days = resp.Data
+		}
+		canonical := names.canonical(project.Name)
+		add := func(statType string, items []wakatime.SummaryItem) {
+			for _, item := range items {
+				if item.Name == "" {
+					continue
+				}
+				k := key{statType, canonical, item.Name}
+				if i, ok := index[k]; ok {
+					stats[i].TotalSeconds += item.TotalSeconds
+					continue
+				}
+				index[k] = len(stats)
+				stats = append(stats, database.DayStats{Day: day, Type: statType, Project: canonical, Name: item.Name, TotalSeconds: item.TotalSeconds})
+			}
+		}
+		for _, d := range days {
+			add("branch", d.Branches)
+			add("entity", d.Entities)
 		}
 	}
+	return stats
+}
 
-	slog.Info("synced summary", "date", day.Format("2006-01-02"), "total_seconds", totalSeconds, "stats_count", len(stats))
-	return totalSeconds, nil
+// fetchProjectSummaries fetches the single-project summaries of a day by
+// project name. It costs one request per project, and stops at the first that
+// fails.
+func (s *Syncer) fetchProjectSummaries(ctx context.Context, day time.Time, projects []wakatime.SummaryItem) (map[string][]wakatime.SummaryDay, error) {
+	summaries := make(map[string][]wakatime.SummaryDay, len(projects))
+	for _, project := range projects {
+		resp, err := s.client.GetSummariesWithProject(ctx, day, day, project.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get summary of project %q: %w", project.Name, err)
+		}
+		summaries[project.Name] = resp.Data
+	}
+	return summaries, nil
+}
+
+// breakdownTypes are the stat types summed by sumBreakdowns
+var breakdownTypes = []string{"category", "language", "editor", "os", "dependency", "machine"}
+
+// sumBreakdowns returns the seconds per stat type and name of single-project
+// summaries, for the types in breakdownTypes
+func sumBreakdowns(summaries map[string][]wakatime.SummaryDay) map[string]map[string]float64 {
+	totals := make(map[string]map[string]float64)
+	add := func(statType, name string, seconds float64) {
+		if totals[statType] == nil {
+			totals[statType] = make(map[string]float64)
+		}
+		totals[statType][name] += seconds
+	}
+
+	for _, days := range summaries {
+		for _, d := range days {
+			for _, b := range []struct {
+				statType string
+				items    []wakatime.SummaryItem
+			}{
+				{"category", d.Categories},
+				{"language", d.Languages},
+				{"editor", d.Editors},
+				{"os", d.OperatingSystems},
+				{"dependency", d.Dependencies},
+			} {
+				for _, item := range b.items {
+					add(b.statType, item.Name, item.TotalSeconds)
+				}
+			}
+			for _, item := range d.Machines {
+				name := item.MachineNameID
+				if name == "" {
+					name = item.Name
+				}
+				add("machine", name, item.TotalSeconds)
+			}
+		}
+	}
+	return totals
+}
+
+// breakdownStats turns the seconds per stat type and name from sumBreakdowns
+// into the stats of a day
+func breakdownStats(day time.Time, totals map[string]map[string]float64) []database.DayStats {
+	var stats []database.DayStats
+	for _, statType := range breakdownTypes {
+		names := make([]string, 0, len(totals[statType]))
+		for name := range totals[statType] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			stats = append(stats, database.DayStats{Day: day, Type: statType, Name: name, TotalSeconds: totals[statType][name]})
+		}
+	}
+	return stats
+}
+
+// projectStats returns the stats of type project
+func projectStats(stats []database.DayStats) []database.DayStats {
+	var projects []database.DayStats
+	for _, stat := range stats {
+		if stat.Type == "project" {
+			projects = append(projects, stat)
+		}
+	}
+	return projects
+}
+
+// subtractStats subtracts seconds per stat type and name from stats, dropping
+// the stats with less than a second left
+func subtractStats(stats []database.DayStats, seconds map[string]map[string]float64) []database.DayStats {
+	var kept []database.DayStats
+	for _, stat := range stats {
+		if sub, ok := seconds[stat.Type][stat.Name]; ok {
+			stat.TotalSeconds -= sub
+			if stat.TotalSeconds < 1 {
+				continue
+			}
+		}
+		kept = append(kept, stat)
+	}
+	return kept
 }
 
-func (s *Syncer) syncDurations(day time.Time) error {
-	resp, err := s.client.GetDurations(day)
+// syncDurations fetches the day's durations and returns how many WakaTime has
+// and the writes to store them, nil if they are already up to date
+func (s *Syncer) syncDurations(ctx context.Context, day time.Time, force bool) (int, dayWrite, error) {
+	resp, err := s.client.GetDurations(ctx, day)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
 	if len(resp.Data) == 0 {
 		slog.Info("no duration data for day", "date", day.Format("2006-01-02"))
-		return nil
+		return 0, nil, nil
 	}
 
-	// Check if we already have the same number of durations
-	existingCount, err := s.db.CountDurationsByDay(day)
+	// Durations of excluded projects are not stored
+	names := s.loadProjectNames()
+	var data []wakatime.DurationData
+	for _, d := range resp.Data {
+		if !s.excluded(d.Project, names) {
+			data = append(data, d)
+		}
+	}
+
+	// Skip if the response is identical to the last fully synced one. Comparing
+	// row counts is not enough: durations can be merged or split upstream.
+	hash, err := contentHash(data)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
-	if existingCount >= len(resp.Data) {
-		slog.Info("durations already up to date", "date", day.Format("2006-01-02"))
-		return nil
+	existingHash, err := s.db.GetContentHash(day, "durations")
+	if err != nil {
+		return 0, nil, err
 	}
-
-	// Delete existing and insert new
-	if err := s.db.DeleteDurationsByDay(day); err != nil {
-		return err
+	if !force && existingHash == hash {
+		slog.Info("durations already up to date", "date", day.Format("2006-01-02"))
+		return len(data), nil, nil
 	}
 
 	var durations []database.Duration
-	for _, d := range resp.Data {
+	for _, d := range data {
 		durations = append(durations, database.Duration{
-			Day:          day,
-			Project:      d.Project,
-			StartTime:    d.Time,
-			Duration:     d.Duration,
-			Dependencies: dependenciesToString(d.Dependencies),
+			Day:            day,
+			Project:        names.canonical(d.Project),
+			StartTime:      d.Time,
+			Duration:       d.Duration,
+			Dependencies:   dependenciesToString(d.Dependencies),
+			AIAdditions:    d.AIAdditions,
+			AIDeletions:    d.AIDeletions,
+			HumanAdditions: d.HumanAdditions,
+			HumanDeletions: d.HumanDeletions,
 		})
 	}
 
-	if err := s.db.InsertDurations(durations); err != nil {
-		return err
-	}
-
 	// Also sync project-level durations for each project
 	projects := make(map[string]bool)
-	for _, d := range resp.Data {
+	for _, d := range data {
 		if d.Project != "" {
 			projects[d.Project] = true
 		}
 	}
 
+	complete := true
 	var projectDurations []database.ProjectDuration
 	for project := range projects {
-		projResp, err := s.client.GetDurationsWithProject(day, project)
+		projResp, err := s.client.GetDurationsWithProject(ctx, day, project, "entity")
 		if err != nil {
 			slog.Error("failed to get project durations", "project", project, "error", err)
+			complete = false
 			continue
 		}
 		for _, d := range projResp.Data {
 			projectDurations = append(projectDurations, database.ProjectDuration{
 				Day:          day,
-				Project:      project,
+				Project:      names.canonical(project),
 				Entity:       d.Entity,
 				Language:     d.Language,
 				Branch:       d.Branch,
@@ -325,54 +1249,92 @@ func (s *Syncer) syncDurations(day time.Time) error {
 		}
 	}
 
-	if len(projectDurations) > 0 {
-		if err := s.db.DeleteProjectDurationsByDay(day); err != nil {
+	return len(durations), func(tx *database.Tx) error {
+		// Delete existing and insert new
+		if err := tx.DeleteDurationsByDay(day); err != nil {
 			return err
 		}
-		if err := s.db.InsertProjectDurations(projectDurations); err != nil {
+		if err := tx.InsertDurations(durations); err != nil {
 			return err
 		}
-	}
 
-	slog.Info("synced durations", "date", day.Format("2006-01-02"), "count", len(durations), "project_count", len(projectDurations))
-	return nil
+		// When forced, also clear project durations that no longer exist upstream
+		if len(projectDurations) > 0 || (force && complete) {
+			if err := tx.DeleteProjectDurationsByDay(day); err != nil {
+				return err
+			}
+			if err := tx.InsertProjectDurations(projectDurations); err != nil {
+				return err
+			}
+		}
+
+		// Only remember the response if everything was fetched, so a partial
+		// sync is retried next time
+		if complete {
+			if err := tx.SetContentHash(day, "durations", hash); err != nil {
+				return err
+			}
+		}
+
+		slog.Info("synced durations", "date", day.Format("2006-01-02"), "count", len(durations), "project_count", len(projectDurations))
+		return nil
+	}, nil
 }
 
-func (s *Syncer) syncHeartbeats(day time.Time) error {
-	resp, err := s.client.GetHeartbeats(day)
+// syncHeartbeats fetches the day's heartbeats and returns how many WakaTime
+// has and the writes to store them, nil if they are already up to date
+func (s *Syncer) syncHeartbeats(ctx context.Context, day time.Time, force bool) (int, dayWrite, error) {
+	resp, err := s.client.GetHeartbeats(ctx, day)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 
 	if len(resp.Data) == 0 {
 		slog.Info("no heartbeat data for day", "date", day.Format("2006-01-02"))
-		return nil
+		return 0, nil, nil
 	}
 
-	// Check if we already have the same number of heartbeats
-	existingCount, err := s.db.CountHeartbeatsByDay(day)
+	names := s.loadProjectNames()
+	data := s.includedHeartbeats(resp.Data, names)
+
+	// Check if we already have the same number of heartbeats. Local ones are
+	// not from WakaTime, so they do not count.
+	existingCount, err := s.db.CountSyncedHeartbeatsByDay(day)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
-	if existingCount >= len(resp.Data) {
+	if !force && existingCount >= len(data) {
 		slog.Info("heartbeats already up to date", "date", day.Format("2006-01-02"))
-		return nil
+		return len(data), nil, nil
 	}
 
-	// Delete existing and insert new
-	if err := s.db.DeleteHeartbeatsByDay(day); err != nil {
-		return err
-	}
+	heartbeats := toHeartbeats(day, data, names)
 
+	return len(heartbeats), func(tx *database.Tx) error {
+		// Delete existing and insert new
+		if err := tx.DeleteHeartbeatsByDay(day); err != nil {
+			return err
+		}
+		if err := tx.InsertHeartbeats(heartbeats); err != nil {
+			return err
+		}
+
+		slog.Info("synced heartbeats", "date", day.Format("2006-01-02"), "count", len(heartbeats))
+		return nil
+	}, nil
+}
+
+// toHeartbeats converts WakaTime heartbeats of a day for storage
+func toHeartbeats(day time.Time, data []wakatime.HeartbeatData, names projectNames) []database.HeartBeat {
 	var heartbeats []database.HeartBeat
-	for _, h := range resp.Data {
+	for _, h := range data {
 		heartbeats = append(heartbeats, database.HeartBeat{
 			Day:       day,
 			Entity:    h.Entity,
 			Type:      h.Type,
 			Category:  h.Category,
 			Time:      h.Time,
-			Project:   h.Project,
+			Project:   names.canonical(h.Project),
 			Branch:    h.Branch,
 			Language:  h.Language,
 			IsWrite:   h.IsWrite,
@@ -382,22 +1344,99 @@ func (s *Syncer) syncHeartbeats(day time.Time) error {
 			CursorPos: h.CursorPos,
 		})
 	}
+	return heartbeats
+}
 
-	if err := s.db.InsertHeartbeats(heartbeats); err != nil {
-		return err
+// SyncToday appends today's heartbeats that are newer than the latest one
+// synced from WakaTime, for a near-live view before the day is fully synced
+// tomorrow
+func (s *Syncer) SyncToday() {
+	if !s.todayMu.TryLock() {
+		slog.Debug("skipping sync of today's heartbeats, the previous one is still running")
+		return
+	}
+	defer s.todayMu.Unlock()
+	s.runningMu.Lock()
+	if s.stopping.Err() != nil {
+		s.runningMu.Unlock()
+		return
 	}
+	s.running.Add(1)
+	s.runningMu.Unlock()
+	defer s.running.Done()
 
-	slog.Info("synced heartbeats", "date", day.Format("2006-01-02"), "count", len(heartbeats))
-	return nil
+	today := time.Now().In(s.cfg.GetTimezone())
+	dateStr := today.Format("2006-01-02")
+
+	// WakaTime only returns whole days of heartbeats, so they are only
+	// fetched once its total for today changed
+	status, err := s.client.GetStatusBarToday(s.ctx)
+	if err != nil {
+		slog.Debug("failed to get today's total, fetching heartbeats anyway", "error", err)
+		status = nil
+	} else if status.Data.Range.Date != dateStr {
+		status = nil
+	}
+	if status != nil && s.todayDate == dateStr && s.todayTotal == status.Data.GrandTotal.TotalSeconds {
+		return
+	}
+
+	cursor, err := s.db.GetLatestSyncedHeartbeatTime(today)
+	if err != nil {
+		slog.Error("failed to get latest heartbeat of today", "date", dateStr, "error", err)
+		return
+	}
+
+	resp, err := s.client.GetHeartbeats(s.ctx, today)
+	if err != nil {
+		slog.Error("failed to sync today's heartbeats", "date", dateStr, "error", err)
+		return
+	}
+	names := s.loadProjectNames()
+	var newer []wakatime.HeartbeatData
+	for _, h := range s.includedHeartbeats(resp.Data, names) {
+		if h.Time > cursor {
+			newer = append(newer, h)
+		}
+	}
+	if len(newer) > 0 {
+		if err := s.db.InsertHeartbeats(toHeartbeats(today, newer, names)); err != nil {
+			slog.Error("failed to store today's heartbeats", "date", dateStr, "error", err)
+			return
+		}
+		slog.Info("synced today's heartbeats", "date", dateStr, "count", len(newer))
+	}
+	if status != nil {
+		s.todayDate, s.todayTotal = dateStr, status.Data.GrandTotal.TotalSeconds
+	}
+}
+
+// runTodaySync syncs today's heartbeats every interval until Stop is called
+func (s *Syncer) runTodaySync(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopping.Done():
+			return
+		case <-ticker.C:
+			s.SyncToday()
+		}
+	}
 }
 
 func (s *Syncer) SyncProjects() error {
-	resp, err := s.client.GetProjects("")
+	resp, err := s.client.GetProjects(s.ctx, "")
 	if err != nil {
 		return err
 	}
 
+	names := s.loadProjectNames()
 	for _, p := range resp.Data {
+		if s.excluded(p.Name, names) {
+			continue
+		}
+
 		var lastHeartbeat, firstHeartbeat time.Time
 		if p.LastHeartbeatAt != "" {
 			lastHeartbeat, _ = time.Parse(time.RFC3339, p.LastHeartbeatAt)
@@ -423,3 +1462,86 @@ func (s *Syncer) SyncProjects() error {
 	slog.Info("synced projects", "count", len(resp.Data))
 	return nil
 }
+
+// SyncMachines stores the names of the machines heartbeats were sent from
+func (s *Syncer) SyncMachines() error {
+	resp, err := s.client.GetMachineNames(s.ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range resp.Data {
+		lastSeenAt, _ := time.Parse(time.RFC3339, m.LastSeenAt)
+		createdAt, _ := time.Parse(time.RFC3339, m.CreatedAt)
+		name := m.Value
+		if name == "" {
+			name = m.Name
+		}
+		if err := s.db.UpsertMachine(&database.Machine{
+			ID:         m.ID,
+			Name:       name,
+			IP:         m.IP,
+			Timezone:   m.Timezone,
+			LastSeenAt: lastSeenAt,
+			CreatedAt:  createdAt,
+		}); err != nil {
+			slog.Error("failed to upsert machine", "machine", name, "error", err)
+		}
+	}
+
+	slog.Info("synced machines", "count", len(resp.Data))
+	return s.db.RekeyMachineStats()
+}
+
+// SyncGoals replaces the stored goals with the ones currently defined on
+// WakaTime. Goals are not available on every plan; in that case the stored
+// goals are left untouched and no error is returned.
+func (s *Syncer) SyncGoals() error {
+	resp, err := s.client.GetGoals(s.ctx)
+	if err != nil {
+		var apiErr *wakatime.APIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusPaymentRequired ||
+			apiErr.StatusCode == http.StatusForbidden || apiErr.StatusCode == http.StatusNotFound) {
+			slog.Info("goals are not available for this wakatime account", "status", apiErr.StatusCode)
+			return nil
+		}
+		return err
+	}
+
+	goals := make([]database.WakaTimeGoal, 0, len(resp.Data))
+	for _, g := range resp.Data {
+		modifiedAt, _ := time.Parse(time.RFC3339, g.ModifiedAt)
+		createdAt, _ := time.Parse(time.RFC3339, g.CreatedAt)
+		chartData := string(g.ChartData)
+		if chartData == "" || chartData == "null" {
+			chartData = "[]"
+		}
+		goals = append(goals, database.WakaTimeGoal{
+			UUID:                    g.ID,
+			Title:                   g.Title,
+			Type:                    g.Type,
+			Delta:                   g.Delta,
+			Seconds:                 g.Seconds,
+			ImproveByPercent:        g.ImproveByPercent,
+			Status:                  g.Status,
+			StatusPercentCalculated: g.StatusPercentCalculated,
+			RangeText:               g.RangeText,
+			IsEnabled:               g.IsEnabled,
+			IsSnoozed:               g.IsSnoozed,
+			IsInverse:               g.IsInverse,
+			Languages:               goalFilterToString(g.Languages),
+			Projects:                goalFilterToString(g.Projects),
+			Editors:                 goalFilterToString(g.Editors),
+			ChartData:               chartData,
+			ModifiedAt:              modifiedAt,
+			CreatedAt:               createdAt,
+		})
+	}
+
+	if err := s.db.ReplaceWakaTimeGoals(goals); err != nil {
+		return err
+	}
+
+	slog.Info("synced goals", "count", len(goals))
+	return nil
+}
@@ -0,0 +1,366 @@
+package sync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/config"
+	"github.com/charlie0129/wakatime-sync-go/internal/database"
+	"github.com/charlie0129/wakatime-sync-go/internal/wakatime"
+)
+
+// newTestSyncer returns a syncer with a temporary database, talking to a fake
+// WakaTime API served by handler
+func newTestSyncer(t *testing.T, handler http.HandlerFunc) *Syncer {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), database.Options{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg := &config.Config{
+		WakaTimeAPI:             "test",
+		WakaTimeBaseURL:         server.URL,
+		Timezone:                "UTC",
+		StartDate:               "2016-01-01",
+		MaxEventSubscribers:     1,
+		HeartbeatTimeoutMinutes: config.DefaultHeartbeatTimeoutMinutes,
+	}
+	return NewSyncer(cfg, db)
+}
+
+// heartbeatsHandler answers every heartbeats request with the same heartbeats
+func heartbeatsHandler(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if filepath.Base(r.URL.Path) == "heartbeats" {
+			w.Write([]byte(body))
+			return
+		}
+		w.Write([]byte(`{"data": []}`))
+	}
+}
+
+func countHeartbeats(t *testing.T, s *Syncer, day time.Time) int {
+	t.Helper()
+	n, err := s.db.CountHeartbeatsByDay(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSyncingHeartbeatsTwiceKeepsRowCount(t *testing.T) {
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	base := day.Add(10 * time.Hour).Unix()
+	body := `{"data": [
+		{"entity": "/src/a.go", "type": "file", "time": ` + strconv.FormatInt(base, 10) + `, "project": "p", "machine_name_id": "m1"},
+		{"entity": "/src/a.go", "type": "file", "time": ` + strconv.FormatInt(base+60, 10) + `, "project": "p", "machine_name_id": "m1"},
+		{"entity": "/src/b.go", "type": "file", "time": ` + strconv.FormatInt(base+120, 10) + `, "project": "p", "machine_name_id": "m1"}
+	]}`
+	s := newTestSyncer(t, heartbeatsHandler(body))
+
+	for i, force := range []bool{false, false, true} {
+		_, write, err := s.syncHeartbeats(context.Background(), day, force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if write != nil {
+			tx, err := s.db.BeginTx()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := write(tx); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := countHeartbeats(t, s, day); n != 3 {
+			t.Fatalf("sync %d: %d heartbeats stored, want 3", i+1, n)
+		}
+	}
+
+	// Inserting an overlapping response without deleting the day first, as the
+	// incremental sync of today does, skips the heartbeats already stored
+	resp, err := s.client.GetHeartbeats(context.Background(), day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.InsertHeartbeats(toHeartbeats(day, resp.Data, projectNames{})); err != nil {
+		t.Fatal(err)
+	}
+	if n := countHeartbeats(t, s, day); n != 3 {
+		t.Fatalf("after inserting the same heartbeats again: %d heartbeats stored, want 3", n)
+	}
+}
+
+// summariesHandler answers summaries requests with the summary of the
+// requested project, or of the whole day if none is requested
+func summariesHandler(byProject map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if filepath.Base(r.URL.Path) == "summaries" {
+			w.Write([]byte(`{"data": [` + byProject[r.URL.Query().Get("project")] + `]}`))
+			return
+		}
+		w.Write([]byte(`{"data": []}`))
+	}
+}
+
+func TestDeletedProjectIsNotSyncedAgain(t *testing.T) {
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	s := newTestSyncer(t, summariesHandler(map[string]string{
+		"": `{"grand_total": {"total_seconds": 2400},
+			"projects": [{"name": "old", "total_seconds": 600}, {"name": "kept", "total_seconds": 1800}],
+			"languages": [{"name": "Go", "total_seconds": 1800}, {"name": "Python", "total_seconds": 600}]}`,
+		"old":  `{"grand_total": {"total_seconds": 600}, "languages": [{"name": "Go", "total_seconds": 600}]}`,
+		"kept": `{"grand_total": {"total_seconds": 1800}, "languages": [{"name": "Go", "total_seconds": 1200}, {"name": "Python", "total_seconds": 600}]}`,
+	}))
+	if err := s.SyncDay(day, false); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := s.DeleteProject("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted.Dates) != 1 || !deleted.Dates[0].Equal(day) {
+		t.Fatalf("deleted dates = %v, want [%s]", deleted.Dates, day.Format("2006-01-02"))
+	}
+	// The days of the project are re-synced in the background
+	s.running.Wait()
+
+	summary, err := s.db.GetDaySummary(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary == nil || summary.TotalSeconds != 1800 {
+		t.Errorf("day summary = %+v, want 1800 seconds", summary)
+	}
+	for statType, want := range map[string]map[string]float64{
+		"project":  {"kept": 1800},
+		"language": {"Go": 1200, "Python": 600},
+	} {
+		stats, err := s.db.GetDayStatsByDayAndType(day, statType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]float64)
+		for _, st := range stats {
+			got[st.Name] = st.TotalSeconds
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s stats = %v, want %v", statType, got, want)
+		}
+	}
+
+	// Forced syncs leave it out too
+	if err := s.SyncDay(day, true); err != nil {
+		t.Fatal(err)
+	}
+	if summary, _ := s.db.GetDaySummary(day); summary == nil || summary.TotalSeconds != 1800 {
+		t.Errorf("day summary after a forced sync = %+v, want 1800 seconds", summary)
+	}
+}
+
+func TestProjectBreakdownsAreKeptPerProject(t *testing.T) {
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	var projectRequests int
+	summaries := summariesHandler(map[string]string{
+		"":  `{"grand_total": {"total_seconds": 1800}, "projects": [{"name": "a", "total_seconds": 600}, {"name": "b", "total_seconds": 1200}]}`,
+		"a": `{"grand_total": {"total_seconds": 600}, "branches": [{"name": "main", "total_seconds": 600}]}`,
+		"b": `{"grand_total": {"total_seconds": 1200}, "branches": [{"name": "main", "total_seconds": 1200}]}`,
+	})
+	s := newTestSyncer(t, func(w http.ResponseWriter, r *http.Request) {
+		if filepath.Base(r.URL.Path) == "summaries" && r.URL.Query().Get("project") != "" {
+			projectRequests++
+		}
+		summaries(w, r)
+	})
+
+	if err := s.SyncDay(day, false); err != nil {
+		t.Fatal(err)
+	}
+	if projectRequests != 0 {
+		t.Errorf("%d per-project requests without sync_project_breakdowns, want 0", projectRequests)
+	}
+
+	s.cfg.SyncProjectBreakdowns = true
+	if err := s.SyncDay(day, true); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := s.db.GetDayStatsByDayAndType(day, "branch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, st := range stats {
+		got[st.Project+"/"+st.Name] = st.TotalSeconds
+	}
+	if want := map[string]float64{"a/main": 600, "b/main": 1200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("branch stats = %v, want %v", got, want)
+	}
+}
+
+func TestExcludedProjectSummaryFailureFailsDay(t *testing.T) {
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	summaries := summariesHandler(map[string]string{
+		"": `{"grand_total": {"total_seconds": 2400},
+			"projects": [{"name": "scratch", "total_seconds": 600}, {"name": "kept", "total_seconds": 1800}],
+			"languages": [{"name": "Go", "total_seconds": 2400}]}`,
+	})
+	s := newTestSyncer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("project") == "scratch" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		summaries(w, r)
+	})
+	s.cfg.ExcludeProjects = []string{"scratch"}
+
+	if err := s.SyncDay(day, false); err == nil {
+		t.Fatal("sync succeeded without the excluded project's summary")
+	}
+	if summary, _ := s.db.GetDaySummary(day); summary != nil {
+		t.Errorf("day summary = %+v, want none", summary)
+	}
+}
+
+func TestIncludedProjectsBreakdowns(t *testing.T) {
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	requested := make(map[string]int)
+	summaries := summariesHandler(map[string]string{
+		"": `{"grand_total": {"total_seconds": 1800},
+			"projects": [{"name": "work", "total_seconds": 600}, {"name": "hobby", "total_seconds": 1200}],
+			"languages": [{"name": "Go", "total_seconds": 600}, {"name": "Python", "total_seconds": 1200}]}`,
+		"work": `{"grand_total": {"total_seconds": 600}, "languages": [{"name": "Go", "total_seconds": 600}],
+			"branches": [{"name": "main", "total_seconds": 600}]}`,
+	})
+	s := newTestSyncer(t, func(w http.ResponseWriter, r *http.Request) {
+		if filepath.Base(r.URL.Path) == "summaries" {
+			requested[r.URL.Query().Get("project")]++
+		}
+		summaries(w, r)
+	})
+	s.cfg.IncludeProjects = []string{"work"}
+	s.cfg.SyncProjectBreakdowns = true
+
+	if err := s.SyncDay(day, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"": 1, "work": 1}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("summary requests by project = %v, want %v", requested, want)
+	}
+	for statType, want := range map[string]map[string]float64{
+		"project":  {"work": 600},
+		"language": {"Go": 600},
+		"branch":   {"main": 600},
+	} {
+		stats, err := s.db.GetDayStatsByDayAndType(day, statType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]float64)
+		for _, st := range stats {
+			got[st.Name] = st.TotalSeconds
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s stats = %v, want %v", statType, got, want)
+		}
+	}
+}
+
+func TestSyncTodayRunsBesideOtherSyncs(t *testing.T) {
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+	var heartbeatRequests int
+	s := newTestSyncer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch filepath.Base(r.URL.Path) {
+		case "today":
+			w.Write([]byte(`{"data": {"grand_total": {"total_seconds": 600}, "range": {"date": "` + today + `"}}}`))
+		case "heartbeats":
+			heartbeatRequests++
+			w.Write([]byte(`{"data": [{"entity": "/src/a.go", "type": "file", "time": ` +
+				strconv.FormatInt(now.Unix(), 10) + `, "project": "p", "machine_name_id": "m1"}]}`))
+		default:
+			w.Write([]byte(`{"data": []}`))
+		}
+	})
+
+	release, ok := s.tryBegin()
+	if !ok {
+		t.Fatal("failed to begin a sync")
+	}
+	defer release()
+
+	s.SyncToday()
+	if n := countHeartbeats(t, s, now); n != 1 {
+		t.Fatalf("%d heartbeats stored during another sync, want 1", n)
+	}
+	// The total did not change, so the heartbeats are not downloaded again
+	s.SyncToday()
+	if heartbeatRequests != 1 {
+		t.Errorf("%d heartbeats requests, want 1", heartbeatRequests)
+	}
+}
+
+func TestIngestDuringSyncIsRecomputedAfterIt(t *testing.T) {
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	s := newTestSyncer(t, heartbeatsHandler(`{"data": []}`))
+	base := float64(day.Add(10 * time.Hour).Unix())
+
+	release, ok := s.tryBegin()
+	if !ok {
+		t.Fatal("failed to begin a sync")
+	}
+	if _, err := s.IngestHeartbeats([]wakatime.HeartbeatData{
+		{Entity: "/src/a.go", Type: "file", Time: base, Project: "p"},
+		{Entity: "/src/a.go", Type: "file", Time: base + 60, Project: "p"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if summary, _ := s.db.GetDaySummary(day); summary != nil {
+		t.Fatalf("day summary = %+v during a sync, want none", summary)
+	}
+
+	// The day is recomputed once the sync is done
+	release()
+	s.running.Wait()
+	if summary, _ := s.db.GetDaySummary(day); summary == nil || summary.TotalSeconds != 60 {
+		t.Errorf("day summary = %+v after the sync, want 60 seconds", summary)
+	}
+}
+
+func TestCloseEventsEndsSubscriptions(t *testing.T) {
+	s := newTestSyncer(t, heartbeatsHandler(`{"data": []}`))
+	events, unsubscribe, err := s.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	s.CloseEvents()
+	if _, ok := <-events; ok {
+		t.Error("received an event after CloseEvents, want the channel closed")
+	}
+	later, _, err := s.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-later; ok {
+		t.Error("received an event on a subscription after CloseEvents, want the channel closed")
+	}
+}
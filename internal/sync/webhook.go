@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/version"
+)
+
+const (
+	webhookAttempts  = 3
+	webhookBaseDelay = 2 * time.Second
+	webhookTimeout   = 10 * time.Second
+
+	// WebhookSignatureHeader carries the hex HMAC-SHA256 of the request body,
+	// keyed with webhook_secret, as "sha256=<hex>"
+	WebhookSignatureHeader = "X-Signature-256"
+)
+
+// WebhookPayload is POSTed to webhook_url after every synced day
+type WebhookPayload struct {
+	Date         string    `json:"date"`
+	Status       string    `json:"status"` // success, partial or failed
+	TotalSeconds float64   `json:"total_seconds"`
+	Durations    int       `json:"durations"`  // number of durations WakaTime has for the day
+	Heartbeats   int       `json:"heartbeats"` // number of heartbeats WakaTime has for the day
+	Error        string    `json:"error,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// notifyWebhook delivers the payload in the background so a slow or failing
+// receiver never delays or fails the sync itself
+func (s *Syncer) notifyWebhook(payload WebhookPayload) {
+	if s.cfg.WebhookURL == "" {
+		return
+	}
+	go func() {
+		if err := s.deliverWebhook(payload); err != nil {
+			slog.Error("failed to deliver webhook", "date", payload.Date, "error", err)
+		}
+	}()
+}
+
+func (s *Syncer) deliverWebhook(payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	for attempt := 0; ; attempt++ {
+		err = s.postWebhook(client, body)
+		if err == nil {
+			return nil
+		}
+		if attempt+1 >= webhookAttempts {
+			return err
+		}
+
+		delay := webhookBaseDelay << attempt
+		slog.Warn("webhook delivery failed, retrying", "date", payload.Date, "attempt", attempt+1, "delay", delay.String(), "error", err)
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *Syncer) postWebhook(client *http.Client, body []byte) error {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+	if s.cfg.WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(s.cfg.WebhookSecret))
+		mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
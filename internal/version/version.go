@@ -0,0 +1,31 @@
+package version
+
+import "runtime/debug"
+
+// Version is the version of this build, set at build time with
+//
+//	go build -ldflags "-X github.com/charlie0129/wakatime-sync-go/internal/version.Version=v1.2.3"
+var Version = "dev"
+
+// Commit is the git commit of this build. It is set like Version, or read
+// from the build info Go embeds when building from a git checkout.
+var Commit = ""
+
+func init() {
+	if Commit != "" {
+		return
+	}
+	Commit = "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				Commit = s.Value
+			}
+		}
+	}
+}
+
+// UserAgent is sent with all outgoing requests
+func UserAgent() string {
+	return "wakatime-sync-go/" + Version
+}
@@ -1,21 +1,96 @@
 package wakatime
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/charlie0129/wakatime-sync-go/internal/version"
 )
 
 const BaseURL = "https://wakatime.com/api/v1"
 
+const (
+	// DefaultMaxRetries is how many times a request is retried on 429 or 5xx responses
+	DefaultMaxRetries = 3
+
+	retryBaseDelay = 2 * time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+// Errors that requests may fail with, to be checked with errors.Is. The
+// returned errors are an *APIError or *RateLimitError with more details.
+var (
+	// ErrUnauthorized means WakaTime rejected the API key (401 or 403)
+	ErrUnauthorized = errors.New("wakatime rejected the api key")
+	// ErrNotFound means the endpoint does not exist (404), e.g. because of a
+	// wrong base URL
+	ErrNotFound = errors.New("wakatime api endpoint not found")
+	// ErrRateLimited means WakaTime kept answering 429 Too Many Requests
+	ErrRateLimited = errors.New("wakatime api rate limited")
+)
+
+// APIError is returned when WakaTime responds with an unexpected status code
+type APIError struct {
+	StatusCode int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("wakatime api returned status %d", e.StatusCode)
+}
+
+// Is matches the sentinel error for the status code
+func (e *APIError) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == ErrUnauthorized
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	}
+	return false
+}
+
+// RateLimitError is returned when WakaTime keeps responding with 429 Too Many
+// Requests after all retries are exhausted.
+type RateLimitError struct {
+	// RetryAfter is the wait the server asked for in its last response, if any
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("wakatime api rate limited, retry after %s", e.RetryAfter)
+	}
+	return "wakatime api rate limited"
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
 type Client struct {
 	apiKey     string
 	baseURL    string
 	httpClient *http.Client
+	maxRetries int
+
+	// failedResponseDir, when set, is where raw response bodies that fail to
+	// unmarshal are written for debugging.
+	failedResponseDir string
 }
 
 func NewClient(apiKey string, proxyURL string) *Client {
@@ -36,16 +111,85 @@ func NewClientWithBaseURL(apiKey string, proxyURL string, baseURL string) *Clien
 	}
 
 	return &Client{
-		apiKey:  apiKey,
+		apiKey:  encodeAPIKey(apiKey),
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Transport: transport,
 			Timeout:   30 * time.Second,
 		},
+		maxRetries: DefaultMaxRetries,
 	}
 }
 
-func (c *Client) doRequest(endpoint string, params map[string]string) ([]byte, error) {
+// encodeAPIKey returns the API key base64-encoded as WakaTime expects in the
+// Basic Authorization header. Keys that are already encoded are returned as-is,
+// so both the plain key from https://wakatime.com/settings/api-key and a
+// pre-encoded one work.
+func encodeAPIKey(apiKey string) string {
+	if apiKey == "" || looksLikePlainAPIKey(apiKey) {
+		return base64.StdEncoding.EncodeToString([]byte(apiKey))
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(apiKey); err == nil && looksLikePlainAPIKey(string(decoded)) {
+		return apiKey
+	}
+	return base64.StdEncoding.EncodeToString([]byte(apiKey))
+}
+
+// looksLikePlainAPIKey reports whether key has the shape of a raw WakaTime API
+// key: "waka_" followed by a UUID, or a bare UUID for older keys.
+func looksLikePlainAPIKey(key string) bool {
+	return apiKeyPattern.MatchString(key)
+}
+
+var apiKeyPattern = regexp.MustCompile(`^(waka_)?[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// SetMaxRetries sets how many times a request is retried on 429 or 5xx
+// responses. Zero disables retries.
+func (c *Client) SetMaxRetries(n int) {
+	if n < 0 {
+		n = 0
+	}
+	c.maxRetries = n
+}
+
+// SaveFailedResponses enables writing raw response bodies that cannot be
+// decoded into dir. An empty dir disables it.
+func (c *Client) SaveFailedResponses(dir string) {
+	c.failedResponseDir = dir
+}
+
+// decode unmarshals body into v. If that fails and saving failed responses is
+// enabled, the raw body is written to a file named after the endpoint and date.
+func (c *Client) decode(endpoint string, params map[string]string, body []byte, v interface{}) error {
+	err := json.Unmarshal(body, v)
+	if err == nil || c.failedResponseDir == "" {
+		return err
+	}
+
+	date := params["date"]
+	if date == "" {
+		date = params["start"]
+	}
+	if date == "" {
+		date = "nodate"
+	}
+	name := strings.Trim(strings.ReplaceAll(endpoint, "/", "_"), "_")
+	filename := fmt.Sprintf("%s_%s_%d.json", name, date, time.Now().Unix())
+
+	if mkErr := os.MkdirAll(c.failedResponseDir, 0o755); mkErr != nil {
+		slog.Error("failed to create failed response dir", "dir", c.failedResponseDir, "error", mkErr)
+		return err
+	}
+	path := filepath.Join(c.failedResponseDir, filename)
+	if wErr := os.WriteFile(path, body, 0o644); wErr != nil {
+		slog.Error("failed to save failed response", "path", path, "error", wErr)
+		return err
+	}
+	slog.Warn("saved undecodable wakatime response", "endpoint", endpoint, "path", path, "error", err)
+	return err
+}
+
+func (c *Client) doRequest(ctx context.Context, endpoint string, params map[string]string) ([]byte, error) {
 	reqURL, err := url.Parse(c.baseURL + endpoint)
 	if err != nil {
 		return nil, err
@@ -57,31 +201,81 @@ func (c *Client) doRequest(endpoint string, params map[string]string) ([]byte, e
 	}
 	reqURL.RawQuery = q.Encode()
 
-	req, err := http.NewRequest(http.MethodGet, reqURL.String(), nil)
+	for attempt := 0; ; attempt++ {
+		status, header, body, err := c.do(ctx, reqURL.String())
+		if err != nil {
+			return nil, err
+		}
+
+		if status == http.StatusOK {
+			return body, nil
+		}
+
+		retryable := status == http.StatusTooManyRequests || status >= 500
+		if !retryable || attempt >= c.maxRetries {
+			slog.Error("wakatime api error", "status", status, "body", string(body))
+			if status == http.StatusTooManyRequests {
+				return nil, &RateLimitError{RetryAfter: parseRetryAfter(header.Get("Retry-After"))}
+			}
+			return nil, &APIError{StatusCode: status}
+		}
+
+		// Exponential backoff, but never retry sooner than the server asked us to
+		wait := retryBaseDelay << attempt
+		if retryAfter := parseRetryAfter(header.Get("Retry-After")); retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > retryMaxDelay {
+			wait = retryMaxDelay
+		}
+		slog.Warn("wakatime api request failed, retrying", "endpoint", endpoint, "status", status, "attempt", attempt+1, "wait", wait.String())
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// do performs a single GET request and returns the status, headers and body
+func (c *Client) do(ctx context.Context, reqURL string) (int, http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
-		return nil, err
+		return 0, nil, nil, err
 	}
 
 	req.Header.Set("Authorization", "Basic "+c.apiKey)
 	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return 0, nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return 0, nil, nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		slog.Error("wakatime api error", "status", resp.StatusCode, "body", string(body))
-		return nil, fmt.Errorf("wakatime api returned status %d", resp.StatusCode)
-	}
+	return resp.StatusCode, resp.Header, body, nil
+}
 
-	return body, nil
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
 }
 
 // --- API Response Types ---
@@ -106,6 +300,12 @@ type DurationData struct {
 	AIDeletions    int      `json:"ai_deletions,omitempty"`
 	HumanAdditions int      `json:"human_additions,omitempty"`
 	HumanDeletions int      `json:"human_deletions,omitempty"`
+
+	// Set when sliced by category, editor, os or machine
+	Category string `json:"category,omitempty"`
+	Editor   string `json:"editor,omitempty"`
+	OS       string `json:"os,omitempty"`
+	Machine  string `json:"machine_name_id,omitempty"`
 }
 
 type HeartbeatResponse struct {
@@ -113,6 +313,11 @@ type HeartbeatResponse struct {
 	Start    string          `json:"start"`
 	End      string          `json:"end"`
 	Timezone string          `json:"timezone"`
+
+	// Set when a busy day is split into pages
+	Page       int  `json:"page,omitempty"`
+	TotalPages int  `json:"total_pages,omitempty"`
+	NextPage   *int `json:"next_page,omitempty"`
 }
 
 type HeartbeatData struct {
@@ -238,6 +443,16 @@ type DailyAverage struct {
 	TextIncludingOtherLanguage    string  `json:"text_including_other_language"`
 }
 
+// StatusBarResponse is today's total so far, as shown in editor status bars
+type StatusBarResponse struct {
+	Data struct {
+		GrandTotal GrandTotal `json:"grand_total"`
+		Range      struct {
+			Date string `json:"date"`
+		} `json:"range"`
+	} `json:"data"`
+}
+
 type UserResponse struct {
 	Data UserData `json:"data"`
 }
@@ -258,100 +473,252 @@ type UserData struct {
 	HasPremiumFeatures bool   `json:"has_premium_features"`
 }
 
+type GoalsResponse struct {
+	Data       []GoalData `json:"data"`
+	Total      int        `json:"total"`
+	TotalPages int        `json:"total_pages"`
+}
+
+type GoalData struct {
+	ID                      string          `json:"id"`
+	Title                   string          `json:"title"`
+	Type                    string          `json:"type"`
+	Delta                   string          `json:"delta"` // day or week
+	Seconds                 float64         `json:"seconds"`
+	ImproveByPercent        float64         `json:"improve_by_percent"`
+	Status                  string          `json:"status"`
+	StatusPercentCalculated float64         `json:"status_percent_calculated"`
+	RangeText               string          `json:"range_text"`
+	IsEnabled               bool            `json:"is_enabled"`
+	IsSnoozed               bool            `json:"is_snoozed"`
+	IsInverse               bool            `json:"is_inverse"`
+	Languages               []string        `json:"languages"`
+	Projects                []string        `json:"projects"`
+	Editors                 []string        `json:"editors"`
+	ChartData               json.RawMessage `json:"chart_data"`
+	ModifiedAt              string          `json:"modified_at"`
+	CreatedAt               string          `json:"created_at"`
+}
+
+type MachineNamesResponse struct {
+	Data []MachineNameData `json:"data"`
+}
+
+type MachineNameData struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	IP         string `json:"ip"`
+	Timezone   string `json:"timezone"`
+	LastSeenAt string `json:"last_seen_at"`
+	CreatedAt  string `json:"created_at"`
+}
+
 // --- API Methods ---
 
-func (c *Client) GetDurations(date time.Time) (*DurationResponse, error) {
+func (c *Client) GetDurations(ctx context.Context, date time.Time) (*DurationResponse, error) {
 	params := map[string]string{
 		"date": date.Format("2006-01-02"),
 	}
-	body, err := c.doRequest("/users/current/durations", params)
+	body, err := c.doRequest(ctx, "/users/current/durations", params)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp DurationResponse
-	if err := json.Unmarshal(body, &resp); err != nil {
+	if err := c.decode("/users/current/durations", params, body, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
 
-func (c *Client) GetDurationsWithProject(date time.Time, project string) (*DurationResponse, error) {
+// DurationSliceBy lists the values WakaTime accepts for slice_by on durations
+var DurationSliceBy = []string{"entity", "language", "dependencies", "os", "editor", "category", "machine"}
+
+// ValidDurationSliceBy reports whether sliceBy is accepted by WakaTime
+func ValidDurationSliceBy(sliceBy string) bool {
+	for _, v := range DurationSliceBy {
+		if v == sliceBy {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDurationsWithProject fetches a day's durations of a project (all projects
+// if empty), sliced by sliceBy (entity if empty)
+func (c *Client) GetDurationsWithProject(ctx context.Context, date time.Time, project, sliceBy string) (*DurationResponse, error) {
+	if sliceBy == "" {
+		sliceBy = "entity"
+	}
 	params := map[string]string{
 		"date":     date.Format("2006-01-02"),
-		"project":  project,
-		"slice_by": "entity",
+		"slice_by": sliceBy,
+	}
+	if project != "" {
+		params["project"] = project
 	}
-	body, err := c.doRequest("/users/current/durations", params)
+	body, err := c.doRequest(ctx, "/users/current/durations", params)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp DurationResponse
-	if err := json.Unmarshal(body, &resp); err != nil {
+	if err := c.decode("/users/current/durations", params, body, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
 
-func (c *Client) GetHeartbeats(date time.Time) (*HeartbeatResponse, error) {
-	params := map[string]string{
-		"date": date.Format("2006-01-02"),
-	}
-	body, err := c.doRequest("/users/current/heartbeats", params)
-	if err != nil {
-		return nil, err
-	}
+// maxHeartbeatPages bounds the pages fetched for a day, in case a server
+// keeps returning a next page
+const maxHeartbeatPages = 1000
+
+// GetHeartbeats fetches a day's heartbeats. If the response is paginated, all
+// pages are fetched and their heartbeats concatenated.
+func (c *Client) GetHeartbeats(ctx context.Context, date time.Time) (*HeartbeatResponse, error) {
+	var all *HeartbeatResponse
+	for page := 1; ; page++ {
+		params := map[string]string{
+			"date": date.Format("2006-01-02"),
+		}
+		if page > 1 {
+			params["page"] = strconv.Itoa(page)
+		}
+		body, err := c.doRequest(ctx, "/users/current/heartbeats", params)
+		if err != nil {
+			return nil, err
+		}
 
-	var resp HeartbeatResponse
-	if err := json.Unmarshal(body, &resp); err != nil {
-		return nil, err
+		var resp HeartbeatResponse
+		if err := c.decode("/users/current/heartbeats", params, body, &resp); err != nil {
+			return nil, err
+		}
+		if all == nil {
+			all = &resp
+		} else {
+			all.Data = append(all.Data, resp.Data...)
+		}
+
+		hasNext := resp.NextPage != nil || page < resp.TotalPages
+		if !hasNext || len(resp.Data) == 0 {
+			break
+		}
+		if page >= maxHeartbeatPages {
+			return nil, fmt.Errorf("heartbeats of %s span more than %d pages", date.Format("2006-01-02"), maxHeartbeatPages)
+		}
 	}
-	return &resp, nil
+	all.Page, all.TotalPages, all.NextPage = 0, 0, nil
+	return all, nil
 }
 
-func (c *Client) GetProjects(query string) (*ProjectResponse, error) {
+func (c *Client) GetProjects(ctx context.Context, query string) (*ProjectResponse, error) {
 	params := map[string]string{}
 	if query != "" {
 		params["q"] = query
 	}
-	body, err := c.doRequest("/users/current/projects", params)
+	body, err := c.doRequest(ctx, "/users/current/projects", params)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp ProjectResponse
-	if err := json.Unmarshal(body, &resp); err != nil {
+	if err := c.decode("/users/current/projects", params, body, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
 
-func (c *Client) GetSummaries(start, end time.Time) (*SummaryResponse, error) {
+func (c *Client) GetSummaries(ctx context.Context, start, end time.Time) (*SummaryResponse, error) {
 	params := map[string]string{
 		"start": start.Format("2006-01-02"),
 		"end":   end.Format("2006-01-02"),
 	}
-	body, err := c.doRequest("/users/current/summaries", params)
+	body, err := c.doRequest(ctx, "/users/current/summaries", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp SummaryResponse
+	if err := c.decode("/users/current/summaries", params, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetSummariesWithProject fetches summaries filtered to a single project. Only
+// these responses include the branches and entities breakdowns.
+func (c *Client) GetSummariesWithProject(ctx context.Context, start, end time.Time, project string) (*SummaryResponse, error) {
+	params := map[string]string{
+		"start":   start.Format("2006-01-02"),
+		"end":     end.Format("2006-01-02"),
+		"project": project,
+	}
+	body, err := c.doRequest(ctx, "/users/current/summaries", params)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp SummaryResponse
-	if err := json.Unmarshal(body, &resp); err != nil {
+	if err := c.decode("/users/current/summaries", params, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetGoals fetches the goals defined on WakaTime along with their progress.
+// Accounts without access to goals get an *APIError (typically 402 or 403).
+func (c *Client) GetGoals(ctx context.Context) (*GoalsResponse, error) {
+	body, err := c.doRequest(ctx, "/users/current/goals", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp GoalsResponse
+	if err := c.decode("/users/current/goals", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetMachineNames fetches the machines that sent heartbeats, with the IDs
+// summaries and heartbeats refer to them by
+func (c *Client) GetMachineNames(ctx context.Context) (*MachineNamesResponse, error) {
+	body, err := c.doRequest(ctx, "/users/current/machine_names", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp MachineNamesResponse
+	if err := c.decode("/users/current/machine_names", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetStatusBarToday fetches today's total so far, in the timezone of the
+// WakaTime account. It is much cheaper than fetching today's heartbeats.
+func (c *Client) GetStatusBarToday(ctx context.Context) (*StatusBarResponse, error) {
+	body, err := c.doRequest(ctx, "/users/current/status_bar/today", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp StatusBarResponse
+	if err := c.decode("/users/current/status_bar/today", nil, body, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
 
-func (c *Client) GetUser() (*UserResponse, error) {
-	body, err := c.doRequest("/users/current", nil)
+func (c *Client) GetUser(ctx context.Context) (*UserResponse, error) {
+	body, err := c.doRequest(ctx, "/users/current", nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp UserResponse
-	if err := json.Unmarshal(body, &resp); err != nil {
+	if err := c.decode("/users/current", nil, body, &resp); err != nil {
 		return nil, err
 	}
 	return &resp, nil
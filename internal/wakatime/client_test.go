@@ -0,0 +1,40 @@
+package wakatime
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizationHeader(t *testing.T) {
+	const key = "waka_12345678-abcd-ef01-2345-6789abcdef01"
+	encoded := base64.StdEncoding.EncodeToString([]byte(key))
+
+	tests := []struct {
+		name   string
+		apiKey string
+	}{
+		{"plain key", key},
+		{"pre-encoded key", encoded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("Authorization")
+				w.Write([]byte(`{"data": {"id": "u"}}`))
+			}))
+			defer server.Close()
+
+			c := NewClientWithBaseURL(tt.apiKey, "", server.URL)
+			if _, err := c.GetUser(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if want := "Basic " + encoded; got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+		})
+	}
+}
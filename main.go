@@ -14,10 +14,23 @@ import (
 	"github.com/charlie0129/wakatime-sync-go/internal/config"
 	"github.com/charlie0129/wakatime-sync-go/internal/database"
 	"github.com/charlie0129/wakatime-sync-go/internal/sync"
+	"github.com/charlie0129/wakatime-sync-go/internal/version"
+	"github.com/charlie0129/wakatime-sync-go/web"
+)
+
+// Shutdown budgets: how long a running sync may take to finish its day, how
+// long in-flight requests may take after that, and how long an aborted sync
+// may take to return before the database is checkpointed
+const (
+	syncStopTimeout       = 20 * time.Second
+	serverShutdownTimeout = 10 * time.Second
+	syncExitTimeout       = 10 * time.Second
 )
 
 func main() {
 	configPath := flag.String("config", "config.yaml", "path to config file")
+	importOffline := flag.String("import-offline", "", "import heartbeats from a wakatime-cli offline queue file, then exit")
+	dev := flag.Bool("dev", false, "serve the frontend from web/dist on disk instead of the embedded copy")
 	flag.Parse()
 
 	// Setup structured logging
@@ -34,54 +47,130 @@ func main() {
 	}
 
 	// Initialize database
-	db, err := database.New(cfg.DatabasePath)
+	db, err := database.New(cfg.DatabasePath, database.Options{
+		BusyTimeout:  cfg.DBBusyTimeout,
+		JournalMode:  cfg.DBJournalMode,
+		MaxOpenConns: cfg.DBMaxOpenConns,
+		MaxIdleConns: cfg.DBMaxIdleConns,
+	})
 	if err != nil {
 		slog.Error("failed to initialize database", "error", err)
 		os.Exit(1)
 	}
 	defer db.Close()
 
-	slog.Info("local time", "time", time.Now().In(cfg.GetTimezone()).Format(time.RFC3339))
+	// The mirror is only for redundancy, so run without it rather than not at all
+	if cfg.MirrorDatabasePath != "" {
+		if err := db.OpenMirror(cfg.MirrorDatabasePath); err != nil {
+			slog.Error("failed to initialize mirror database, continuing without it", "path", cfg.MirrorDatabasePath, "error", err)
+		}
+	}
 
 	// Initialize syncer
 	syncer := sync.NewSyncer(cfg, db)
 
+	if *importOffline != "" {
+		n, err := syncer.ImportOfflineHeartbeats(*importOffline)
+		if err != nil {
+			slog.Error("failed to import offline heartbeats", "path", *importOffline, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("offline import finished", "inserted", n)
+		return
+	}
+
+	// Verify the API key works before anything else talks to WakaTime. A failure
+	// is only logged, the stored data can still be served.
+	syncer.ValidateCredentials()
+
+	// Adopt the WakaTime account timezone if configured to do so
+	syncer.ResolveTimezone()
+
+	slog.Info("local time", "time", time.Now().In(cfg.GetTimezone()).Format(time.RFC3339))
+
 	// Start background sync scheduler
 	go syncer.StartScheduler()
 
 	// Setup HTTP server
 	handler := api.NewHandler(cfg, db, syncer)
 	mux := http.NewServeMux()
-	handler.RegisterRoutes(mux)
+	static := web.Dist()
+	if *dev {
+		static = os.DirFS("web/dist")
+	}
+	handler.RegisterRoutes(mux, static)
+
+	var h http.Handler = handler.AuthMiddleware(api.GzipMiddleware(mux))
+	h = api.BodyLimitMiddleware(cfg.MaxRequestBytes, cfg.MaxImportBytes, h)
+	if cfg.RateLimitPerMinute > 0 {
+		limiter, err := api.NewRateLimiter(cfg.RateLimitPerMinute, cfg.TrustedProxies)
+		if err != nil {
+			slog.Error("invalid rate limit config", "error", err)
+			os.Exit(1)
+		}
+		h = limiter.Middleware(h)
+		slog.Info("rate limiting api requests", "per_minute", cfg.RateLimitPerMinute, "trusted_proxies", cfg.TrustedProxies)
+	}
+	if cfg.AccessLog {
+		h = api.AccessLogMiddleware(h)
+	}
 
 	server := &http.Server{
 		Addr:         cfg.ListenAddr,
-		Handler:      corsMiddleware(mux),
+		Handler:      corsMiddleware(h),
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
 
 	// Graceful shutdown
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		<-sigCh
 
 		slog.Info("shutting down server...")
-		syncer.Stop() // Stop cron scheduler
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
 
+		// The API stays up while a sync finishes its current day. Requests get
+		// their own deadline afterwards, so a slow sync does not cut them off.
+		syncCtx, cancelSync := context.WithTimeout(context.Background(), syncStopTimeout)
+		defer cancelSync()
+		if err := syncer.Stop(syncCtx); err != nil {
+			slog.Warn("sync still running at shutdown, aborting it", "error", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
 		if err := server.Shutdown(ctx); err != nil {
 			slog.Error("server shutdown error", "error", err)
 		}
 	}()
 
-	slog.Info("server starting", "addr", cfg.ListenAddr)
+	// Event streams only end when their channel is closed
+	server.RegisterOnShutdown(syncer.CloseEvents)
+
+	slog.Info("server starting", "addr", cfg.ListenAddr, "version", version.Version)
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		slog.Error("server error", "error", err)
 		os.Exit(1)
 	}
+
+	// ListenAndServe returns as soon as shutdown starts, so wait for in-flight
+	// requests before checkpointing
+	<-shutdownDone
+
+	// A sync aborted by Stop returns once its cancelled request fails, and
+	// must not be writing while the checkpoint runs
+	ctx, cancel := context.WithTimeout(context.Background(), syncExitTimeout)
+	defer cancel()
+	if err := syncer.Wait(ctx); err != nil {
+		slog.Warn("aborted sync did not exit, skipping the checkpoint", "error", err)
+		return
+	}
+	if err := db.Checkpoint(); err != nil {
+		slog.Warn("failed to checkpoint database", "error", err)
+	}
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
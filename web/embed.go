@@ -0,0 +1,22 @@
+// Package web embeds the built frontend into the binary.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist is the output of `npm run build`. dist/.gitkeep keeps the directory
+// around, so the Go code builds before the frontend is built.
+//
+//go:embed all:dist
+var dist embed.FS
+
+// Dist returns the embedded frontend
+func Dist() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
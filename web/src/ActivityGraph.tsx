@@ -54,6 +54,7 @@ export default function ActivityGraph({ onYearChange, onDayClick }: ActivityGrap
   const [availableYears, setAvailableYears] = useState<number[]>([]);
   const [selectedYear, setSelectedYear] = useState<number>(new Date().getFullYear());
   const [yearlyData, setYearlyData] = useState<YearlyActivityDay[]>([]);
+  const [weekStartsOn, setWeekStartsOn] = useState<0 | 1>(1);
   const [loading, setLoading] = useState(true);
   const [tooltip, setTooltip] = useState<TooltipData | null>(null);
   const [dropdownOpen, setDropdownOpen] = useState(false);
@@ -82,6 +83,7 @@ export default function ActivityGraph({ onYearChange, onDayClick }: ActivityGrap
       try {
         const response = await api.getYearlyActivity(selectedYear);
         setYearlyData(response.data || []);
+        setWeekStartsOn(response.week_start === 'sunday' ? 0 : 1);
         onYearChange?.(selectedYear);
       } catch (error) {
         console.error('Failed to fetch yearly activity:', error);
@@ -119,14 +121,15 @@ export default function ActivityGraph({ onYearChange, onDayClick }: ActivityGrap
     // Generate all days of the year
     const allDays = eachDayOfInterval({ start: yearStart, end: yearEnd });
     
-    // Group by weeks (starting from Sunday, like GitHub)
+    // Group by weeks, starting on the configured week_start
     const weeksData: Array<Array<{ date: Date; data?: YearlyActivityDay; isFuture: boolean }>> = [];
+    const lastDayOfWeek = (weekStartsOn + 6) % 7;
     
-    // Find the first Sunday on or before the year start
-    const firstSunday = startOfWeek(yearStart, { weekStartsOn: 0 });
+    // Find the first day of the week on or before the year start
+    const firstWeekStart = startOfWeek(yearStart, { weekStartsOn });
     
     let currentWeek: Array<{ date: Date; data?: YearlyActivityDay; isFuture: boolean }> = [];
-    let currentDate = firstSunday;
+    let currentDate = firstWeekStart;
     
     // Fill in days before year start as empty
     while (currentDate < yearStart) {
@@ -146,8 +149,8 @@ export default function ActivityGraph({ onYearChange, onDayClick }: ActivityGrap
         isFuture,
       });
       
-      if (dayOfWeek === 6) {
-        // Saturday, end of week
+      if (dayOfWeek === lastDayOfWeek) {
+        // End of week
         weeksData.push(currentWeek);
         currentWeek = [];
       }
@@ -184,7 +187,7 @@ export default function ActivityGraph({ onYearChange, onDayClick }: ActivityGrap
       totalSeconds: totalSecs,
       activeDays: activeDaysCount,
     };
-  }, [selectedYear, yearlyData]);
+  }, [selectedYear, yearlyData, weekStartsOn]);
 
   const handleMouseEnter = (
     e: React.MouseEvent,
@@ -267,13 +270,14 @@ export default function ActivityGraph({ onYearChange, onDayClick }: ActivityGrap
                 {/* Day of week labels */}
                 <div className="day-labels">
                   <span className="month-spacer"></span>
-                  <span></span>
+                  {weekStartsOn === 0 && <span></span>}
                   <span>Mon</span>
                   <span></span>
                   <span>Wed</span>
                   <span></span>
                   <span>Fri</span>
                   <span></span>
+                  {weekStartsOn === 1 && <span></span>}
                 </div>
                 
                 {/* Weeks grid with month labels above */}
@@ -197,7 +197,7 @@ function App() {
         <div className="header-right">
           <div className="sync-status">
             <div className="sync-status-dot" />
-            Last synced: {lastSynced}
+            Last synced: {lastSynced || 'never'}
           </div>
         </div>
       </header>
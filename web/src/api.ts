@@ -8,6 +8,10 @@ export interface DurationData {
   language?: string;
   branch?: string;
   type?: string;
+  ai_additions?: number;
+  ai_deletions?: number;
+  human_additions?: number;
+  human_deletions?: number;
 }
 
 export interface DurationResponse {
@@ -34,6 +38,10 @@ export interface HeartbeatData {
 
 export interface HeartbeatResponse {
   data: HeartbeatData[];
+  total: number;
+  limit: number;
+  offset: number;
+  next: number | null;
   start: string;
   end: string;
   timezone: string;
@@ -44,18 +52,26 @@ export interface SummaryItem {
   total_seconds: number;
   percent: number;
   digital: string;
+  decimal: string;
   hours: number;
   minutes: number;
   seconds?: number;
   text: string;
+  color?: string; // projects only
+  project?: string; // branches and entities only
 }
 
 export interface GrandTotal {
   total_seconds: number;
   digital: string;
+  decimal: string;
   hours: number;
   minutes: number;
   text: string;
+  ai_additions: number;
+  ai_deletions: number;
+  human_additions: number;
+  human_deletions: number;
 }
 
 export interface SummaryRange {
@@ -67,6 +83,7 @@ export interface SummaryRange {
 }
 
 export interface DaySummary {
+  partial: boolean;
   grand_total: GrandTotal;
   categories: SummaryItem[];
   languages: SummaryItem[];
@@ -75,6 +92,8 @@ export interface DaySummary {
   projects: SummaryItem[];
   dependencies: SummaryItem[];
   machines: SummaryItem[];
+  branches: SummaryItem[];
+  entities: SummaryItem[];
   range: SummaryRange;
 }
 
@@ -82,6 +101,7 @@ export interface CumulativeTotal {
   seconds: number;
   text: string;
   digital: string;
+  decimal: string;
 }
 
 export interface DailyAverage {
@@ -108,10 +128,16 @@ export interface ProjectData {
   last_heartbeat_at: string;
   first_heartbeat_at: string;
   created_at: string;
+  total_seconds: number;
+  text: string;
 }
 
 export interface ProjectsResponse {
   data: ProjectData[];
+  total: number;
+  limit: number;
+  offset: number;
+  next: number | null;
 }
 
 export interface DailyStat {
@@ -132,6 +158,8 @@ export interface RangeStats {
   editors: SummaryItem[];
   operating_systems: SummaryItem[];
   projects: SummaryItem[];
+  branches: SummaryItem[];
+  entities: SummaryItem[];
   projects_daily: Array<{
     day: string;
     name: string;
@@ -141,14 +169,37 @@ export interface RangeStats {
   end: string;
 }
 
+export interface SyncLogEntry {
+  day: string;
+  synced_at: string;
+  total_seconds: number;
+  status: string;
+  error?: string;
+}
+
 export interface SyncStatus {
   last_synced_day: string;
+  last_synced_at: string;
+  last_sync_day: string;
+  last_status: string;
+  last_error: string;
+  success_days: number;
+  partial_days: number;
+  failed_days: number;
+  recent_failures: SyncLogEntry[];
+  syncing: boolean;
 }
 
 export interface AvailableYearsResponse {
   years: number[];
 }
 
+export interface ActivityYearsResponse {
+  years: number[];
+  earliest: string;
+  latest: string;
+}
+
 export interface ProjectBreakdown {
   name: string;
   total_seconds: number;
@@ -160,9 +211,19 @@ export interface YearlyActivityDay {
   projects: ProjectBreakdown[];
 }
 
+export type WeekStart = 'monday' | 'sunday';
+
 export interface YearlyActivityResponse {
   year: number;
   data: YearlyActivityDay[];
+  week_start: WeekStart;
+}
+
+export interface ActivityRangeResponse {
+  start: string;
+  end: string;
+  data: YearlyActivityDay[];
+  week_start: WeekStart;
 }
 
 class ApiClient {
@@ -193,8 +254,24 @@ class ApiClient {
     return this.fetch('/api/v1/users/current/summaries', { start, end });
   }
 
-  async getProjects(query?: string): Promise<ProjectsResponse> {
-    return this.fetch('/api/v1/users/current/projects', { q: query || '' });
+  async getTodaySummary(): Promise<SummariesResponse> {
+    return this.fetch('/api/v1/users/current/summaries', { range: 'today' });
+  }
+
+  async getProjects(
+    query?: string,
+    limit?: number,
+    offset?: number,
+    sort?: 'name' | 'created_at' | 'last_heartbeat_at' | 'total',
+    order?: 'asc' | 'desc',
+  ): Promise<ProjectsResponse> {
+    return this.fetch('/api/v1/users/current/projects', {
+      q: query || '',
+      limit: limit ? String(limit) : '',
+      offset: offset ? String(offset) : '',
+      sort: sort || '',
+      order: order || '',
+    });
   }
 
   async getDailyStats(start: string, end: string): Promise<DailyStatsResponse> {
@@ -217,6 +294,14 @@ class ApiClient {
     return this.fetch('/api/v1/stats/yearly', { year: year.toString() });
   }
 
+  async getActivityYears(): Promise<ActivityYearsResponse> {
+    return this.fetch('/api/v1/activity/years');
+  }
+
+  async getActivityRange(start: string, end: string): Promise<ActivityRangeResponse> {
+    return this.fetch('/api/v1/activity', { start, end });
+  }
+
   async triggerSync(days: number, apiKey: string): Promise<{ message: string }> {
     const url = new URL(API_BASE + '/api/v1/sync');
     url.searchParams.set('days', days.toString());
@@ -224,7 +309,9 @@ class ApiClient {
 
     const response = await fetch(url.toString(), { method: 'POST' });
     if (!response.ok) {
-      throw new Error(`Sync failed: ${response.status}`);
+      // The server explains e.g. a rejected WakaTime API key or rate limiting
+      const body = await response.json().catch(() => null);
+      throw new Error(body?.error ? `Sync failed: ${body.error}` : `Sync failed: ${response.status}`);
     }
     return response.json();
   }